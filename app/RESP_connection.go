@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type RESPConnection struct {
@@ -15,25 +18,65 @@ func NewRESPConnection(conn *TCPConnection) *RESPConnection {
 	return &RESPConnection{conn: conn, parser: NewParser()}
 }
 
-func (rc *RESPConnection) NextRESP(ctx context.Context) (RESPValue, error) {
-	resp, err := rc.parser.ParseNext()
-	if err != nil && err != io.EOF {
-		return RESPValue{}, err
-	}
+func (rc *RESPConnection) next(ctx context.Context, parse func() (RESPValue, error)) (RESPValue, error) {
+	for {
+		resp, err := parse()
+		if err != io.EOF {
+			return resp, err
+		}
 
-	if err == io.EOF {
 		input, err := rc.conn.Read(ctx)
 		if err != nil {
 			return RESPValue{}, err
 		}
 
-		resp, err = rc.parser.ParseInput(input)
-		if err != nil {
-			return RESPValue{}, err
+		rc.parser.Feed(input)
+	}
+}
+
+func (rc *RESPConnection) NextRESP(ctx context.Context) (RESPValue, error) {
+	return rc.next(ctx, rc.parser.ParseNext)
+}
+
+// NextTransfer reads the header of an RDB transfer and returns a reader of its
+// body. The body is sent like a bulk string without the trailing CRLF, or
+// when its length isn't known upfront, as $EOF:<mark> followed by the body
+// and the mark.
+func (rc *RESPConnection) NextTransfer(ctx context.Context) (io.Reader, error) {
+	resp, err := rc.next(ctx, rc.parser.ParseNextLine)
+	if err != nil {
+		return nil, err
+	}
+
+	header := resp.Value.(string)
+	if mark, ok := strings.CutPrefix(header, "$EOF:"); ok && len(mark) == rdbEOFMarkLength {
+		return NewEOFMarkReader(rc, mark), nil
+	}
+
+	if !strings.HasPrefix(header, "$") {
+		return nil, fmt.Errorf("failed to parse RDB transfer: unexpected header %s", header)
+	}
+
+	size, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert RDB transfer size %s into number: %v", header[1:], err)
+	}
+
+	return io.LimitReader(rc, size), nil
+}
+
+// Read reads raw input following the values parsed so far, so a transfer can
+// be streamed from the connection instead of buffered whole.
+func (rc *RESPConnection) Read(buf []byte) (int, error) {
+	if rc.parser.Buffered() == 0 {
+		input, err := rc.conn.Read(context.Background())
+		rc.parser.Feed(input)
+		if err != nil && input == "" {
+			return 0, err
 		}
 	}
 
-	return resp, nil
+	return rc.parser.ReadRaw(buf), nil
 }
 
 func (rc *RESPConnection) NextArgs(ctx context.Context) (ParseInfo, error) {
@@ -63,6 +106,16 @@ func (rc *RESPConnection) RespondRESP(value RESPValue) error {
 	return nil
 }
 
+// Respond sends message as is, for input that is already RESP encoded.
+func (rc *RESPConnection) Respond(message string) error {
+	err := rc.conn.Write(message)
+	if err != nil {
+		return fmt.Errorf("error responding to connection: %v", err)
+	}
+
+	return nil
+}
+
 func (rc *RESPConnection) RespondRESPValues(responses []RESPValue) error {
 	for _, r := range responses {
 		err := rc.RespondRESP(r)
@@ -74,6 +127,37 @@ func (rc *RESPConnection) RespondRESPValues(responses []RESPValue) error {
 	return nil
 }
 
+// RespondRDB sends size bytes of an RDB file read from r, in the same format as
+// RDBFile values.
+func (rc *RESPConnection) RespondRDB(r io.Reader, size int64) error {
+	err := rc.conn.WriteFrom(fmt.Sprintf("$%d\r\n", size), r)
+	if err != nil {
+		return fmt.Errorf("error sending RDB file to connection: %v", err)
+	}
+
+	return nil
+}
+
+func (rc *RESPConnection) RemoteIP() string {
+	return rc.conn.RemoteIP()
+}
+
+func (rc *RESPConnection) LocalIP() string {
+	return rc.conn.LocalIP()
+}
+
+func (rc *RESPConnection) RemoteAddr() string {
+	return rc.conn.RemoteAddr()
+}
+
+func (rc *RESPConnection) LocalAddr() string {
+	return rc.conn.LocalAddr()
+}
+
+func (rc *RESPConnection) SetDeadline(t time.Time) error {
+	return rc.conn.SetDeadline(t)
+}
+
 func (rc *RESPConnection) Close() error {
 	return rc.conn.Close()
 }
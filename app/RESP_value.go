@@ -10,6 +10,16 @@ type RESPValue struct {
 	Value interface{}
 }
 
+// CommandRESP builds a command as sent by clients, an array of bulk strings.
+func CommandRESP(args ...string) RESPValue {
+	values := make([]RESPValue, len(args))
+	for i, arg := range args {
+		values[i] = RESPValue{Type: BulkString, Value: arg}
+	}
+
+	return RESPValue{Type: Array, Value: values}
+}
+
 type RESPError struct {
 	Error   string
 	Message string
@@ -35,6 +45,11 @@ const (
 	NullBulkString
 	RDBFile
 	Stream
+	NullArray
+	// Map holds its keys and values alternately.
+	Map
+	// Push is an out of band array sent to RESP3 clients.
+	Push
 )
 
 func (listHeader *RESPListHeader) ToString() string {
@@ -61,7 +76,9 @@ func RESPValuesToStrings(list []RESPValue) ([]string, error) {
 	return res, nil
 }
 
-func (rv *RESPValue) arrayToString() (string, error) {
+// arrayToString writes the elements of an aggregate after its header, made of
+// prefix and size.
+func (rv *RESPValue) arrayToString(prefix string, size int) (string, error) {
 	val := rv.Value.([]RESPValue)
 	strVals, err := RESPValuesToStrings(val)
 	if err != nil {
@@ -69,7 +86,7 @@ func (rv *RESPValue) arrayToString() (string, error) {
 	}
 
 	str := strings.Join(strVals, "")
-	return fmt.Sprintf("*%d\r\n%s", len(val), str), nil
+	return fmt.Sprintf("%s%d\r\n%s", prefix, size, str), nil
 }
 
 func (rv *RESPValue) ToString() (string, error) {
@@ -84,11 +101,17 @@ func (rv *RESPValue) ToString() (string, error) {
 	case Integer:
 		return fmt.Sprintf(":%d\r\n", rv.Value.(int)), nil
 	case Array:
-		return rv.arrayToString()
+		return rv.arrayToString("*", len(rv.Value.([]RESPValue)))
+	case Map:
+		return rv.arrayToString("%", len(rv.Value.([]RESPValue))/2)
+	case Push:
+		return rv.arrayToString(">", len(rv.Value.([]RESPValue)))
 	case Null:
 		return "_\r\n", nil
 	case NullBulkString:
 		return "$-1\r\n", nil
+	case NullArray:
+		return "*-1\r\n", nil
 	case SimpleError:
 		val := rv.Value.(RESPError)
 		return fmt.Sprintf("-%s %s\r\n", val.Error, val.Message), nil
@@ -3,12 +3,16 @@ package main
 import (
 	"bufio"
 	"context"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 type TCPConnection struct {
-	conn *net.Conn
-	io   *bufio.ReadWriter
+	conn      *net.Conn
+	io        *bufio.ReadWriter
+	writeLock sync.Mutex
 }
 
 func NewTCPConnection(conn *net.Conn) *TCPConnection {
@@ -42,13 +46,16 @@ func (conn *TCPConnection) ReadLine() (string, error) {
 }
 
 func (conn *TCPConnection) Read(ctx context.Context) (string, error) {
-	buf := make([]byte, 1024)
+	buf := make([]byte, 16*1024)
 	n, err := conn.io.Read(buf)
 
 	return string(buf[:n]), err
 }
 
 func (conn *TCPConnection) Write(message string) error {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
 	_, err := conn.io.WriteString(message)
 	if err != nil {
 		return err
@@ -58,11 +65,62 @@ func (conn *TCPConnection) Write(message string) error {
 	return err
 }
 
+// WriteFrom writes header followed by everything in r without another write
+// being interleaved between them.
+func (conn *TCPConnection) WriteFrom(header string, r io.Reader) error {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
+	_, err := conn.io.WriteString(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(conn.io.Writer, r)
+	if err != nil {
+		return err
+	}
+
+	return conn.io.Flush()
+}
+
 func (conn *TCPConnection) WriteLine(message string) error {
 	err := conn.Write(message + "\n")
 	return err
 }
 
+func (conn *TCPConnection) LocalIP() string {
+	host, _, err := net.SplitHostPort((*conn.conn).LocalAddr().String())
+	if err != nil {
+		return ""
+	}
+
+	return host
+}
+
+// SetDeadline makes reads and writes fail once t has passed.
+func (conn *TCPConnection) SetDeadline(t time.Time) error {
+	return (*conn.conn).SetDeadline(t)
+}
+
+func (conn *TCPConnection) RemoteIP() string {
+	host, _, err := net.SplitHostPort((*conn.conn).RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+
+	return host
+}
+
+// RemoteAddr returns the host and port of the peer.
+func (conn *TCPConnection) RemoteAddr() string {
+	return (*conn.conn).RemoteAddr().String()
+}
+
+func (conn *TCPConnection) LocalAddr() string {
+	return (*conn.conn).LocalAddr().String()
+}
+
 func (conn *TCPConnection) Close() error {
 	return (*conn.conn).Close()
 }
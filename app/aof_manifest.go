@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	aofTypeBase    = "b"
+	aofTypeIncr    = "i"
+	aofTypeHistory = "h"
+)
+
+type AOFFileInfo struct {
+	Name string
+	Seq  int
+	Type string
+}
+
+// AOFManifest lists the files that make up the append only file: an optional
+// base holding a snapshot of the dataset, followed by the incremental files
+// that log every write made after it.
+type AOFManifest struct {
+	Base    *AOFFileInfo
+	Incrs   []AOFFileInfo
+	History []AOFFileInfo
+}
+
+func NewAOFManifest() *AOFManifest {
+	return &AOFManifest{Base: nil, Incrs: []AOFFileInfo{}, History: []AOFFileInfo{}}
+}
+
+func parseAOFManifestLine(line string) (AOFFileInfo, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return AOFFileInfo{}, fmt.Errorf("invalid AOF manifest line: %s", line)
+	}
+
+	info := AOFFileInfo{}
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			info.Name = fields[i+1]
+		case "seq":
+			seq, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return AOFFileInfo{}, fmt.Errorf("invalid AOF manifest sequence in line: %s", line)
+			}
+			info.Seq = seq
+		case "type":
+			info.Type = fields[i+1]
+		}
+	}
+
+	if info.Name == "" || info.Type == "" {
+		return AOFFileInfo{}, fmt.Errorf("invalid AOF manifest line: %s", line)
+	}
+
+	return info, nil
+}
+
+func ParseAOFManifest(contents string) (*AOFManifest, error) {
+	manifest := NewAOFManifest()
+
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		info, err := parseAOFManifestLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch info.Type {
+		case aofTypeBase:
+			if manifest.Base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest: found duplicate base file")
+			}
+			manifest.Base = &info
+		case aofTypeIncr:
+			manifest.Incrs = append(manifest.Incrs, info)
+		case aofTypeHistory:
+			manifest.History = append(manifest.History, info)
+		default:
+			return nil, fmt.Errorf("invalid AOF manifest file type %s", info.Type)
+		}
+	}
+
+	return manifest, nil
+}
+
+func (manifest *AOFManifest) ToString() string {
+	sb := strings.Builder{}
+	files := []AOFFileInfo{}
+	if manifest.Base != nil {
+		files = append(files, *manifest.Base)
+	}
+	files = append(files, manifest.History...)
+	files = append(files, manifest.Incrs...)
+
+	for _, info := range files {
+		WriteLine(&sb, fmt.Sprintf("file %s seq %d type %s", info.Name, info.Seq, info.Type))
+	}
+
+	return sb.String()
+}
+
+func (manifest *AOFManifest) Copy() *AOFManifest {
+	copied := NewAOFManifest()
+	if manifest.Base != nil {
+		base := *manifest.Base
+		copied.Base = &base
+	}
+	copied.Incrs = append(copied.Incrs, manifest.Incrs...)
+	copied.History = append(copied.History, manifest.History...)
+
+	return copied
+}
+
+func (manifest *AOFManifest) LastIncr() (AOFFileInfo, bool) {
+	if len(manifest.Incrs) == 0 {
+		return AOFFileInfo{}, false
+	}
+
+	return manifest.Incrs[len(manifest.Incrs)-1], true
+}
+
+func (manifest *AOFManifest) NextIncrSeq() int {
+	last, ok := manifest.LastIncr()
+	if !ok {
+		return 1
+	}
+
+	return last.Seq + 1
+}
+
+func (manifest *AOFManifest) NextBaseSeq() int {
+	if manifest.Base == nil {
+		return 1
+	}
+
+	return manifest.Base.Seq + 1
+}
+
+func LoadAOFManifest(path string) (*AOFManifest, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAOFManifest(string(contents))
+}
+
+// Persist writes the manifest to a temporary file and renames it into place, so
+// readers only ever see a complete manifest.
+func (manifest *AOFManifest) Persist(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.manifest")
+	if err != nil {
+		return fmt.Errorf("failed to create temp AOF manifest: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(manifest.ToString())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp AOF manifest: %v", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temp AOF manifest: %v", err)
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to set temp AOF manifest permissions: %v", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to rename temp AOF manifest: %v", err)
+	}
+
+	return nil
+}
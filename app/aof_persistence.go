@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+func (rs *RedisServer) aofPath() (string, string) {
+	rs.ServerInfo.Persistence.Acquire()
+	defer rs.ServerInfo.Persistence.Release()
+
+	info := rs.ServerInfo.Persistence
+	return filepath.Join(info.Dir, info.AppendDirname), info.AppendFilename
+}
+
+func (rs *RedisServer) appendOnlyConfigured() bool {
+	rs.ServerInfo.Persistence.Acquire()
+	defer rs.ServerInfo.Persistence.Release()
+
+	return rs.ServerInfo.Persistence.AppendOnly
+}
+
+// loadAppendOnly rebuilds the dataset by replaying the append only file, then
+// keeps appending to it.
+func (rs *RedisServer) loadAppendOnly(ctx context.Context) error {
+	dir, filename := rs.aofPath()
+	replayer := NewRedisConnection(nil, rs)
+
+	// Commands logged between MULTI and EXEC are only applied once the EXEC
+	// is read, so a transaction cut short by a crash is left out entirely.
+	var transaction []ParseInfo
+	err := LoadAppendOnlyFile(dir, filename, rs.Database, func(resp RESPValue) error {
+		parseInfo, err := NewParser().GetArgs(resp)
+		if err != nil {
+			return fmt.Errorf("failed to replay AOF command: %v", err)
+		}
+
+		switch {
+		case parseInfo.Command == "MULTI":
+			transaction = []ParseInfo{}
+		case parseInfo.Command == "EXEC":
+			for _, queued := range transaction {
+				replayer.ResponseFromArgs(ctx, queued)
+			}
+			transaction = nil
+		case transaction != nil:
+			transaction = append(transaction, parseInfo)
+		default:
+			replayer.ResponseFromArgs(ctx, parseInfo)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if transaction != nil {
+		fmt.Printf("discarded a transaction left incomplete at the end of the append only file\n")
+	}
+
+	rs.Database.ClearDirty(rs.Database.Dirty())
+	return rs.AOF.Open(dir, filename)
+}
+
+func (rs *RedisServer) rewriteAppendOnly(snapshot *DatabaseSnapshot, incrSeq int) {
+	err := rs.AOF.FinishRewrite(snapshot, incrSeq)
+	snapshot.Release()
+	if err != nil {
+		fmt.Printf("failed to rewrite append only file: %v\n", err)
+	}
+
+	rs.AOF.EndRewrite(err)
+}
+
+// StartAppendOnly creates a new append only file whose base is written from a
+// snapshot of the current dataset in the background.
+func (rs *RedisServer) StartAppendOnly(ctx context.Context) error {
+	dir, filename := rs.aofPath()
+
+	snapshot, err := rs.snapshotAtomically(ctx, func() error {
+		err := rs.AOF.Create(dir, filename)
+		if err != nil {
+			return err
+		}
+
+		return rs.AOF.BeginRewrite()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start append only file: %v", err)
+	}
+
+	go rs.rewriteAppendOnly(snapshot, 1)
+	return nil
+}
+
+func (rs *RedisServer) StopAppendOnly() {
+	rs.AOF.Close()
+}
+
+// BackgroundRewriteAppendOnly compacts the append only file into a base written
+// from the current dataset, while writes continue to be logged to a new
+// incremental file. The rewrite is scheduled rather than started when another
+// snapshot is in progress, and runs as soon as that one is released.
+func (rs *RedisServer) BackgroundRewriteAppendOnly() (bool, error) {
+	err := rs.AOF.BeginRewrite()
+	if err != nil {
+		return false, err
+	}
+
+	scheduled := true
+	select {
+	case <-rs.Database.SnapshotReleased():
+		scheduled = false
+	default:
+	}
+
+	go func() {
+		incrSeq := 0
+		snapshot, err := rs.snapshotAtomically(context.Background(), func() error {
+			seq, err := rs.AOF.StartRewrite()
+			incrSeq = seq
+			return err
+		})
+		if err != nil {
+			fmt.Printf("failed to start append only file rewrite: %v\n", err)
+			rs.AOF.EndRewrite(err)
+			return
+		}
+
+		rs.rewriteAppendOnly(snapshot, incrSeq)
+	}()
+
+	return scheduled, nil
+}
+
+func (rs *RedisServer) appendOnlyRewriteNeeded() bool {
+	rs.ServerInfo.Persistence.Acquire()
+	percentage := rs.ServerInfo.Persistence.AutoAofRewritePercentage
+	minSize := rs.ServerInfo.Persistence.AutoAofRewriteMinSize
+	rs.ServerInfo.Persistence.Release()
+
+	return rs.AOF.RewriteNeeded(percentage, minSize)
+}
+
+// feedAppendOnly logs a write command that has been applied to the database.
+func (rs *RedisServer) feedAppendOnly(resp RESPValue) {
+	err := rs.AOF.Append(resp)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	appendFsyncAlways   = "always"
+	appendFsyncEverysec = "everysec"
+	appendFsyncNo       = "no"
+)
+
+// AppendOnlyFile logs every write command to the last incremental file listed
+// in its manifest. It is closed (incr is nil) while append only is disabled.
+type AppendOnlyFile struct {
+	dir               string
+	filename          string
+	fsync             string
+	manifest          *AOFManifest
+	incr              *os.File
+	unsynced          bool
+	currentSize       int64
+	baseSize          int64
+	rewriting         bool
+	lastWriteStatus   string
+	lastRewriteStatus string
+	lock              sync.Mutex
+}
+
+func NewAppendOnlyFile(fsync string) *AppendOnlyFile {
+	return &AppendOnlyFile{fsync: fsync, manifest: NewAOFManifest(), lastWriteStatus: "ok", lastRewriteStatus: "ok"}
+}
+
+func (aof *AppendOnlyFile) path(name string) string {
+	return filepath.Join(aof.dir, name)
+}
+
+func (aof *AppendOnlyFile) manifestPath() string {
+	return aof.path(aof.filename + ".manifest")
+}
+
+func (aof *AppendOnlyFile) baseName(seq int) string {
+	return fmt.Sprintf("%s.%d.base.rdb", aof.filename, seq)
+}
+
+func (aof *AppendOnlyFile) incrName(seq int) string {
+	return fmt.Sprintf("%s.%d.incr.aof", aof.filename, seq)
+}
+
+func (aof *AppendOnlyFile) fileSize(name string) int64 {
+	stat, err := os.Stat(aof.path(name))
+	if err != nil {
+		return 0
+	}
+
+	return stat.Size()
+}
+
+// totalSize returns the combined size of the base and incremental files.
+func (aof *AppendOnlyFile) totalSize() int64 {
+	size := int64(0)
+	if aof.manifest.Base != nil {
+		size += aof.fileSize(aof.manifest.Base.Name)
+	}
+
+	for _, incr := range aof.manifest.Incrs {
+		size += aof.fileSize(incr.Name)
+	}
+
+	return size
+}
+
+func AppendOnlyFileExists(dir string, filename string) bool {
+	_, err := os.Stat(filepath.Join(dir, filename+".manifest"))
+	return err == nil
+}
+
+func (aof *AppendOnlyFile) Enabled() bool {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	return aof.incr != nil
+}
+
+func (aof *AppendOnlyFile) SetFsync(fsync string) {
+	aof.lock.Lock()
+	aof.fsync = fsync
+	aof.lock.Unlock()
+}
+
+func (aof *AppendOnlyFile) Fsync() string {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	return aof.fsync
+}
+
+// openIncr starts appending to a new incremental file and records it in the
+// manifest before any command is written to it.
+func (aof *AppendOnlyFile) openIncr() error {
+	info := AOFFileInfo{Name: aof.incrName(aof.manifest.NextIncrSeq()), Seq: aof.manifest.NextIncrSeq(), Type: aofTypeIncr}
+
+	incr, err := os.OpenFile(aof.path(info.Name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF file %s: %v", info.Name, err)
+	}
+
+	manifest := aof.manifest.Copy()
+	manifest.Incrs = append(manifest.Incrs, info)
+	err = manifest.Persist(aof.manifestPath())
+	if err != nil {
+		incr.Close()
+		return err
+	}
+
+	aof.closeIncr()
+	aof.manifest = manifest
+	aof.incr = incr
+
+	return nil
+}
+
+func (aof *AppendOnlyFile) closeIncr() {
+	if aof.incr == nil {
+		return
+	}
+
+	aof.incr.Sync()
+	aof.incr.Close()
+	aof.incr = nil
+	aof.unsynced = false
+}
+
+// Create starts a new append only file in dir with an empty manifest.
+func (aof *AppendOnlyFile) Create(dir string, filename string) error {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create AOF directory: %v", err)
+	}
+
+	aof.dir, aof.filename = dir, filename
+	aof.manifest = NewAOFManifest()
+	aof.currentSize, aof.baseSize = 0, 0
+
+	return aof.openIncr()
+}
+
+// Open continues appending to an existing append only file.
+func (aof *AppendOnlyFile) Open(dir string, filename string) error {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	aof.dir, aof.filename = dir, filename
+	manifest, err := LoadAOFManifest(aof.manifestPath())
+	if err != nil {
+		return fmt.Errorf("failed to load AOF manifest: %v", err)
+	}
+	aof.manifest = manifest
+	aof.currentSize = aof.totalSize()
+	aof.baseSize = aof.currentSize
+
+	last, ok := manifest.LastIncr()
+	if !ok {
+		return aof.openIncr()
+	}
+
+	incr, err := os.OpenFile(aof.path(last.Name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open AOF file %s: %v", last.Name, err)
+	}
+	aof.incr = incr
+
+	return nil
+}
+
+func (aof *AppendOnlyFile) Close() {
+	aof.lock.Lock()
+	aof.closeIncr()
+	aof.lock.Unlock()
+}
+
+func (aof *AppendOnlyFile) Append(resp RESPValue) error {
+	message, err := resp.ToString()
+	if err != nil {
+		return err
+	}
+
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	if aof.incr == nil {
+		return nil
+	}
+
+	written, err := aof.incr.WriteString(message)
+	aof.currentSize += int64(written)
+	if err == nil && aof.fsync == appendFsyncAlways {
+		err = aof.incr.Sync()
+	} else if err == nil {
+		aof.unsynced = true
+	}
+
+	if err != nil {
+		aof.lastWriteStatus = "err"
+		return fmt.Errorf("failed to write to AOF: %v", err)
+	}
+
+	aof.lastWriteStatus = "ok"
+	return nil
+}
+
+// FlushToDisk fsyncs writes made since the last call when the fsync policy is
+// everysec.
+func (aof *AppendOnlyFile) FlushToDisk() error {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	if aof.incr == nil || !aof.unsynced || aof.fsync != appendFsyncEverysec {
+		return nil
+	}
+
+	aof.unsynced = false
+	return aof.incr.Sync()
+}
+
+// BeginRewrite marks a rewrite as in progress, failing if one already is.
+func (aof *AppendOnlyFile) BeginRewrite() error {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	if aof.incr == nil {
+		return fmt.Errorf("append only file is not enabled")
+	} else if aof.rewriting {
+		return fmt.Errorf("background append only file rewriting already in progress")
+	}
+
+	aof.rewriting = true
+	return nil
+}
+
+func (aof *AppendOnlyFile) EndRewrite(err error) {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	aof.rewriting = false
+	aof.lastRewriteStatus = "ok"
+	if err != nil {
+		aof.lastRewriteStatus = "err"
+	}
+}
+
+// RewriteNeeded reports whether the file has grown by at least percentage
+// since the last rewrite and is at least minSize bytes.
+func (aof *AppendOnlyFile) RewriteNeeded(percentage int, minSize int64) bool {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	if aof.incr == nil || aof.rewriting || percentage <= 0 || aof.currentSize < minSize {
+		return false
+	}
+
+	base := Max(int(aof.baseSize), 1)
+	growth := int(aof.currentSize)*100/base - 100
+	return growth >= percentage
+}
+
+// StartRewrite switches to a new incremental file and returns its sequence
+// number. Writes from here on are logged to it, while FinishRewrite replaces
+// everything before it with a base holding the dataset as of this point.
+func (aof *AppendOnlyFile) StartRewrite() (int, error) {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	if aof.incr == nil {
+		return 0, fmt.Errorf("append only file is not open")
+	}
+
+	err := aof.openIncr()
+	if err != nil {
+		return 0, err
+	}
+
+	last, _ := aof.manifest.LastIncr()
+	return last.Seq, nil
+}
+
+// FinishRewrite writes snapshot as the new base and switches to a manifest
+// listing only it and the incremental files from incrSeq onwards. The files it
+// replaces are deleted once the new manifest is in place.
+func (aof *AppendOnlyFile) FinishRewrite(snapshot *DatabaseSnapshot, incrSeq int) error {
+	aof.lock.Lock()
+	dir := aof.dir
+	base := AOFFileInfo{Name: aof.baseName(aof.manifest.NextBaseSeq()), Seq: aof.manifest.NextBaseSeq(), Type: aofTypeBase}
+	aof.lock.Unlock()
+
+	err := writeRDBFile(dir, base.Name, snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to write AOF base: %v", err)
+	}
+
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	if aof.incr == nil || aof.dir != dir {
+		os.Remove(filepath.Join(dir, base.Name))
+		return fmt.Errorf("append only file was closed during rewrite")
+	}
+
+	manifest := aof.manifest.Copy()
+	if manifest.Base != nil {
+		manifest.History = append(manifest.History, AOFFileInfo{Name: manifest.Base.Name, Seq: manifest.Base.Seq, Type: aofTypeHistory})
+	}
+	manifest.Base = &base
+
+	incrs := []AOFFileInfo{}
+	for _, incr := range manifest.Incrs {
+		if incr.Seq >= incrSeq {
+			incrs = append(incrs, incr)
+		} else {
+			manifest.History = append(manifest.History, AOFFileInfo{Name: incr.Name, Seq: incr.Seq, Type: aofTypeHistory})
+		}
+	}
+	manifest.Incrs = incrs
+
+	err = manifest.Persist(aof.manifestPath())
+	if err != nil {
+		os.Remove(filepath.Join(dir, base.Name))
+		return err
+	}
+	aof.manifest = manifest
+	aof.currentSize = aof.totalSize()
+	aof.baseSize = aof.currentSize
+
+	return aof.deleteHistory()
+}
+
+func (aof *AppendOnlyFile) deleteHistory() error {
+	for _, history := range aof.manifest.History {
+		err := os.Remove(aof.path(history.Name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete AOF history file %s: %v", history.Name, err)
+		}
+	}
+
+	manifest := aof.manifest.Copy()
+	manifest.History = []AOFFileInfo{}
+
+	err := manifest.Persist(aof.manifestPath())
+	if err != nil {
+		return err
+	}
+	aof.manifest = manifest
+
+	return nil
+}
+
+func (aof *AppendOnlyFile) ToString() string {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	sb := strings.Builder{}
+	WriteLine(&sb, fmt.Sprintf("aof_enabled:%d", boolToInt(aof.incr != nil)))
+	WriteLine(&sb, fmt.Sprintf("aof_rewrite_in_progress:%d", boolToInt(aof.rewriting)))
+	WriteLine(&sb, fmt.Sprintf("aof_last_bgrewrite_status:%s", aof.lastRewriteStatus))
+	WriteLine(&sb, fmt.Sprintf("aof_last_write_status:%s", aof.lastWriteStatus))
+	if aof.incr != nil {
+		WriteLine(&sb, fmt.Sprintf("aof_current_size:%d", aof.currentSize))
+		WriteLine(&sb, fmt.Sprintf("aof_base_size:%d", aof.baseSize))
+	}
+
+	return sb.String()
+}
+
+// replayFile feeds every command in the file at path to apply. A command cut
+// short at the end of the last file is treated as a crash mid-write: the
+// partial command is truncated away and loading carries on.
+func replayFile(path string, last bool, apply func(resp RESPValue) error) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read AOF file: %v", err)
+	}
+
+	parser := NewParser()
+	parser.Feed(string(contents))
+
+	for {
+		resp, err := parser.ParseNext()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to parse AOF file %s: %v", path, err)
+		}
+
+		err = apply(resp)
+		if err != nil {
+			return err
+		}
+	}
+
+	remaining := parser.Buffered()
+	if remaining == 0 {
+		return nil
+	}
+
+	if !last {
+		return fmt.Errorf("failed to load AOF file %s: unexpected end of file", path)
+	}
+
+	fmt.Printf("AOF file %s was truncated, discarding last %d bytes\n", path, remaining)
+	return os.Truncate(path, int64(len(contents)-remaining))
+}
+
+func isRDBFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 5)
+	_, err = io.ReadFull(file, header)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil
+	}
+
+	return string(header) == "REDIS", err
+}
+
+// LoadAppendOnlyFile replays the base and incremental files listed in the
+// manifest in dir. Base files may hold either an RDB snapshot or logged commands.
+func LoadAppendOnlyFile(dir string, filename string, database *Database, apply func(resp RESPValue) error) error {
+	manifest, err := LoadAOFManifest(filepath.Join(dir, filename+".manifest"))
+	if err != nil {
+		return fmt.Errorf("failed to load AOF manifest: %v", err)
+	}
+
+	if manifest.Base != nil {
+		path := filepath.Join(dir, manifest.Base.Name)
+		rdb, err := isRDBFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to open AOF base file: %v", err)
+		}
+
+		if rdb {
+			file, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("failed to open AOF base file: %v", err)
+			}
+
+			err = NewRDBReader(file).Load(database)
+			file.Close()
+			if err != nil {
+				return fmt.Errorf("failed to load AOF base file: %v", err)
+			}
+		} else {
+			err = replayFile(path, len(manifest.Incrs) == 0, apply)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, incr := range manifest.Incrs {
+		err = replayFile(filepath.Join(dir, incr.Name), i == len(manifest.Incrs)-1, apply)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// aofManifest reads the manifest of the append only file kept in dir.
+func aofManifest(t *testing.T, dir string) *AOFManifest {
+	t.Helper()
+
+	manifest, err := LoadAOFManifest(filepath.Join(dir, "appendonlydir", "appendonly.aof.manifest"))
+	if err != nil {
+		t.Fatalf("failed to load AOF manifest: %v", err)
+	}
+
+	return manifest
+}
+
+// aofCommand returns a command as it is logged to the append only file.
+func aofCommand(args ...string) string {
+	resp := CommandRESP(args...)
+	command, _ := resp.ToString()
+	return command
+}
+
+func TestAppendOnlyFileReloadsAfterRewrite(t *testing.T) {
+	dir := t.TempDir()
+	_, port := runTestServerIn(t, dir, "", "appendonly", "yes")
+	client := newTestClient(t, port)
+
+	waitFor(t, "the first rewrite to finish", func() bool {
+		return strings.Contains(client.do("INFO", "persistence").Value.(string), "aof_rewrite_in_progress:0")
+	})
+	client.do("SET", "before", "1")
+	first := aofManifest(t, dir)
+
+	if resp := client.do("BGREWRITEAOF"); resp.Type == SimpleError {
+		t.Fatalf("BGREWRITEAOF returned %v", resp)
+	}
+	waitFor(t, "the rewrite to finish", func() bool {
+		return aofManifest(t, dir).Base.Seq > first.Base.Seq
+	})
+	client.do("SET", "after", "2")
+
+	manifest := aofManifest(t, dir)
+	if len(manifest.Incrs) != 1 || len(manifest.History) != 0 {
+		t.Fatalf("manifest after rewrite is:\n%s", manifest.ToString())
+	}
+
+	for _, old := range append([]AOFFileInfo{*first.Base}, first.Incrs...) {
+		_, err := os.Stat(filepath.Join(dir, "appendonlydir", old.Name))
+		if !os.IsNotExist(err) {
+			t.Fatalf("file %s replaced by the rewrite still exists", old.Name)
+		}
+	}
+
+	_, port = runTestServerIn(t, dir, "", "appendonly", "yes")
+	restarted := newTestClient(t, port)
+	if resp := restarted.do("GET", "before"); !isBulk(resp, "1") {
+		t.Fatalf("key written before the rewrite reloaded as %v", resp)
+	}
+	if resp := restarted.do("GET", "after"); !isBulk(resp, "2") {
+		t.Fatalf("key written after the rewrite reloaded as %v", resp)
+	}
+}
+
+func TestAppendOnlyFileDropsIncompleteTransaction(t *testing.T) {
+	dir := t.TempDir()
+	_, port := runTestServerIn(t, dir, "", "appendonly", "yes")
+	client := newTestClient(t, port)
+
+	client.do("SET", "plain", "1")
+	client.pipeline([]string{"MULTI"}, []string{"SET", "committed", "1"}, []string{"EXEC"})
+
+	// A crash mid transaction leaves its MULTI and some of its commands,
+	// the last of which may be cut short.
+	incr, _ := aofManifest(t, dir).LastIncr()
+	path := filepath.Join(dir, "appendonlydir", incr.Name)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open AOF file: %v", err)
+	}
+	complete, cut, multi := aofCommand("SET", "uncommitted", "1"), aofCommand("SET", "cut", "1"), aofCommand("MULTI")
+	file.WriteString(multi + complete + cut[:len(cut)-4])
+	file.Close()
+
+	_, port = runTestServerIn(t, dir, "", "appendonly", "yes")
+	restarted := newTestClient(t, port)
+	for key, want := range map[string]string{"plain": "1", "committed": "1"} {
+		if resp := restarted.do("GET", key); !isBulk(resp, want) {
+			t.Fatalf("GET %s after replay returned %v", key, resp)
+		}
+	}
+	for _, key := range []string{"uncommitted", "cut"} {
+		if resp := restarted.do("GET", key); resp.Type != NullBulkString {
+			t.Fatalf("GET %s from the incomplete transaction returned %v", key, resp)
+		}
+	}
+
+	contents, _ := os.ReadFile(path)
+	if strings.HasSuffix(string(contents), cut[:len(cut)-4]) {
+		t.Fatalf("the command cut short was left in the AOF file")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	pauseNone = iota
+	pauseWrite
+	pauseAll
+)
+
+// ClientPause holds back the commands of clients while CLIENT PAUSE is in
+// effect: writes only, or every command.
+type ClientPause struct {
+	mode    int
+	until   time.Time
+	resumed chan struct{}
+	lock    sync.Mutex
+}
+
+func NewClientPause() *ClientPause {
+	return &ClientPause{resumed: make(chan struct{})}
+}
+
+// Pause pauses clients until until. A pause already in effect is only ever
+// extended, in time or to every command.
+func (cp *ClientPause) Pause(mode int, until time.Time) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	if cp.mode == pauseNone || time.Now().After(cp.until) {
+		cp.mode, cp.until = mode, until
+		return
+	}
+
+	cp.mode = max(cp.mode, mode)
+	if until.After(cp.until) {
+		cp.until = until
+	}
+}
+
+func (cp *ClientPause) Unpause() {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	cp.mode = pauseNone
+	close(cp.resumed)
+	cp.resumed = make(chan struct{})
+}
+
+// Wait returns once a command, a write when write is set, is no longer
+// paused.
+func (cp *ClientPause) Wait(ctx context.Context, write bool) {
+	for {
+		cp.lock.Lock()
+		paused := cp.mode == pauseAll || (cp.mode == pauseWrite && write)
+		remaining := time.Until(cp.until)
+		resumed := cp.resumed
+		cp.lock.Unlock()
+
+		if !paused || remaining <= 0 {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-resumed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// ClientRegistry keeps the connected clients by id. Ids are never reused.
+type ClientRegistry struct {
+	clients map[int]*RedisConnection
+	nextID  int
+	lock    sync.RWMutex
+}
+
+func NewClientRegistry() *ClientRegistry {
+	return &ClientRegistry{clients: map[int]*RedisConnection{}, nextID: 1}
+}
+
+// Register gives rc the next id and adds it to the registry.
+func (cr *ClientRegistry) Register(rc *RedisConnection) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	rc.id = cr.nextID
+	cr.nextID += 1
+	cr.clients[rc.id] = rc
+}
+
+func (cr *ClientRegistry) Unregister(rc *RedisConnection) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	delete(cr.clients, rc.id)
+}
+
+func (cr *ClientRegistry) Get(id int) (*RedisConnection, bool) {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	rc, ok := cr.clients[id]
+	return rc, ok
+}
+
+// List returns the registered clients in the order they connected.
+func (cr *ClientRegistry) List() []*RedisConnection {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+
+	clients := []*RedisConnection{}
+	for _, rc := range cr.clients {
+		clients = append(clients, rc)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+	return clients
+}
@@ -2,38 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"sync"
+	"net"
 )
 
+// Clients hands the connections the server accepts over to be served.
 type Clients struct {
-	connections []*RedisConnection
-	lock        sync.Mutex
+	accepted chan *RedisConnection
+}
+
+func NewClients() *Clients {
+	return &Clients{accepted: make(chan *RedisConnection)}
 }
 
 func (c *Clients) Add(conn *RedisConnection) {
-	c.lock.Lock()
-	c.connections = append(c.connections, conn)
-	c.lock.Unlock()
+	c.accepted <- conn
 }
 
 func handleClient(ctx context.Context, conn *RedisConnection) {
 	err := conn.HandleRequests(ctx)
-	if err != nil && err != io.EOF {
+	if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
 		fmt.Printf("failed to handle client requests: %v\n", err)
 		conn.Close()
 	}
 }
 
+// HandleAll serves every connection added until ctx is done.
 func (c *Clients) HandleAll(ctx context.Context) {
-	c.lock.Lock()
-
-	for _, client := range c.connections {
-		go handleClient(ctx, client)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case client := <-c.accepted:
+			go handleClient(ctx, client)
+		}
 	}
-
-	c.connections = []*RedisConnection{}
-
-	c.lock.Unlock()
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// commandWrite marks commands that may modify the dataset. They are run
+	// under the server's write lock and propagated to replicas and the AOF.
+	commandWrite = 1 << iota
+	// commandRead marks commands that read the dataset, or the replication
+	// state written along with it. They run under the write lock shared with
+	// other reads, so they see the writes of a transaction or script either
+	// all applied or not at all.
+	commandRead
+	// commandStale marks commands a replica still serves while its link to the
+	// master is down and replica-serve-stale-data is off.
+	commandStale
+	// commandNoMulti marks commands that can't be queued in a transaction,
+	// mostly because they wait on replication or take the write lock.
+	commandNoMulti
+	// commandMayReplicate marks commands that run under the write lock like
+	// writes, but only propagate the writes they end up making, as scripts do.
+	commandMayReplicate
+	// commandNoScript marks commands scripts can't call.
+	commandNoScript
+	// commandAllowBusy marks commands that still run while a script has been
+	// running for longer than the busy threshold.
+	commandAllowBusy
+	// commandSubscribed marks commands a client subscribed to channels or
+	// patterns may still call.
+	commandSubscribed
+	// commandNoMonitor marks administrative commands MONITOR doesn't show.
+	commandNoMonitor
+)
+
+// Command describes a command the server understands. Arity counts the
+// command name itself, and a negative arity means at least that many
+// arguments. A command with subcommands is described by the subcommand named
+// by its first argument instead, whose arity also counts the command name.
+// The keys of a command are its arguments from FirstKey to LastKey, every
+// KeyStep, where the command name is at 0 and a negative LastKey counts back
+// from the last argument.
+type Command struct {
+	Name        string
+	Arity       int
+	Flags       int
+	FirstKey    int
+	LastKey     int
+	KeyStep     int
+	Handler     func(rc *RedisConnection, ctx context.Context, parseInfo ParseInfo) []RESPValue
+	Subcommands map[string]Command
+}
+
+var commandTable map[string]Command
+
+func init() {
+	commandTable = map[string]Command{}
+	for _, command := range []Command{
+		{Name: "PING", Arity: -1, Flags: commandStale | commandSubscribed, Handler: (*RedisConnection).responsePING},
+		{Name: "ECHO", Arity: 2, Handler: (*RedisConnection).responseECHO},
+		{Name: "GET", Arity: 2, Flags: commandRead, FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: (*RedisConnection).responseGET},
+		{Name: "SET", Arity: -3, Flags: commandWrite, FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: (*RedisConnection).responseSET},
+		{Name: "DEL", Arity: -2, Flags: commandWrite, FirstKey: 1, LastKey: -1, KeyStep: 1, Handler: (*RedisConnection).responseDEL},
+		{Name: "INFO", Arity: -1, Flags: commandRead | commandStale, Handler: (*RedisConnection).responseINFO},
+		{Name: "REPLCONF", Arity: -1, Flags: commandStale | commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseREPLCONF},
+		{Name: "PSYNC", Arity: -3, Flags: commandStale | commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responsePSYNC},
+		{Name: "WAIT", Arity: 3, Flags: commandNoMulti | commandNoScript, Handler: (*RedisConnection).responseWAIT},
+		{Name: "CONFIG", Arity: -2, Flags: commandStale | commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseCONFIG},
+		{Name: "TYPE", Arity: 2, Flags: commandRead, FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: (*RedisConnection).responseTYPE},
+		{Name: "XADD", Arity: -5, Flags: commandWrite, FirstKey: 1, LastKey: 1, KeyStep: 1, Handler: (*RedisConnection).responseXADD},
+		{Name: "SAVE", Arity: 1, Flags: commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseSAVE},
+		{Name: "BGSAVE", Arity: -1, Flags: commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseBGSAVE},
+		{Name: "LASTSAVE", Arity: 1, Handler: (*RedisConnection).responseLASTSAVE},
+		{Name: "SHUTDOWN", Arity: -1, Flags: commandStale | commandNoMulti | commandNoScript | commandAllowBusy | commandNoMonitor, Handler: (*RedisConnection).responseSHUTDOWN},
+		{Name: "REPLICAOF", Arity: 3, Flags: commandStale | commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseREPLICAOF},
+		{Name: "SLAVEOF", Arity: 3, Flags: commandStale | commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseREPLICAOF},
+		{Name: "FAILOVER", Arity: -1, Flags: commandStale | commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseFAILOVER},
+		{Name: "MULTI", Arity: 1, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).responseMULTI},
+		{Name: "EXEC", Arity: 1, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).responseEXEC},
+		{Name: "DISCARD", Arity: 1, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).responseDISCARD},
+		{Name: "WATCH", Arity: -2, Flags: commandStale | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responseWATCH},
+		{Name: "UNWATCH", Arity: 1, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).responseUNWATCH},
+		{Name: "FLUSHDB", Arity: -1, Flags: commandWrite, Handler: (*RedisConnection).responseFLUSHDB},
+		{Name: "FLUSHALL", Arity: -1, Flags: commandWrite, Handler: (*RedisConnection).responseFLUSHDB},
+		{Name: "BGREWRITEAOF", Arity: 1, Flags: commandNoMulti | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).responseBGREWRITEAOF},
+		{Name: "EVAL", Arity: -3, Flags: commandMayReplicate | commandNoScript, Handler: (*RedisConnection).responseEVAL},
+		{Name: "EVALSHA", Arity: -3, Flags: commandMayReplicate | commandNoScript, Handler: (*RedisConnection).responseEVALSHA},
+		{Name: "SCRIPT", Arity: -2, Subcommands: subcommandTable(
+			Command{Name: "SCRIPT|LOAD", Arity: 3, Flags: commandNoScript, Handler: (*RedisConnection).scriptLOAD},
+			Command{Name: "SCRIPT|EXISTS", Arity: -3, Flags: commandNoScript, Handler: (*RedisConnection).scriptEXISTS},
+			Command{Name: "SCRIPT|FLUSH", Arity: -2, Flags: commandNoScript, Handler: (*RedisConnection).scriptFLUSH},
+			Command{Name: "SCRIPT|KILL", Arity: 2, Flags: commandNoScript | commandAllowBusy, Handler: (*RedisConnection).scriptKILL},
+		)},
+		{Name: "FCALL", Arity: -3, Flags: commandMayReplicate | commandNoScript, Handler: (*RedisConnection).responseFCALL},
+		{Name: "FCALL_RO", Arity: -3, Flags: commandMayReplicate | commandNoScript, Handler: (*RedisConnection).responseFCALL},
+		{Name: "FUNCTION", Arity: -2, Subcommands: subcommandTable(
+			Command{Name: "FUNCTION|LOAD", Arity: -3, Flags: commandWrite | commandNoScript, Handler: (*RedisConnection).functionLOAD},
+			Command{Name: "FUNCTION|DELETE", Arity: 3, Flags: commandWrite | commandNoScript, Handler: (*RedisConnection).functionDELETE},
+			Command{Name: "FUNCTION|FLUSH", Arity: -2, Flags: commandWrite | commandNoScript, Handler: (*RedisConnection).functionFLUSH},
+			Command{Name: "FUNCTION|RESTORE", Arity: -3, Flags: commandWrite | commandNoScript, Handler: (*RedisConnection).functionRESTORE},
+			Command{Name: "FUNCTION|DUMP", Arity: 2, Flags: commandNoScript, Handler: (*RedisConnection).functionDUMP},
+			Command{Name: "FUNCTION|LIST", Arity: -2, Flags: commandNoScript, Handler: (*RedisConnection).functionLIST},
+			Command{Name: "FUNCTION|STATS", Arity: 2, Flags: commandNoScript | commandAllowBusy, Handler: (*RedisConnection).functionSTATS},
+			Command{Name: "FUNCTION|KILL", Arity: 2, Flags: commandNoScript | commandAllowBusy, Handler: (*RedisConnection).functionKILL},
+		)},
+		{Name: "QUIT", Arity: -1, Flags: commandStale | commandSubscribed | commandAllowBusy | commandNoScript, Handler: (*RedisConnection).responseQUIT},
+		{Name: "SUBSCRIBE", Arity: -2, Flags: commandStale | commandSubscribed | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responseSUBSCRIBE},
+		{Name: "UNSUBSCRIBE", Arity: -1, Flags: commandStale | commandSubscribed | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responseUNSUBSCRIBE},
+		{Name: "PSUBSCRIBE", Arity: -2, Flags: commandStale | commandSubscribed | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responsePSUBSCRIBE},
+		{Name: "PUNSUBSCRIBE", Arity: -1, Flags: commandStale | commandSubscribed | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responsePUNSUBSCRIBE},
+		{Name: "SSUBSCRIBE", Arity: -2, Flags: commandStale | commandSubscribed | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responseSSUBSCRIBE},
+		{Name: "SUNSUBSCRIBE", Arity: -1, Flags: commandStale | commandSubscribed | commandNoMulti | commandNoScript, Handler: (*RedisConnection).responseSUNSUBSCRIBE},
+		{Name: "PUBLISH", Arity: 3, Flags: commandStale, Handler: (*RedisConnection).responsePUBLISH},
+		{Name: "SPUBLISH", Arity: 3, Flags: commandStale, Handler: (*RedisConnection).responseSPUBLISH},
+		{Name: "PUBSUB", Arity: -2, Subcommands: subcommandTable(
+			Command{Name: "PUBSUB|CHANNELS", Arity: -2, Flags: commandStale, Handler: (*RedisConnection).pubsubCHANNELS},
+			Command{Name: "PUBSUB|NUMSUB", Arity: -2, Flags: commandStale, Handler: (*RedisConnection).pubsubNUMSUB},
+			Command{Name: "PUBSUB|NUMPAT", Arity: 2, Flags: commandStale, Handler: (*RedisConnection).pubsubNUMPAT},
+			Command{Name: "PUBSUB|SHARDCHANNELS", Arity: -2, Flags: commandStale, Handler: (*RedisConnection).pubsubSHARDCHANNELS},
+			Command{Name: "PUBSUB|SHARDNUMSUB", Arity: -2, Flags: commandStale, Handler: (*RedisConnection).pubsubSHARDNUMSUB},
+		)},
+		{Name: "MONITOR", Arity: 1, Flags: commandStale | commandNoMulti | commandNoScript | commandAllowBusy | commandNoMonitor, Handler: (*RedisConnection).responseMONITOR},
+		{Name: "HELLO", Arity: -1, Flags: commandStale | commandNoScript | commandAllowBusy, Handler: (*RedisConnection).responseHELLO},
+		{Name: "CLIENT", Arity: -2, Subcommands: subcommandTable(
+			Command{Name: "CLIENT|ID", Arity: 2, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientID},
+			Command{Name: "CLIENT|SETNAME", Arity: 3, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientSETNAME},
+			Command{Name: "CLIENT|GETNAME", Arity: 2, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientGETNAME},
+			Command{Name: "CLIENT|LIST", Arity: -2, Flags: commandStale | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).clientLIST},
+			Command{Name: "CLIENT|INFO", Arity: 2, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientINFO},
+			Command{Name: "CLIENT|KILL", Arity: -3, Flags: commandStale | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).clientKILL},
+			Command{Name: "CLIENT|PAUSE", Arity: -3, Flags: commandStale | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).clientPAUSE},
+			Command{Name: "CLIENT|UNPAUSE", Arity: 2, Flags: commandStale | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).clientUNPAUSE},
+			Command{Name: "CLIENT|NO-EVICT", Arity: 3, Flags: commandStale | commandNoScript | commandNoMonitor, Handler: (*RedisConnection).clientNOEVICT},
+			Command{Name: "CLIENT|REPLY", Arity: 3, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientREPLY},
+			Command{Name: "CLIENT|TRACKING", Arity: -3, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientTRACKING},
+			Command{Name: "CLIENT|CACHING", Arity: 3, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientCACHING},
+			Command{Name: "CLIENT|GETREDIR", Arity: 2, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientGETREDIR},
+			Command{Name: "CLIENT|TRACKINGINFO", Arity: 2, Flags: commandStale | commandNoScript, Handler: (*RedisConnection).clientTRACKINGINFO},
+		)},
+	} {
+		commandTable[command.Name] = command
+	}
+}
+
+// subcommandTable maps the subcommands of a command by the name that follows
+// the "|" in their own names.
+func subcommandTable(commands ...Command) map[string]Command {
+	table := map[string]Command{}
+	for _, command := range commands {
+		_, name, _ := strings.Cut(command.Name, "|")
+		table[name] = command
+	}
+
+	return table
+}
+
+// lookupCommand finds the command parseInfo calls, or the subcommand it calls
+// for commands that have subcommands.
+func lookupCommand(parseInfo ParseInfo) (Command, bool) {
+	command, ok := commandTable[strings.ToUpper(parseInfo.Command)]
+	if !ok || command.Subcommands == nil || len(parseInfo.Args) == 0 {
+		return command, ok
+	}
+
+	name, ok := parseInfo.Args[0].Value.(string)
+	if !ok {
+		return Command{}, false
+	}
+
+	command, ok = command.Subcommands[strings.ToUpper(name)]
+	return command, ok
+}
+
+func (command Command) HasFlag(flag int) bool {
+	return command.Flags&flag != 0
+}
+
+// Keys returns the keys a call with args (not counting the command name)
+// names.
+func (command Command) Keys(args []RESPValue) []string {
+	if command.FirstKey == 0 {
+		return nil
+	}
+
+	last := command.LastKey
+	if last < 0 {
+		last += len(args) + 1
+	}
+
+	keys := []string{}
+	for i := command.FirstKey; i <= last && i <= len(args); i += command.KeyStep {
+		keys = append(keys, args[i-1].Value.(string))
+	}
+
+	return keys
+}
+
+// CheckArity reports whether a call with args (not counting the command name)
+// has an acceptable number of arguments.
+func (command Command) CheckArity(args []RESPValue) bool {
+	if command.Arity < 0 {
+		return len(args)+1 >= -command.Arity
+	}
+
+	return len(args)+1 == command.Arity
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ConfigParameter struct {
+	Get func(rs *RedisServer) string
+	Set func(rs *RedisServer, val string) error
+}
+
+func configParameters() map[string]ConfigParameter {
+	readOnly := replicaBoolParameter(func(rs *RedisServer) *bool { return &rs.ServerInfo.Replication.ReadOnly })
+	serveStaleData := replicaBoolParameter(func(rs *RedisServer) *bool { return &rs.ServerInfo.Replication.ServeStaleData })
+	minReplicas := replicaIntParameter(func(rs *RedisServer) *int { return &rs.ServerInfo.Replication.MinReplicas })
+	minReplicasLag := replicaIntParameter(func(rs *RedisServer) *int { return &rs.ServerInfo.Replication.MinReplicasLag })
+	replicaPriority := replicaIntParameter(func(rs *RedisServer) *int { return &rs.ServerInfo.Replication.ReplicaPriority })
+	disklessSync := replicaBoolParameter(func(rs *RedisServer) *bool { return &rs.ServerInfo.Replication.DisklessSync })
+	disklessDelay := replicaIntParameter(func(rs *RedisServer) *int { return &rs.ServerInfo.Replication.DisklessDelay })
+	busyReplyThreshold := ConfigParameter{
+		Get: func(rs *RedisServer) string {
+			return strconv.FormatInt(rs.RunningScript.Threshold().Milliseconds(), 10)
+		},
+		Set: func(rs *RedisServer, val string) error {
+			num, err := strconv.ParseInt(val, 10, 64)
+			if err != nil || num < 0 {
+				return fmt.Errorf("argument must be a non-negative integer")
+			}
+
+			rs.RunningScript.SetThreshold(time.Duration(num) * time.Millisecond)
+			return nil
+		},
+	}
+
+	return map[string]ConfigParameter{
+		"replica-read-only":        readOnly,
+		"slave-read-only":          readOnly,
+		"replica-serve-stale-data": serveStaleData,
+		"slave-serve-stale-data":   serveStaleData,
+		"min-replicas-to-write":    minReplicas,
+		"min-slaves-to-write":      minReplicas,
+		"min-replicas-max-lag":     minReplicasLag,
+		"min-slaves-max-lag":       minReplicasLag,
+		"replica-priority":         replicaPriority,
+		"slave-priority":           replicaPriority,
+		"repl-diskless-sync":       disklessSync,
+		"repl-diskless-sync-delay": disklessDelay,
+		"busy-reply-threshold":     busyReplyThreshold,
+		"lua-time-limit":           busyReplyThreshold,
+		"tracking-table-max-keys": {
+			Get: func(rs *RedisServer) string {
+				return strconv.Itoa(rs.Tracking.MaxKeys())
+			},
+			Set: func(rs *RedisServer, val string) error {
+				num, err := strconv.Atoi(val)
+				if err != nil || num < 0 {
+					return fmt.Errorf("argument must be a non-negative integer")
+				}
+
+				rs.Tracking.SetMaxKeys(num)
+				return nil
+			},
+		},
+		"notify-keyspace-events": {
+			Get: func(rs *RedisServer) string {
+				return keyspaceEventsString(rs.Notifier.Flags())
+			},
+			Set: func(rs *RedisServer, val string) error {
+				flags, err := parseKeyspaceEvents(val)
+				if err != nil {
+					return err
+				}
+
+				rs.Notifier.SetFlags(flags)
+				return nil
+			},
+		},
+		"repl-diskless-load": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Replication.Acquire()
+				defer rs.ServerInfo.Replication.Release()
+				return rs.ServerInfo.Replication.DisklessLoad
+			},
+			Set: func(rs *RedisServer, val string) error {
+				load := strings.ToLower(val)
+				if load != disklessLoadDisabled && load != disklessLoadOnEmptyDB && load != disklessLoadSwapDB {
+					return fmt.Errorf("argument must be one of disabled, on-empty-db or swapdb")
+				}
+
+				rs.ServerInfo.Replication.Acquire()
+				rs.ServerInfo.Replication.DisklessLoad = load
+				rs.ServerInfo.Replication.Release()
+				return nil
+			},
+		},
+		"dir": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Persistence.Acquire()
+				defer rs.ServerInfo.Persistence.Release()
+				return rs.ServerInfo.Persistence.Dir
+			},
+			Set: func(rs *RedisServer, val string) error {
+				stat, err := os.Stat(val)
+				if err != nil || !stat.IsDir() {
+					return fmt.Errorf("no such directory %s", val)
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.Dir = val
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		"dbfilename": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Persistence.Acquire()
+				defer rs.ServerInfo.Persistence.Release()
+				return rs.ServerInfo.Persistence.Dbfilename
+			},
+			Set: func(rs *RedisServer, val string) error {
+				if val == "" || strings.ContainsRune(val, os.PathSeparator) {
+					return fmt.Errorf("dbfilename can't be a path, just a filename")
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.Dbfilename = val
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		"save": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Persistence.Acquire()
+				defer rs.ServerInfo.Persistence.Release()
+				return FormatSavePoints(rs.ServerInfo.Persistence.SavePoints)
+			},
+			Set: func(rs *RedisServer, val string) error {
+				savePoints, err := ParseSavePoints(val)
+				if err != nil {
+					return err
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.SavePoints = savePoints
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		"appendonly": {
+			Get: func(rs *RedisServer) string {
+				return formatYesNo(rs.appendOnlyConfigured())
+			},
+			Set: func(rs *RedisServer, val string) error {
+				appendOnly, err := parseYesNo(val)
+				if err != nil {
+					return err
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.AppendOnly = appendOnly
+				rs.ServerInfo.Persistence.Release()
+
+				if !rs.loaded {
+					return nil
+				} else if appendOnly && !rs.AOF.Enabled() {
+					return rs.StartAppendOnly(context.Background())
+				} else if !appendOnly {
+					rs.StopAppendOnly()
+				}
+				return nil
+			},
+		},
+		"appendfsync": {
+			Get: func(rs *RedisServer) string {
+				return rs.AOF.Fsync()
+			},
+			Set: func(rs *RedisServer, val string) error {
+				fsync := strings.ToLower(val)
+				if fsync != appendFsyncAlways && fsync != appendFsyncEverysec && fsync != appendFsyncNo {
+					return fmt.Errorf("argument must be one of always, everysec or no")
+				}
+
+				rs.AOF.SetFsync(fsync)
+				return nil
+			},
+		},
+		"appendfilename": {
+			Get: func(rs *RedisServer) string {
+				_, filename := rs.aofPath()
+				return filename
+			},
+			Set: func(rs *RedisServer, val string) error {
+				if rs.loaded {
+					return fmt.Errorf("can't set immutable config")
+				} else if val == "" || strings.ContainsRune(val, os.PathSeparator) {
+					return fmt.Errorf("appendfilename can't be a path, just a filename")
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.AppendFilename = val
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		"appenddirname": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Persistence.Acquire()
+				defer rs.ServerInfo.Persistence.Release()
+				return rs.ServerInfo.Persistence.AppendDirname
+			},
+			Set: func(rs *RedisServer, val string) error {
+				if rs.loaded {
+					return fmt.Errorf("can't set immutable config")
+				} else if val == "" || strings.ContainsRune(val, os.PathSeparator) {
+					return fmt.Errorf("appenddirname can't be a path, just a dirname")
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.AppendDirname = val
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		"auto-aof-rewrite-percentage": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Persistence.Acquire()
+				defer rs.ServerInfo.Persistence.Release()
+				return strconv.Itoa(rs.ServerInfo.Persistence.AutoAofRewritePercentage)
+			},
+			Set: func(rs *RedisServer, val string) error {
+				percentage, err := strconv.Atoi(val)
+				if err != nil || percentage < 0 {
+					return fmt.Errorf("argument must be a non-negative integer")
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.AutoAofRewritePercentage = percentage
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		"auto-aof-rewrite-min-size": {
+			Get: func(rs *RedisServer) string {
+				rs.ServerInfo.Persistence.Acquire()
+				defer rs.ServerInfo.Persistence.Release()
+				return strconv.FormatInt(rs.ServerInfo.Persistence.AutoAofRewriteMinSize, 10)
+			},
+			Set: func(rs *RedisServer, val string) error {
+				minSize, err := parseMemory(val)
+				if err != nil {
+					return err
+				}
+
+				rs.ServerInfo.Persistence.Acquire()
+				rs.ServerInfo.Persistence.AutoAofRewriteMinSize = minSize
+				rs.ServerInfo.Persistence.Release()
+				return nil
+			},
+		},
+		// The backlog is fed under the write lock, so it is resized under it.
+		"repl-backlog-size": {
+			Get: func(rs *RedisServer) string {
+				rs.writeLock.RLock()
+				defer rs.writeLock.RUnlock()
+				return strconv.Itoa(rs.ServerInfo.Replication.ReplBacklogSize)
+			},
+			Set: func(rs *RedisServer, val string) error {
+				size, err := parseMemory(val)
+				if err != nil {
+					return err
+				}
+
+				rs.writeLock.Lock()
+				defer rs.writeLock.Unlock()
+
+				backlogSize := Max(int(size), minReplBacklogSize)
+				rs.ServerInfo.Replication.ReplBacklogSize = backlogSize
+				if rs.ServerInfo.Replication.Backlog != nil {
+					rs.ServerInfo.Replication.Backlog.Resize(backlogSize)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// replicaIntParameter is a non-negative replication parameter.
+func replicaIntParameter(field func(rs *RedisServer) *int) ConfigParameter {
+	return ConfigParameter{
+		Get: func(rs *RedisServer) string {
+			rs.ServerInfo.Replication.Acquire()
+			defer rs.ServerInfo.Replication.Release()
+			return strconv.Itoa(*field(rs))
+		},
+		Set: func(rs *RedisServer, val string) error {
+			num, err := strconv.Atoi(val)
+			if err != nil || num < 0 {
+				return fmt.Errorf("argument must be a non-negative integer")
+			}
+
+			rs.ServerInfo.Replication.Acquire()
+			*field(rs) = num
+			rs.ServerInfo.Replication.Release()
+			return nil
+		},
+	}
+}
+
+// replicaBoolParameter is a yes/no replication parameter.
+func replicaBoolParameter(field func(rs *RedisServer) *bool) ConfigParameter {
+	return ConfigParameter{
+		Get: func(rs *RedisServer) string {
+			rs.ServerInfo.Replication.Acquire()
+			defer rs.ServerInfo.Replication.Release()
+			return formatYesNo(*field(rs))
+		},
+		Set: func(rs *RedisServer, val string) error {
+			enabled, err := parseYesNo(val)
+			if err != nil {
+				return err
+			}
+
+			rs.ServerInfo.Replication.Acquire()
+			*field(rs) = enabled
+			rs.ServerInfo.Replication.Release()
+			return nil
+		},
+	}
+}
+
+// parseMemory parses a byte count with an optional unit such as 64mb or 1gb.
+func parseMemory(val string) (int64, error) {
+	lower := strings.ToLower(val)
+	units := []Pair{{Key: "gb", Val: "1073741824"}, {Key: "mb", Val: "1048576"}, {Key: "kb", Val: "1024"}, {Key: "g", Val: "1000000000"}, {Key: "m", Val: "1000000"}, {Key: "k", Val: "1000"}, {Key: "b", Val: "1"}}
+
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.Key) {
+			lower = strings.TrimSuffix(lower, unit.Key)
+			multiplier, _ = strconv.ParseInt(unit.Val, 10, 64)
+			break
+		}
+	}
+
+	num, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || num < 0 {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+
+	return num * multiplier, nil
+}
+
+func parseYesNo(val string) (bool, error) {
+	switch strings.ToLower(val) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+}
+
+func formatYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func (rs *RedisServer) ConfigGet(name string) (string, error) {
+	param, ok := configParameters()[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown config parameter %s", name)
+	}
+
+	return param.Get(rs), nil
+}
+
+func (rs *RedisServer) ConfigSet(name string, val string) error {
+	param, ok := configParameters()[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown config parameter %s", name)
+	}
+
+	err := param.Set(rs, val)
+	if err != nil {
+		return fmt.Errorf("failed to set config parameter %s: %v", name, err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
@@ -10,13 +11,36 @@ type ResultData struct {
 	Expiry time.Time
 }
 
+// Database stores keys in data. While a snapshot is in progress the snapshot
+// owns frozen, and writes land in data (with deletions recorded in removed, or
+// every frozen key hidden by cleared) until the snapshot is released and the
+// two are merged back together. The expiry of every key that has one is also
+// kept in expires, so expired keys can be sampled among those keys only, and
+// the keys deleted once expired are queued in expired until they are
+// propagated. The function libraries are stored alongside
+// the keys. Keyspace events are sent to notifier, and invalidations of the
+// keys clients may have cached to tracking, when they are set.
 type Database struct {
-	data map[string]ResultData
-	lock sync.RWMutex
+	data      map[string]ResultData
+	expires   map[string]time.Time
+	expired   []string
+	frozen    map[string]ResultData
+	removed   map[string]bool
+	cleared   bool
+	released  chan struct{}
+	dirty     int
+	watched   map[string]map[*WatchedKeys]bool
+	libraries FunctionLibraries
+	notifier  *KeyspaceNotifier
+	tracking  *TrackingTable
+	lock      sync.RWMutex
 }
 
 func NewDatabase() *Database {
-	return &Database{data: map[string]ResultData{}, lock: sync.RWMutex{}}
+	released := make(chan struct{})
+	close(released)
+
+	return &Database{data: map[string]ResultData{}, expires: map[string]time.Time{}, released: released, watched: map[string]map[*WatchedKeys]bool{}, libraries: FunctionLibraries{}, lock: sync.RWMutex{}}
 }
 
 func (database *Database) readerAcquire() {
@@ -35,11 +59,50 @@ func (database *Database) writerRelease() {
 	database.lock.Unlock()
 }
 
+func (database *Database) lookup(key string) (ResultData, bool) {
+	val, ok := database.data[key]
+	if ok || database.frozen == nil || database.cleared || database.removed[key] {
+		return val, ok
+	}
+
+	val, ok = database.frozen[key]
+	return val, ok
+}
+
+func (database *Database) store(key string, val ResultData) {
+	database.touch(key)
+	database.data[key] = val
+	if database.frozen != nil {
+		delete(database.removed, key)
+	}
+	if val.Expiry.IsZero() {
+		delete(database.expires, key)
+	} else {
+		database.expires[key] = val.Expiry
+	}
+	database.dirty += 1
+}
+
+func (database *Database) remove(key string) {
+	database.touch(key)
+	delete(database.data, key)
+	delete(database.expires, key)
+	if database.frozen != nil {
+		database.removed[key] = true
+	}
+	database.dirty += 1
+}
+
+func isExpired(val ResultData) bool {
+	empty := time.Time{}
+	return val.Expiry != empty && time.Now().After(val.Expiry)
+}
+
 func (database *Database) GetValue(key string) RESPValue {
 	database.deleteIfExpired(key)
 
 	database.readerAcquire()
-	val, ok := database.data[key]
+	val, ok := database.lookup(key)
 	database.readerRelease()
 
 	if !ok {
@@ -50,28 +113,356 @@ func (database *Database) GetValue(key string) RESPValue {
 }
 
 func (database *Database) deleteIfExpired(key string) {
-	val, ok := database.data[key]
+	database.readerAcquire()
+	val, ok := database.lookup(key)
+	database.readerRelease()
 
-	if ok {
-		empty := time.Time{}
-		if val.Expiry != empty && time.Now().After(val.Expiry) {
-			database.deleteKey(key)
+	if ok && isExpired(val) {
+		database.deleteExpiredKey(key)
+	}
+}
+
+func (database *Database) deleteExpiredKey(key string) {
+	database.writerAcquire()
+	val, ok := database.lookup(key)
+	expired := ok && isExpired(val)
+	if expired {
+		database.remove(key)
+		database.expired = append(database.expired, key)
+	}
+	database.writerRelease()
+
+	if expired {
+		database.invalidate([]string{key})
+		database.Notify(notifyExpired, "expired", key)
+	}
+}
+
+// ExpireCycle deletes the expired keys among a sample of the keys with an
+// expiry, sampling again while a quarter or more of the sample had expired,
+// and reports the deleted keys as expired.
+func (database *Database) ExpireCycle(sampleSize int, timeLimit time.Duration) {
+	start := time.Now()
+	for time.Since(start) < timeLimit {
+		database.writerAcquire()
+		sampled, expired := 0, []string{}
+		now := time.Now()
+		for key, expiry := range database.expires {
+			if sampled == sampleSize {
+				break
+			}
+
+			sampled += 1
+			if now.After(expiry) {
+				expired = append(expired, key)
+			}
+		}
+
+		for _, key := range expired {
+			database.remove(key)
+		}
+		database.expired = append(database.expired, expired...)
+		database.writerRelease()
+
+		database.invalidate(expired)
+		for _, key := range expired {
+			database.Notify(notifyExpired, "expired", key)
+		}
+
+		if len(expired)*4 < sampleSize {
+			return
 		}
 	}
 }
 
-func (database *Database) deleteKey(key string) {
+// TakeExpired returns the keys deleted because they expired since it was last
+// called.
+func (database *Database) TakeExpired() []string {
 	database.writerAcquire()
-	delete(database.data, key)
+	defer database.writerRelease()
+
+	expired := database.expired
+	database.expired = nil
+	return expired
+}
+
+func (database *Database) HasExpired() bool {
+	database.readerAcquire()
+	defer database.readerRelease()
+
+	return len(database.expired) > 0
+}
+
+// Delete removes key, reporting whether it existed. A key that already
+// expired is deleted as expired instead.
+func (database *Database) Delete(key string) bool {
+	database.deleteIfExpired(key)
+
+	database.writerAcquire()
+	_, ok := database.lookup(key)
+	if ok {
+		database.remove(key)
+	}
 	database.writerRelease()
+
+	return ok
 }
 
 func (database *Database) SetValue(key string, val RESPValue, expiry int) {
-	database.writerAcquire()
 	timeStamp := time.Time{}
 	if expiry != -1 {
 		timeStamp = time.Now().Add(time.Millisecond * time.Duration(expiry))
 	}
-	database.data[key] = ResultData{Value: val, Expiry: timeStamp}
+
+	database.SetResult(key, ResultData{Value: val, Expiry: timeStamp})
+}
+
+func (database *Database) SetResult(key string, val ResultData) {
+	database.writerAcquire()
+	_, existed := database.lookup(key)
+	database.store(key, val)
 	database.writerRelease()
+
+	if !existed {
+		database.Notify(notifyNew, "new", key)
+	}
+}
+
+func (database *Database) SetNotifier(notifier *KeyspaceNotifier) {
+	database.notifier = notifier
+}
+
+func (database *Database) SetTracking(tracking *TrackingTable) {
+	database.tracking = tracking
+}
+
+// invalidate sends invalidations for keys the server modified by itself, as
+// it does when keys expire.
+func (database *Database) invalidate(keys []string) {
+	if database.tracking != nil && len(keys) > 0 {
+		database.tracking.Invalidate(keys, 0)
+	}
+}
+
+func (database *Database) invalidateAll() {
+	if database.tracking != nil {
+		database.tracking.InvalidateAll()
+	}
+}
+
+// Notify sends a keyspace event about key, unless the database has no
+// notifier.
+func (database *Database) Notify(class int, event string, key string) {
+	if database.notifier != nil {
+		database.notifier.Notify(class, event, key)
+	}
+}
+
+func (database *Database) Size() int {
+	database.readerAcquire()
+	defer database.readerRelease()
+
+	return database.size()
+}
+
+func (database *Database) size() int {
+	size := len(database.data)
+	if database.frozen != nil && !database.cleared {
+		for key := range database.frozen {
+			_, ok := database.data[key]
+			if !ok && !database.removed[key] {
+				size += 1
+			}
+		}
+	}
+
+	return size
+}
+
+func (database *Database) Dirty() int {
+	database.readerAcquire()
+	defer database.readerRelease()
+
+	return database.dirty
+}
+
+func (database *Database) ClearDirty(saved int) {
+	database.writerAcquire()
+	database.dirty -= saved
+	database.writerRelease()
+}
+
+// Snapshot freezes the current contents of the database without copying them.
+// Only one snapshot may be in progress at a time, and it must be released once
+// the caller is done reading it.
+func (database *Database) Snapshot() (*DatabaseSnapshot, error) {
+	database.writerAcquire()
+	defer database.writerRelease()
+
+	if database.frozen != nil {
+		return nil, fmt.Errorf("snapshot already in progress")
+	}
+
+	database.frozen = database.data
+	database.data = map[string]ResultData{}
+	database.removed = map[string]bool{}
+	database.cleared = false
+	database.released = make(chan struct{})
+
+	return &DatabaseSnapshot{database: database, data: database.frozen, libraries: database.libraries, dirty: database.dirty}, nil
+}
+
+func (database *Database) releaseSnapshot() {
+	database.writerAcquire()
+	defer database.writerRelease()
+
+	if !database.cleared {
+		for key := range database.removed {
+			delete(database.frozen, key)
+		}
+
+		for key, val := range database.data {
+			database.frozen[key] = val
+		}
+
+		database.data = database.frozen
+	}
+
+	database.frozen = nil
+	database.removed = nil
+	database.cleared = false
+	close(database.released)
+}
+
+// SnapshotReleased returns a channel that is closed once no snapshot is in
+// progress.
+func (database *Database) SnapshotReleased() <-chan struct{} {
+	database.readerAcquire()
+	defer database.readerRelease()
+
+	return database.released
+}
+
+// Flush removes every key.
+func (database *Database) Flush() {
+	database.writerAcquire()
+	database.touchExisting()
+	database.dirty += database.size()
+	database.data = map[string]ResultData{}
+	database.expires = map[string]time.Time{}
+	if database.frozen != nil {
+		database.removed = map[string]bool{}
+		database.cleared = true
+	}
+	database.writerRelease()
+
+	database.invalidateAll()
+}
+
+// Replace swaps in the contents of other, which must not be used afterwards.
+func (database *Database) Replace(other *Database) {
+	database.writerAcquire()
+	database.touchExisting()
+	for key := range database.watched {
+		if _, ok := other.data[key]; ok {
+			database.touch(key)
+		}
+	}
+
+	database.data = other.data
+	database.expires = other.expires
+	database.libraries = other.libraries
+	if database.frozen != nil {
+		database.removed = map[string]bool{}
+		database.cleared = true
+	}
+	database.dirty += 1
+	database.writerRelease()
+
+	database.invalidateAll()
+}
+
+func (database *Database) Libraries() FunctionLibraries {
+	database.readerAcquire()
+	defer database.readerRelease()
+
+	return database.libraries
+}
+
+func (database *Database) SetLibraries(libraries FunctionLibraries) {
+	database.writerAcquire()
+	defer database.writerRelease()
+
+	database.libraries = libraries
+	database.dirty += 1
+}
+
+// Watch adds key to the keys watched, so watched is marked dirty once the key
+// is modified.
+func (database *Database) Watch(watched *WatchedKeys, key string) {
+	database.writerAcquire()
+	defer database.writerRelease()
+
+	if _, ok := watched.keys[key]; ok {
+		return
+	}
+
+	val, ok := database.lookup(key)
+	watched.keys[key] = ok && isExpired(val)
+	if database.watched[key] == nil {
+		database.watched[key] = map[*WatchedKeys]bool{}
+	}
+	database.watched[key][watched] = true
+}
+
+// Unwatch stops watching every key watched and clears its dirty mark.
+func (database *Database) Unwatch(watched *WatchedKeys) {
+	database.writerAcquire()
+	defer database.writerRelease()
+
+	for key := range watched.keys {
+		delete(database.watched[key], watched)
+		if len(database.watched[key]) == 0 {
+			delete(database.watched, key)
+		}
+	}
+
+	watched.keys = map[string]bool{}
+	watched.dirty = false
+}
+
+// WatchedModified reports whether any of the keys watched was modified, or
+// expired, since it was watched.
+func (database *Database) WatchedModified(watched *WatchedKeys) bool {
+	database.readerAcquire()
+	defer database.readerRelease()
+
+	if watched.dirty {
+		return true
+	}
+
+	for key, expiredWhenWatched := range watched.keys {
+		val, ok := database.lookup(key)
+		if ok && !expiredWhenWatched && isExpired(val) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (database *Database) touch(key string) {
+	for watched := range database.watched[key] {
+		watched.dirty = true
+	}
+}
+
+// touchExisting marks the watchers of every watched key that exists as dirty,
+// for when the whole dataset is replaced.
+func (database *Database) touchExisting() {
+	for key := range database.watched {
+		if _, ok := database.lookup(key); ok {
+			database.touch(key)
+		}
+	}
 }
@@ -0,0 +1,52 @@
+package main
+
+type DatabaseSnapshot struct {
+	database  *Database
+	data      map[string]ResultData
+	libraries FunctionLibraries
+	dirty     int
+}
+
+// ForEach visits every unexpired key in the snapshot. The frozen data is never
+// written to while the snapshot is held, so no locking is needed here.
+func (snapshot *DatabaseSnapshot) ForEach(visit func(key string, val ResultData) error) error {
+	for key, val := range snapshot.data {
+		if isExpired(val) {
+			continue
+		}
+
+		err := visit(key, val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (snapshot *DatabaseSnapshot) Libraries() FunctionLibraries {
+	return snapshot.libraries
+}
+
+func (snapshot *DatabaseSnapshot) Size() int {
+	return len(snapshot.data)
+}
+
+func (snapshot *DatabaseSnapshot) Expires() int {
+	expires := 0
+	for _, val := range snapshot.data {
+		if !val.Expiry.IsZero() {
+			expires += 1
+		}
+	}
+
+	return expires
+}
+
+func (snapshot *DatabaseSnapshot) Dirty() int {
+	return snapshot.dirty
+}
+
+func (snapshot *DatabaseSnapshot) Release() {
+	snapshot.database.releaseSnapshot()
+}
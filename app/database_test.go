@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireCycleSamplesKeysWithAnExpiry(t *testing.T) {
+	database := NewDatabase()
+	for i := 0; i < 100000; i++ {
+		database.SetValue("persistent:"+strconv.Itoa(i), RESPValue{Type: BulkString, Value: "1"}, -1)
+	}
+
+	past := time.Now().Add(-time.Second)
+	for i := 0; i < 10; i++ {
+		database.SetResult("volatile:"+strconv.Itoa(i), ResultData{Value: RESPValue{Type: BulkString, Value: "1"}, Expiry: past})
+	}
+
+	database.SetResult("persisted", ResultData{Value: RESPValue{Type: BulkString, Value: "1"}, Expiry: past})
+	database.SetValue("persisted", RESPValue{Type: BulkString, Value: "2"}, -1)
+
+	database.ExpireCycle(expireCycleSampleSize, expireCycleTimeLimit)
+
+	if size := database.Size(); size != 100001 {
+		t.Fatalf("database has %d keys after the expire cycle, want 100001", size)
+	}
+
+	if len(database.expires) != 0 {
+		t.Fatalf("%d keys are still indexed as having an expiry", len(database.expires))
+	}
+
+	if val := database.GetValue("persisted"); val.Value != "2" {
+		t.Fatalf("key set again without an expiry was %v", val)
+	}
+}
+
+func TestExpireCycleDeletesKeysNeverRead(t *testing.T) {
+	rs, port := runTestServer(t, "", "notify-keyspace-events", "Ex")
+
+	subscriber := newTestClient(t, port)
+	subscriber.do("SUBSCRIBE", "__keyevent@0__:expired")
+
+	commands := [][]string{}
+	for i := 0; i < 500; i++ {
+		commands = append(commands, []string{"SET", "volatile:" + strconv.Itoa(i), "1", "PX", "50"}, []string{"SET", "persistent:" + strconv.Itoa(i), "1"})
+	}
+	newTestClient(t, port).pipeline(commands...)
+
+	expired := map[string]bool{}
+	for len(expired) < 500 {
+		resp, err := subscriber.receive()
+		if err != nil {
+			t.Fatalf("failed to read expired event after %d keys: %v", len(expired), err)
+		}
+
+		key := resp.Value.([]RESPValue)[2].Value.(string)
+		if !strings.HasPrefix(key, "volatile:") || expired[key] {
+			t.Fatalf("unexpected expired event for %s", key)
+		}
+		expired[key] = true
+	}
+
+	if size := rs.Database.Size(); size != 500 {
+		t.Fatalf("database has %d keys once the volatile ones expired, want 500", size)
+	}
+}
+
+func TestExpiredKeysArePropagatedAsDel(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	replicaPort := startTestServer(t, "127.0.0.1 "+masterPort)
+
+	master := newTestClient(t, masterPort)
+	replica := newTestClient(t, replicaPort)
+	master.do("SET", "synced", "1")
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(replica.do("GET", "synced"), "1")
+	})
+
+	if resp := replica.do("MONITOR"); resp.Value != "OK" {
+		t.Fatalf("MONITOR returned %v", resp)
+	}
+
+	master.do("SET", "unread", "1", "PX", "50")
+	master.do("SET", "read", "1", "PX", "50")
+	time.Sleep(60 * time.Millisecond)
+	master.do("GET", "read")
+
+	deleted := map[string]bool{}
+	for !deleted["unread"] || !deleted["read"] {
+		resp, err := replica.receive()
+		if err != nil {
+			t.Fatalf("failed to read monitored command: %v", err)
+		}
+
+		line := resp.Value.(string)
+		for _, key := range []string{"unread", "read"} {
+			if strings.HasSuffix(line, `"del" "`+key+`"`) {
+				deleted[key] = true
+			}
+		}
+	}
+}
+
+// trackKeys turns tracking on for a client of port, with invalidations sent to
+// a subscribed client which is returned.
+func trackKeys(t *testing.T, port string) (*testClient, *testClient) {
+	t.Helper()
+
+	invalidations := newTestClient(t, port)
+	id := invalidations.do("CLIENT", "ID").Value.(int)
+	invalidations.do("SUBSCRIBE", "__redis__:invalidate")
+
+	tracking := newTestClient(t, port)
+	if resp := tracking.do("CLIENT", "TRACKING", "ON", "REDIRECT", strconv.Itoa(id)); resp.Value != "OK" {
+		t.Fatalf("CLIENT TRACKING returned %v", resp)
+	}
+
+	return tracking, invalidations
+}
+
+// expectInvalidateAll reads invalidations until one of every key arrives.
+func expectInvalidateAll(t *testing.T, invalidations *testClient) {
+	t.Helper()
+
+	for {
+		resp, err := invalidations.receive()
+		if err != nil {
+			t.Fatalf("failed to read invalidation: %v", err)
+		}
+
+		if message := resp.Value.([]RESPValue); message[2].Value == nil {
+			return
+		}
+	}
+}
+
+func TestFlushInvalidatesTrackedKeys(t *testing.T) {
+	port := startTestServer(t, "")
+	tracking, invalidations := trackKeys(t, port)
+
+	tracking.do("SET", "cached", "1")
+	tracking.do("GET", "cached")
+	newTestClient(t, port).do("FLUSHALL")
+	expectInvalidateAll(t, invalidations)
+
+	if resp := tracking.do("GET", "cached"); resp.Type != NullBulkString {
+		t.Fatalf("GET after the invalidation returned %v", resp)
+	}
+}
+
+func TestResyncInvalidatesTrackedKeys(t *testing.T) {
+	firstPort := startTestServer(t, "")
+	secondPort := startTestServer(t, "")
+	newTestClient(t, firstPort).do("SET", "cached", "1")
+	newTestClient(t, secondPort).do("SET", "cached", "2")
+
+	port := startTestServer(t, "127.0.0.1 "+firstPort)
+	tracking, invalidations := trackKeys(t, port)
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(tracking.do("GET", "cached"), "1")
+	})
+
+	newTestClient(t, port).do("REPLICAOF", "127.0.0.1", secondPort)
+	expectInvalidateAll(t, invalidations)
+
+	waitFor(t, "the replica to load the new master's data", func() bool {
+		return isBulk(tracking.do("GET", "cached"), "2")
+	})
+}
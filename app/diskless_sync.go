@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Ways a replica loads the RDB of a full sync: disabled saves it to disk
+// first, swapdb loads it straight from the connection, and on-empty-db does so
+// only when there is no data to lose if the transfer fails.
+const (
+	disklessLoadDisabled  = "disabled"
+	disklessLoadOnEmptyDB = "on-empty-db"
+	disklessLoadSwapDB    = "swapdb"
+)
+
+// DisklessSync is a full sync whose RDB is generated once and streamed to
+// every replica that asked for one within repl-diskless-sync-delay.
+type DisklessSync struct {
+	replicants []*ReplicantConnection
+	errs       map[*ReplicantConnection]error
+	done       chan struct{}
+}
+
+func (rs *RedisServer) disklessLoad() bool {
+	rs.ServerInfo.Replication.Acquire()
+	load := rs.ServerInfo.Replication.DisklessLoad
+	rs.ServerInfo.Replication.Release()
+
+	switch load {
+	case disklessLoadSwapDB:
+		return true
+	case disklessLoadOnEmptyDB:
+		return rs.Database.Size() == 0
+	default:
+		return false
+	}
+}
+
+// replicantsWriter writes to every replicant of a diskless sync, dropping the
+// ones whose connection fails.
+type replicantsWriter struct {
+	rs         *RedisServer
+	replicants []*ReplicantConnection
+	errs       map[*ReplicantConnection]error
+}
+
+func (rw *replicantsWriter) Write(p []byte) (int, error) {
+	live := []*ReplicantConnection{}
+	for _, replicant := range rw.replicants {
+		err := replicant.conn.Conn.Respond(string(p))
+		if err != nil {
+			rw.errs[replicant] = err
+			rw.rs.ServerInfo.Replication.Replicants.Remove(replicant)
+			replicant.Close()
+			continue
+		}
+
+		live = append(live, replicant)
+	}
+
+	rw.replicants = live
+	if len(live) == 0 {
+		return 0, fmt.Errorf("no replicas left to sync")
+	}
+
+	return len(p), nil
+}
+
+func (rs *RedisServer) joinDisklessSync(replicant *ReplicantConnection) *DisklessSync {
+	rs.disklessLock.Lock()
+	defer rs.disklessLock.Unlock()
+
+	if rs.disklessSync == nil {
+		sync := &DisklessSync{replicants: []*ReplicantConnection{}, errs: map[*ReplicantConnection]error{}, done: make(chan struct{})}
+		rs.disklessSync = sync
+
+		rs.ServerInfo.Replication.Acquire()
+		delay := time.Duration(rs.ServerInfo.Replication.DisklessDelay) * time.Second
+		rs.ServerInfo.Replication.Release()
+		time.AfterFunc(delay, func() {
+			rs.runDisklessSync(sync)
+		})
+	}
+
+	rs.disklessSync.replicants = append(rs.disklessSync.replicants, replicant)
+	return rs.disklessSync
+}
+
+func (rs *RedisServer) runDisklessSync(sync *DisklessSync) {
+	defer close(sync.done)
+
+	rs.disklessLock.Lock()
+	rs.disklessSync = nil
+	rs.disklessLock.Unlock()
+
+	err := rs.streamDisklessSync(sync)
+	if err != nil {
+		for _, replicant := range sync.replicants {
+			if sync.errs[replicant] == nil {
+				sync.errs[replicant] = err
+			}
+		}
+	}
+}
+
+func (rs *RedisServer) streamDisklessSync(sync *DisklessSync) error {
+	offset := 0
+	snapshot, err := rs.snapshotAtomically(context.Background(), func() error {
+		rs.createBacklog()
+		for _, replicant := range sync.replicants {
+			rs.ServerInfo.Replication.Replicants.Add(replicant)
+		}
+		offset = rs.ServerInfo.Replication.MasterReplOffset
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start diskless sync: %v", err)
+	}
+	defer snapshot.Release()
+
+	for _, replicant := range sync.replicants {
+		replicant.SetSendingBulk()
+	}
+
+	mark := NewEOFMark()
+	writer := &replicantsWriter{rs: rs, replicants: sync.replicants, errs: sync.errs}
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n$EOF:%s\r\n", rs.ServerInfo.Replication.MasterReplid, offset, mark)
+
+	_, err = writer.Write([]byte(header))
+	if err == nil {
+		err = NewRDBWriter(writer).WriteSnapshot(snapshot)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stream diskless sync: %v", err)
+	}
+
+	for _, replicant := range writer.replicants {
+		replicant.StreamOnAck()
+	}
+
+	_, err = writer.Write([]byte(mark))
+	if err != nil {
+		return fmt.Errorf("failed to stream diskless sync: %v", err)
+	}
+
+	return nil
+}
+
+// disklessFullSync waits for the diskless sync the replicant joined to be
+// streamed. Writes are sent to it once it acknowledges having loaded the RDB.
+func (rs *RedisServer) disklessFullSync(ctx context.Context, replicant *ReplicantConnection) error {
+	sync := rs.joinDisklessSync(replicant)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sync.done:
+	}
+
+	err := sync.errs[replicant]
+	if err != nil {
+		return fmt.Errorf("failed to run diskless sync: %v", err)
+	}
+
+	return nil
+}
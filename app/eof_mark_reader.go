@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+const rdbEOFMarkLength = 40
+
+// EOFMarkReader reads an RDB transfer of unknown length, which ends with a
+// random mark. The last bytes read are held back until it is known whether
+// they are the mark.
+type EOFMarkReader struct {
+	r       io.Reader
+	mark    []byte
+	pending []byte
+	done    bool
+}
+
+func NewEOFMarkReader(r io.Reader, mark string) *EOFMarkReader {
+	return &EOFMarkReader{r: r, mark: []byte(mark), pending: []byte{}}
+}
+
+// NewEOFMark returns a random mark to end a transfer with.
+func NewEOFMark() string {
+	mark := make([]byte, rdbEOFMarkLength/2)
+	rand.Read(mark)
+	return hex.EncodeToString(mark)
+}
+
+func (er *EOFMarkReader) Read(buf []byte) (int, error) {
+	for {
+		if !er.done && bytes.HasSuffix(er.pending, er.mark) {
+			er.pending = er.pending[:len(er.pending)-len(er.mark)]
+			er.done = true
+		}
+
+		if er.done {
+			if len(er.pending) == 0 {
+				return 0, io.EOF
+			}
+
+			n := copy(buf, er.pending)
+			er.pending = er.pending[n:]
+			return n, nil
+		}
+
+		if len(er.pending) > len(er.mark) {
+			n := copy(buf, er.pending[:len(er.pending)-len(er.mark)])
+			er.pending = er.pending[n:]
+			return n, nil
+		}
+
+		chunk := make([]byte, 16*1024)
+		n, err := er.r.Read(chunk)
+		er.pending = append(er.pending, chunk[:n]...)
+		if n == 0 && err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if n == 0 && err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// States of a manual failover as listed in INFO replication: the master pauses
+// writes and waits for a replica to catch up, then hands over to it by
+// becoming its replica.
+const (
+	failoverStateNone           = "no-failover"
+	failoverStateWaitingForSync = "waiting-for-sync"
+	failoverStateInProgress     = "failover-in-progress"
+)
+
+const failoverPollInterval = 100 * time.Millisecond
+
+type Failover struct {
+	state  string
+	host   string
+	port   string
+	abort  func()
+	result chan error
+	lock   sync.Mutex
+}
+
+func NewFailover() *Failover {
+	return &Failover{state: failoverStateNone}
+}
+
+func (f *Failover) State() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.state
+}
+
+// Begin starts waiting for the replica at host and port, or for any replica
+// when host is empty. It returns false if a failover is already running.
+func (f *Failover) Begin(host string, port string, abort func()) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.state != failoverStateNone {
+		return false
+	}
+
+	f.state = failoverStateWaitingForSync
+	f.host, f.port = host, port
+	f.abort = abort
+	return true
+}
+
+func (f *Failover) Target() (string, string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.host, f.port
+}
+
+// SetInProgress records the replica being handed over to. The result of
+// asking it to take over is reported on the returned channel.
+func (f *Failover) SetInProgress(host string, port string) chan error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.state = failoverStateInProgress
+	f.host, f.port = host, port
+	f.result = make(chan error, 1)
+	return f.result
+}
+
+func (f *Failover) InProgress() bool {
+	return f.State() == failoverStateInProgress
+}
+
+// Report hands the outcome of the PSYNC FAILOVER handshake to the failover in
+// progress, if there is one.
+func (f *Failover) Report(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.state != failoverStateInProgress {
+		return
+	}
+
+	select {
+	case f.result <- err:
+	default:
+	}
+}
+
+// Abort stops the running failover. It returns false if there is none.
+func (f *Failover) Abort() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.state == failoverStateNone {
+		return false
+	}
+
+	f.abort()
+	return true
+}
+
+func (f *Failover) End() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.state = failoverStateNone
+	f.host, f.port = "", ""
+	f.abort = nil
+	f.result = nil
+}
+
+// PauseWrites holds back every write until ResumeWrites, waiting for the ones
+// being executed to finish first.
+func (rs *RedisServer) PauseWrites() {
+	rs.writeLock.Lock()
+	rs.writesPaused = true
+	rs.writeLock.Unlock()
+}
+
+func (rs *RedisServer) ResumeWrites() {
+	rs.writeLock.Lock()
+	rs.writesPaused = false
+	rs.writesResumed.Broadcast()
+	rs.writeLock.Unlock()
+}
+
+// StartFailover pauses writes and hands over to the replica at host and port,
+// or to the first replica to catch up when host is empty, in the background.
+// The role is checked and the failover begun together, so the server can't be
+// made a replica in between.
+func (rs *RedisServer) StartFailover(ctx context.Context, host string, port string, timeout time.Duration, force bool) error {
+	rs.replicationLock.Lock()
+	defer rs.replicationLock.Unlock()
+
+	info := rs.ServerInfo.Replication
+	if info.Role() == "slave" {
+		return fmt.Errorf("FAILOVER is not valid when server is a replica.")
+	}
+
+	if info.Replicants.Size() == 0 {
+		return fmt.Errorf("FAILOVER requires connected replicas.")
+	}
+
+	if host != "" {
+		replicant, ok := info.Replicants.Find(host, port)
+		if !ok {
+			return fmt.Errorf("FAILOVER target HOST and PORT is not a replica.")
+		}
+
+		if !replicant.Online() {
+			return fmt.Errorf("FAILOVER target replica is not online.")
+		}
+	}
+
+	abortCtx, abort := context.WithCancel(ctx)
+	if !info.Failover.Begin(host, port, abort) {
+		abort()
+		return fmt.Errorf("FAILOVER already in progress.")
+	}
+
+	rs.PauseWrites()
+	go rs.failover(ctx, abortCtx, timeout, force)
+
+	return nil
+}
+
+// waitForFailoverTarget returns the address of the replica to hand over to
+// once it has acknowledged everything written. With force, the requested
+// target is used even when it didn't catch up before the timeout.
+func (rs *RedisServer) waitForFailoverTarget(ctx context.Context, timeout time.Duration, force bool) (string, string, error) {
+	info := rs.ServerInfo.Replication
+	rs.RequestAcknowledgements()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+
+	ticker := time.NewTicker(failoverPollInterval)
+	defer ticker.Stop()
+
+	host, port := info.Failover.Target()
+	for {
+		rs.writeLock.RLock()
+		offset := info.MasterReplOffset
+		rs.writeLock.RUnlock()
+
+		replicant, ok := info.Replicants.CaughtUp(host, port, offset)
+		if ok && host != "" {
+			return host, port, nil
+		} else if ok {
+			return replicant.ip, replicant.port, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", "", fmt.Errorf("aborted")
+		case <-deadline:
+			if force {
+				return host, port, nil
+			}
+			return "", "", fmt.Errorf("replica never caught up before timeout")
+		case <-ticker.C:
+		}
+	}
+}
+
+// failover becomes a replica of the chosen target, asking it to take over with
+// PSYNC FAILOVER. If that fails, this server goes back to being a master.
+func (rs *RedisServer) failover(ctx context.Context, abortCtx context.Context, timeout time.Duration, force bool) {
+	info := rs.ServerInfo.Replication
+	defer rs.ResumeWrites()
+	defer info.Failover.End()
+
+	host, port, err := rs.waitForFailoverTarget(abortCtx, timeout, force)
+	if err != nil {
+		fmt.Printf("FAILOVER aborted: %v\n", err)
+		return
+	}
+
+	result := info.Failover.SetInProgress(host, port)
+	rs.ReplicaOf(ctx, host, port)
+
+	select {
+	case err = <-result:
+	case <-abortCtx.Done():
+		err = fmt.Errorf("aborted")
+	}
+
+	if err != nil {
+		fmt.Printf("FAILOVER to %s:%s failed: %v\n", host, port, err)
+		rs.PromoteToMaster()
+	}
+}
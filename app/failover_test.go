@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFailoverHandsOverToReplica(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	replicaPort := startTestServer(t, "127.0.0.1 "+masterPort)
+
+	master := newTestClient(t, masterPort)
+	replica := newTestClient(t, replicaPort)
+	master.do("SET", "before", "1")
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(replica.do("GET", "before"), "1")
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, port := range []string{masterPort, replicaPort} {
+		client := newTestClient(t, port)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for _, args := range [][]string{{"GET", "before"}, {"INFO", "replication"}} {
+					_, err := client.try(args...)
+					if err != nil {
+						t.Errorf("%s failed: %v", args[0], err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	if resp := master.do("FAILOVER"); resp.Value != "OK" {
+		t.Fatalf("FAILOVER returned %v", resp)
+	}
+
+	waitFor(t, "the failover to finish", func() bool {
+		info := master.do("INFO", "replication").Value.(string)
+		return strings.Contains(info, "role:slave") && strings.Contains(info, "master_failover_state:no-failover")
+	})
+
+	close(stop)
+	wg.Wait()
+
+	info := replica.do("INFO", "replication").Value.(string)
+	if !strings.Contains(info, "role:master") {
+		t.Fatalf("replica wasn't promoted:\n%s", info)
+	}
+
+	if resp := replica.do("SET", "after", "2"); resp.Value != "OK" {
+		t.Fatalf("SET on the new master returned %v", resp)
+	}
+
+	waitFor(t, "the old master to follow the new one", func() bool {
+		return isBulk(master.do("GET", "after"), "2")
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/internal/lua"
+	"github.com/codecrafters-io/redis-starter-go/app/internal/lua/parse"
+)
+
+const (
+	functionChunkName   = "user_function"
+	functionLoadTimeout = 500 * time.Millisecond
+)
+
+// functionFlags are the flags a function may be registered with.
+var functionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+// LibraryFunction is a function registered by a library.
+type LibraryFunction struct {
+	name        string
+	description string
+	flags       []string
+}
+
+func (lf *LibraryFunction) HasFlag(flag string) bool {
+	for _, f := range lf.flags {
+		if f == flag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FunctionLibrary is a library of functions loaded with FUNCTION LOAD. Its
+// code is compiled once, and run again in a fresh state for each call to
+// register the callbacks of its functions.
+type FunctionLibrary struct {
+	name      string
+	code      string
+	proto     *lua.FunctionProto
+	functions map[string]*LibraryFunction
+}
+
+// validName reports whether name is a valid library or function name.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseLibraryMetadata reads the library name from the first line of code,
+// such as "#!lua name=mylib", and returns the code to compile, with that line
+// left blank so line numbers are kept.
+func parseLibraryMetadata(code string) (string, string, error) {
+	line, body, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return "", "", fmt.Errorf("Missing library metadata")
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "lua") {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", "", fmt.Errorf("Engine '%s' not found", engine)
+	}
+
+	name := ""
+	for _, field := range fields[1:] {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok || key != "name" {
+			return "", "", fmt.Errorf("Invalid metadata value given: %s", field)
+		}
+		name = val
+	}
+
+	if name == "" {
+		return "", "", fmt.Errorf("Library name was not given")
+	}
+
+	if !validName(name) {
+		return "", "", fmt.Errorf("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+
+	return name, "\n" + body, nil
+}
+
+// LoadFunctionLibrary compiles code and runs it to find the functions it
+// registers.
+func LoadFunctionLibrary(code string) (*FunctionLibrary, error) {
+	name, body, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(body), functionChunkName)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %s", singleLine(err.Error()))
+	}
+
+	proto, err := lua.Compile(chunk, functionChunkName)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %s", singleLine(err.Error()))
+	}
+
+	library := &FunctionLibrary{name: name, code: code, proto: proto, functions: map[string]*LibraryFunction{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), functionLoadTimeout)
+	defer cancel()
+	L := newScriptState(ctx)
+	defer L.Close()
+
+	_, _, err = library.instantiate(L, library.functions)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("FUNCTION LOAD timeout")
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(library.functions) == 0 {
+		return nil, fmt.Errorf("No functions registered")
+	}
+
+	return library, nil
+}
+
+// instantiate runs the library's code in L, recording the functions it
+// registers into functions, and returns the redis table it was given and the
+// callbacks of its functions.
+func (library *FunctionLibrary) instantiate(L *lua.LState, functions map[string]*LibraryFunction) (*lua.LTable, map[string]*lua.LFunction, error) {
+	callbacks := map[string]*lua.LFunction{}
+	redis := redisLibrary(L)
+	redis.RawSetString("register_function", L.NewFunction(registerFunction(functions, callbacks)))
+	L.SetGlobal("redis", redis)
+
+	L.Push(L.NewFunctionFromProto(library.proto))
+	err := L.PCall(0, 0, nil)
+	if err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			return nil, nil, fmt.Errorf("Error registering functions: %s", singleLine(apiErr.Object.String()))
+		}
+		return nil, nil, fmt.Errorf("Error registering functions: %s", singleLine(err.Error()))
+	}
+
+	return redis, callbacks, nil
+}
+
+// registerFunction returns redis.register_function, which takes either a name
+// and a callback, or a table with the function_name, callback, and optional
+// flags and description.
+func registerFunction(functions map[string]*LibraryFunction, callbacks map[string]*lua.LFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		function := &LibraryFunction{flags: []string{}}
+		var callback *lua.LFunction
+
+		if table, ok := L.Get(1).(*lua.LTable); ok && L.GetTop() == 1 {
+			table.ForEach(func(key lua.LValue, val lua.LValue) {
+				switch key.String() {
+				case "function_name":
+					function.name = lua.LVAsString(val)
+				case "callback":
+					callback, _ = val.(*lua.LFunction)
+				case "description":
+					function.description = lua.LVAsString(val)
+				case "flags":
+					flags, ok := val.(*lua.LTable)
+					if !ok {
+						L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+					}
+					flags.ForEach(func(_ lua.LValue, flag lua.LValue) {
+						if !functionFlags[lua.LVAsString(flag)] {
+							L.RaiseError("unknown flag given")
+						}
+						function.flags = append(function.flags, lua.LVAsString(flag))
+					})
+				default:
+					L.RaiseError("unknown argument given to redis.register_function")
+				}
+			})
+		} else {
+			function.name = L.CheckString(1)
+			callback = L.CheckFunction(2)
+		}
+
+		if !validName(function.name) {
+			L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		}
+
+		if callback == nil {
+			L.RaiseError("callback argument given to redis.register_function must be a function")
+		}
+
+		if _, ok := functions[function.name]; ok {
+			L.RaiseError("Function already exists in the library")
+		}
+
+		functions[function.name] = function
+		callbacks[function.name] = callback
+		return 0
+	}
+}
+
+// FunctionLibraries maps library names to libraries. It is never modified in
+// place, so database snapshots can share it.
+type FunctionLibraries map[string]*FunctionLibrary
+
+// Find returns the library that registered function.
+func (libraries FunctionLibraries) Find(function string) (*FunctionLibrary, *LibraryFunction, bool) {
+	for _, library := range libraries {
+		if f, ok := library.functions[function]; ok {
+			return library, f, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+func (libraries FunctionLibraries) Sorted() []*FunctionLibrary {
+	sorted := []*FunctionLibrary{}
+	for _, library := range libraries {
+		sorted = append(sorted, library)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+
+	return sorted
+}
+
+func (libraries FunctionLibraries) Functions() int {
+	count := 0
+	for _, library := range libraries {
+		count += len(library.functions)
+	}
+
+	return count
+}
+
+// With returns the libraries with library added. A library with the same name
+// is only replaced when replace is set, and no other library may register a
+// function with the same name.
+func (libraries FunctionLibraries) With(library *FunctionLibrary, replace bool) (FunctionLibraries, error) {
+	if _, ok := libraries[library.name]; ok && !replace {
+		return nil, fmt.Errorf("Library '%s' already exists", library.name)
+	}
+
+	for _, other := range libraries {
+		if other.name == library.name {
+			continue
+		}
+
+		for name := range library.functions {
+			if _, ok := other.functions[name]; ok {
+				return nil, fmt.Errorf("Function %s already exists", name)
+			}
+		}
+	}
+
+	result := libraries.Without(library.name)
+	result[library.name] = library
+	return result, nil
+}
+
+func (libraries FunctionLibraries) Without(name string) FunctionLibraries {
+	result := FunctionLibraries{}
+	for other, library := range libraries {
+		if other != name {
+			result[other] = library
+		}
+	}
+
+	return result
+}
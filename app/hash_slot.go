@@ -0,0 +1,35 @@
+package main
+
+import "strings"
+
+const clusterSlots = 16384
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum Redis hashes keys to slots with.
+func crc16(p []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range p {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// keyHashSlot returns the slot key hashes to. Only the part of the key between
+// the first { and the } after it is hashed when that part is not empty, so
+// related keys can be kept in the same slot.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16([]byte(key))) % clusterSlots
+}
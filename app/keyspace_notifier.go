@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	notifyKeyspace = 1 << iota
+	notifyKeyevent
+	notifyGeneric
+	notifyString
+	notifyList
+	notifySet
+	notifyHash
+	notifyZSet
+	notifyExpired
+	notifyEvicted
+	notifyStream
+	notifyKeyMiss
+	notifyModule
+	notifyNew
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+// keyspaceEventClasses are the characters notify-keyspace-events is made of,
+// in the order they are listed back.
+var keyspaceEventClasses = []struct {
+	char  byte
+	class int
+}{
+	{'g', notifyGeneric},
+	{'$', notifyString},
+	{'l', notifyList},
+	{'s', notifySet},
+	{'h', notifyHash},
+	{'z', notifyZSet},
+	{'x', notifyExpired},
+	{'e', notifyEvicted},
+	{'t', notifyStream},
+	{'d', notifyModule},
+	{'K', notifyKeyspace},
+	{'E', notifyKeyevent},
+	{'m', notifyKeyMiss},
+	{'n', notifyNew},
+}
+
+func parseKeyspaceEvents(val string) (int, error) {
+	flags := 0
+	for i := 0; i < len(val); i++ {
+		if val[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+
+		found := false
+		for _, class := range keyspaceEventClasses {
+			if class.char == val[i] {
+				flags |= class.class
+				found = true
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("invalid event class character, use 'Ag$lshzxeKEtmdn'")
+		}
+	}
+
+	return flags, nil
+}
+
+func keyspaceEventsString(flags int) string {
+	var str strings.Builder
+	for _, class := range keyspaceEventClasses {
+		if flags&notifyAll == notifyAll && class.class&notifyAll != 0 {
+			continue
+		}
+
+		if flags&class.class != 0 {
+			str.WriteByte(class.char)
+		}
+	}
+
+	if flags&notifyAll == notifyAll {
+		return "A" + str.String()
+	}
+
+	return str.String()
+}
+
+// KeyspaceNotifier publishes the events of the classes enabled by
+// notify-keyspace-events to __keyspace@0__:<key>, naming the event, and to
+// __keyevent@0__:<event>, naming the key.
+type KeyspaceNotifier struct {
+	pubSub *PubSub
+	flags  int
+	lock   sync.RWMutex
+}
+
+func NewKeyspaceNotifier(pubSub *PubSub) *KeyspaceNotifier {
+	return &KeyspaceNotifier{pubSub: pubSub}
+}
+
+func (kn *KeyspaceNotifier) Flags() int {
+	kn.lock.RLock()
+	defer kn.lock.RUnlock()
+
+	return kn.flags
+}
+
+func (kn *KeyspaceNotifier) SetFlags(flags int) {
+	kn.lock.Lock()
+	defer kn.lock.Unlock()
+
+	kn.flags = flags
+}
+
+// Notify publishes event on key if its class is enabled.
+func (kn *KeyspaceNotifier) Notify(class int, event string, key string) {
+	flags := kn.Flags()
+	if flags&class == 0 {
+		return
+	}
+
+	if flags&notifyKeyspace != 0 {
+		kn.pubSub.Publish("__keyspace@0__:"+key, event)
+	}
+
+	if flags&notifyKeyevent != 0 {
+		kn.pubSub.Publish("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const (
+	listpackHeaderSize = 6
+	listpackEOF        = 0xFF
+)
+
+// Listpack builds the serialized listpack encoding used by RDB stream nodes.
+type Listpack struct {
+	entries []byte
+	count   int
+}
+
+func NewListpack() *Listpack {
+	return &Listpack{entries: []byte{}, count: 0}
+}
+
+func encodeListpackBacklen(size int) []byte {
+	n := listpackBacklenSize(size)
+	backlen := make([]byte, n)
+	for i := 0; i < n; i++ {
+		backlen[i] = byte((size >> (7 * (n - 1 - i))) & 127)
+		if i > 0 {
+			backlen[i] |= 128
+		}
+	}
+
+	return backlen
+}
+
+func (lp *Listpack) appendEntry(entry []byte) {
+	lp.entries = append(lp.entries, entry...)
+	lp.entries = append(lp.entries, encodeListpackBacklen(len(entry))...)
+	lp.count += 1
+}
+
+func (lp *Listpack) AppendString(s string) {
+	size := len(s)
+	entry := []byte{}
+
+	switch {
+	case size < 64:
+		entry = append(entry, 0x80|byte(size))
+	case size < 4096:
+		entry = append(entry, 0xE0|byte(size>>8), byte(size))
+	default:
+		entry = append(entry, 0xF0)
+		entry = binary.LittleEndian.AppendUint32(entry, uint32(size))
+	}
+
+	lp.appendEntry(append(entry, s...))
+}
+
+func (lp *Listpack) AppendInt(n int64) {
+	entry := []byte{}
+
+	switch {
+	case n >= 0 && n <= 127:
+		entry = append(entry, byte(n))
+	case n >= -4096 && n <= 4095:
+		v := uint64(n) & 0x1FFF
+		entry = append(entry, 0xC0|byte(v>>8), byte(v))
+	case n >= -32768 && n <= 32767:
+		entry = append(entry, 0xF1)
+		entry = binary.LittleEndian.AppendUint16(entry, uint16(n))
+	case n >= -8388608 && n <= 8388607:
+		v := uint32(n)
+		entry = append(entry, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+	case n >= -2147483648 && n <= 2147483647:
+		entry = append(entry, 0xF3)
+		entry = binary.LittleEndian.AppendUint32(entry, uint32(n))
+	default:
+		entry = append(entry, 0xF4)
+		entry = binary.LittleEndian.AppendUint64(entry, uint64(n))
+	}
+
+	lp.appendEntry(entry)
+}
+
+func (lp *Listpack) Bytes() []byte {
+	total := listpackHeaderSize + len(lp.entries) + 1
+	count := Min(lp.count, 65535)
+
+	res := make([]byte, 0, total)
+	res = binary.LittleEndian.AppendUint32(res, uint32(total))
+	res = binary.LittleEndian.AppendUint16(res, uint16(count))
+	res = append(res, lp.entries...)
+	return append(res, listpackEOF)
+}
+
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+func signExtend(v uint64, bits int) int64 {
+	shift := 64 - bits
+	return int64(v<<shift) >> shift
+}
+
+func decodeListpackEntry(data []byte) (string, int, error) {
+	if len(data) == 0 {
+		return "", 0, fmt.Errorf("failed to decode listpack entry: unexpected end of listpack")
+	}
+
+	need := func(n int) error {
+		if len(data) < n {
+			return fmt.Errorf("failed to decode listpack entry: unexpected end of listpack")
+		}
+		return nil
+	}
+
+	encoding := data[0]
+	var str string
+	var size int
+
+	switch {
+	case encoding&0x80 == 0:
+		str, size = strconv.Itoa(int(encoding)), 1
+	case encoding&0xC0 == 0x80:
+		length := int(encoding & 0x3F)
+		if err := need(1 + length); err != nil {
+			return "", 0, err
+		}
+		str, size = string(data[1:1+length]), 1+length
+	case encoding&0xE0 == 0xC0:
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		v := uint64(encoding&0x1F)<<8 | uint64(data[1])
+		str, size = strconv.FormatInt(signExtend(v, 13), 10), 2
+	case encoding&0xF0 == 0xE0:
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		length := int(encoding&0x0F)<<8 | int(data[1])
+		if err := need(2 + length); err != nil {
+			return "", 0, err
+		}
+		str, size = string(data[2:2+length]), 2+length
+	case encoding == 0xF0:
+		if err := need(5); err != nil {
+			return "", 0, err
+		}
+		length := int(binary.LittleEndian.Uint32(data[1:5]))
+		if err := need(5 + length); err != nil {
+			return "", 0, err
+		}
+		str, size = string(data[5:5+length]), 5+length
+	case encoding >= 0xF1 && encoding <= 0xF4:
+		width := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[encoding]
+		if err := need(1 + width); err != nil {
+			return "", 0, err
+		}
+		v := uint64(0)
+		for i := width; i >= 1; i-- {
+			v = v<<8 | uint64(data[i])
+		}
+		str, size = strconv.FormatInt(signExtend(v, width*8), 10), 1+width
+	default:
+		return "", 0, fmt.Errorf("failed to decode listpack entry: invalid encoding %x", encoding)
+	}
+
+	return str, size + listpackBacklenSize(size), nil
+}
+
+// DecodeListpack returns every element of a serialized listpack as a string,
+// formatting integer encoded elements in decimal.
+func DecodeListpack(data []byte) ([]string, error) {
+	if len(data) < listpackHeaderSize+1 {
+		return []string{}, fmt.Errorf("failed to decode listpack: too short")
+	}
+
+	elements := []string{}
+	i := listpackHeaderSize
+	for i < len(data) && data[i] != listpackEOF {
+		str, size, err := decodeListpackEntry(data[i:])
+		if err != nil {
+			return []string{}, err
+		}
+
+		elements = append(elements, str)
+		i += size
+	}
+
+	if i >= len(data) {
+		return []string{}, fmt.Errorf("failed to decode listpack: missing terminator")
+	}
+
+	return elements, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// listpackVector holds elements in each of the encodings noted below, laid
+// out as the listpack specification describes them.
+func listpackVector() ([]byte, []string) {
+	long, longer := strings.Repeat("x", 70), strings.Repeat("y", 200)
+
+	data := []byte{0x34, 0x01, 0x00, 0x00, 0x08, 0x00}
+	data = append(data, 0x05, 0x01)                                // 7 bit unsigned 5
+	data = append(data, 0x85, 'h', 'e', 'l', 'l', 'o', 0x06)       // 6 bit length string
+	data = append(data, 0xDF, 0xFF, 0x02)                          // 13 bit signed -1
+	data = append(data, 0xC3, 0xE8, 0x02)                          // 13 bit signed 1000
+	data = append(data, 0xF1, 0x10, 0x27, 0x03)                    // 16 bit signed 10000
+	data = append(append(append(data, 0xE0, 0x46), long...), 0x48) // 12 bit length string
+	data = append(data, 0xF2, 0x60, 0x79, 0xFE, 0x04)              // 24 bit signed -100000
+	data = append(append(append(data, 0xE0, 0xC8), longer...), 0x01, 0xCA)
+	data = append(data, 0xFF)
+
+	return data, []string{"5", "hello", "-1", "1000", "10000", long, "-100000", longer}
+}
+
+func TestDecodeListpack(t *testing.T) {
+	data, elements := listpackVector()
+
+	decoded, err := DecodeListpack(data)
+	if err != nil {
+		t.Fatalf("failed to decode listpack: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded, elements) {
+		t.Fatalf("decoded %q, want %q", decoded, elements)
+	}
+
+	_, err = DecodeListpack(data[:len(data)-10])
+	if err == nil {
+		t.Fatalf("decoded a truncated listpack")
+	}
+}
+
+func TestEncodeListpack(t *testing.T) {
+	data, elements := listpackVector()
+
+	lp := NewListpack()
+	lp.AppendInt(5)
+	lp.AppendString(elements[1])
+	lp.AppendInt(-1)
+	lp.AppendInt(1000)
+	lp.AppendInt(10000)
+	lp.AppendString(elements[5])
+	lp.AppendInt(-100000)
+	lp.AppendString(elements[7])
+
+	if encoded := lp.Bytes(); !bytes.Equal(encoded, data) {
+		t.Fatalf("encoded %x, want %x", encoded, data)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "fmt"
+
+// LZFDecompress expands data compressed with the LZF algorithm Redis uses for
+// large strings in RDB files.
+func LZFDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+
+	i := 0
+	for i < len(in) {
+		ctrl := int(in[i])
+		i += 1
+
+		if ctrl < 1<<5 {
+			literal := ctrl + 1
+			if i+literal > len(in) {
+				return []byte{}, fmt.Errorf("failed to decompress LZF: literal run out of bounds")
+			}
+
+			out = append(out, in[i:i+literal]...)
+			i += literal
+			continue
+		}
+
+		backref := len(out) - ((ctrl & 0x1F) << 8) - 1
+		size := ctrl >> 5
+		if size == 7 {
+			if i >= len(in) {
+				return []byte{}, fmt.Errorf("failed to decompress LZF: truncated back reference")
+			}
+			size += int(in[i])
+			i += 1
+		}
+
+		if i >= len(in) {
+			return []byte{}, fmt.Errorf("failed to decompress LZF: truncated back reference")
+		}
+		backref -= int(in[i])
+		i += 1
+
+		if backref < 0 {
+			return []byte{}, fmt.Errorf("failed to decompress LZF: back reference out of bounds")
+		}
+
+		for j := 0; j < size+2; j++ {
+			out = append(out, out[backref+j])
+		}
+	}
+
+	if len(out) != length {
+		return []byte{}, fmt.Errorf("failed to decompress LZF: expected %d bytes, got %d", length, len(out))
+	}
+
+	return out, nil
+}
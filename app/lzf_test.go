@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLZFDecompress(t *testing.T) {
+	for _, test := range []struct {
+		in  []byte
+		out string
+	}{
+		// A literal run alone.
+		{[]byte{0x02, 'a', 'b', 'c'}, "abc"},
+		// A short back reference copying 6 bytes from 2 back.
+		{[]byte{0x01, 'a', 'b', 0x80, 0x01}, "abababab"},
+		// A long back reference, whose length takes an extra byte.
+		{[]byte{0x02, 'a', 'b', 'c', 0xE0, 0x00, 0x02}, "abcabcabcabc"},
+		{[]byte{0x00, 'a', 0xE0, 0x14, 0x00}, string(bytes.Repeat([]byte{'a'}, 30))},
+		// A back reference followed by another literal run.
+		{[]byte{0x01, 'a', 'b', 0x20, 0x01, 0x00, '!'}, "ababa!"},
+	} {
+		out, err := LZFDecompress(test.in, len(test.out))
+		if err != nil {
+			t.Fatalf("failed to decompress %x: %v", test.in, err)
+		}
+
+		if string(out) != test.out {
+			t.Fatalf("decompressed %x to %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestLZFDecompressInvalid(t *testing.T) {
+	for _, test := range []struct {
+		in     []byte
+		length int
+	}{
+		// A literal run longer than the input.
+		{[]byte{0x05, 'a'}, 6},
+		// A back reference before the start of the output.
+		{[]byte{0x00, 'a', 0x20, 0x05}, 4},
+		// A back reference missing its offset.
+		{[]byte{0x00, 'a', 0x20}, 4},
+		// Output of a different length than stored.
+		{[]byte{0x02, 'a', 'b', 'c'}, 4},
+	} {
+		_, err := LZFDecompress(test.in, test.length)
+		if err == nil {
+			t.Fatalf("decompressed invalid input %x", test.in)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -21,6 +24,7 @@ func (mc *MasterConnection) handshakeStage(ctx context.Context, request RESPValu
 	if err != nil {
 		return RESPValue{}, fmt.Errorf("failed to run handshake: %v", err)
 	}
+	mc.conn.Server.ServerInfo.Replication.MasterLink.Touch()
 
 	return response, nil
 }
@@ -51,37 +55,159 @@ func (mc *MasterConnection) handshakePING(ctx context.Context) error {
 
 func (mc *MasterConnection) handshakeREPLCONF(ctx context.Context) error {
 	request1 := RESPValue{Array, []RESPValue{{BulkString, "REPLCONF"}, {BulkString, "listening-port"}, {BulkString, mc.conn.Server.ServerInfo.Replication.Port}}}
-	request2 := RESPValue{Array, []RESPValue{{BulkString, "REPLCONF"}, {BulkString, "capa"}, {BulkString, "psync2"}}}
+	request2 := RESPValue{Array, []RESPValue{{BulkString, "REPLCONF"}, {BulkString, "capa"}, {BulkString, "eof"}, {BulkString, "capa"}, {BulkString, "psync2"}}}
 
 	return mc.verifyResponses(ctx, []stage{{request: request1, expected: "OK"}, {request: request2, expected: "OK"}})
 }
 
+// psyncRequest asks to continue from the cached replication history when this
+// server has synced with a master before, and for a full resync otherwise.
+// During a failover it also asks the new master to take over.
+func (mc *MasterConnection) psyncRequest() RESPValue {
+	info := mc.conn.Server.ServerInfo.Replication
+	replid, offset := "?", "-1"
+	mc.conn.Server.writeLock.RLock()
+	if info.MasterSynced {
+		replid, offset = info.MasterReplid, strconv.Itoa(info.MasterReplOffset+1)
+	}
+	mc.conn.Server.writeLock.RUnlock()
+
+	request := RESPValue{Array, []RESPValue{{BulkString, "PSYNC"}, {BulkString, replid}, {BulkString, offset}}}
+	if info.Failover.InProgress() {
+		request.Value = append(request.Value.([]RESPValue), RESPValue{BulkString, "FAILOVER"})
+	}
+
+	return request
+}
+
+// continueSync adopts the master's replication ID, keeping the previous one so
+// replicas of this server can still continue from it.
+func (mc *MasterConnection) continueSync(masterReplid string) {
+	mc.conn.Server.writeLock.Lock()
+	defer mc.conn.Server.writeLock.Unlock()
+
+	info := mc.conn.Server.ServerInfo.Replication
+	if masterReplid != "" && masterReplid != info.MasterReplid {
+		info.MasterReplid2 = info.MasterReplid
+		info.SecondReplOffset = info.MasterReplOffset + 1
+		info.MasterReplid = masterReplid
+
+		// Our replicas reconnect to learn the new ID, continuing from the old one.
+		info.Replicants.DisconnectAll()
+	}
+
+	if info.Backlog == nil {
+		info.Backlog = NewReplicationBacklog(info.ReplBacklogSize, info.MasterReplOffset)
+	}
+}
+
 func (mc *MasterConnection) handshakePSYNC(ctx context.Context) error {
-	request := RESPValue{Array, []RESPValue{{BulkString, "PSYNC"}, {BulkString, "?"}, {BulkString, "-1"}}}
-	val, err := mc.handshakeStage(ctx, request)
+	val, err := mc.handshakeStage(ctx, mc.psyncRequest())
 	if err != nil {
 		return err
 	}
 
-	_, args, _ := strings.Cut(val.Value.(string), " ")
+	response, ok := val.Value.(string)
+	if !ok || val.Type != SimpleString {
+		return fmt.Errorf("unexpected PSYNC response: %v", val.Value)
+	}
+
+	result, args, _ := strings.Cut(response, " ")
 	masterReplid, args, _ := strings.Cut(args, " ")
-	masterReplOffset, _, _ := strings.Cut(args, " ")
+	if result == "CONTINUE" {
+		mc.continueSync(masterReplid)
+		return nil
+	}
 
+	masterReplOffset, _, _ := strings.Cut(args, " ")
 	offset, err := strconv.Atoi(masterReplOffset)
 	if err != nil {
 		return err
 	}
 
-	mc.conn.Server.ServerInfo.Replication.MasterReplid = masterReplid
-	mc.conn.Server.ServerInfo.Replication.MasterReplOffset = offset
+	mc.conn.Server.ServerInfo.Replication.MasterLink.SetState(replStateTransfer)
+	err = mc.loadRDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	mc.conn.Server.writeLock.Lock()
+	defer mc.conn.Server.writeLock.Unlock()
+
+	info := mc.conn.Server.ServerInfo.Replication
+	info.MasterReplid = masterReplid
+	info.MasterReplid2 = noReplicationID
+	info.MasterReplOffset = offset
+	info.SecondReplOffset = -1
+	info.Backlog = NewReplicationBacklog(info.ReplBacklogSize, offset)
+	info.MasterSynced = true
+
+	// Our replicas hold a history that no longer exists and must resync.
+	info.Replicants.DisconnectAll()
+
+	return nil
+}
+
+// receiveRDB loads the master's snapshot into database, straight from the
+// connection when loading disklessly, and otherwise by saving it as this
+// server's RDB file first.
+func (mc *MasterConnection) receiveRDB(transfer io.Reader, database *Database) error {
+	if mc.conn.Server.disklessLoad() {
+		return NewRDBReader(transfer).Load(database)
+	}
+
+	dir, dbfilename := mc.conn.Server.rdbPath()
+	err := replaceRDBFile(dir, dbfilename, func(w io.Writer) error {
+		_, err := io.Copy(w, transfer)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filepath.Join(dir, dbfilename))
+	if err != nil {
+		return fmt.Errorf("failed to open RDB file: %v", err)
+	}
+	defer file.Close()
+
+	return NewRDBReader(file).Load(database)
+}
+
+// loadRDB reads the master's snapshot into a fresh database and swaps it in
+// once it has been fully loaded.
+func (mc *MasterConnection) loadRDB(ctx context.Context) error {
+	transfer, err := mc.conn.Conn.NextTransfer(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to receive RDB file: %v", err)
+	}
+
+	database := NewDatabase()
+	err = mc.receiveRDB(transfer, database)
+	if err != nil {
+		return fmt.Errorf("failed to load RDB file: %v", err)
+	}
 
-	// handle RDB file
-	mc.conn.Conn.NextRESP(ctx)
+	_, err = io.Copy(io.Discard, transfer)
+	if err != nil {
+		return fmt.Errorf("failed to receive RDB file: %v", err)
+	}
+
+	mc.conn.Server.Database.Replace(database)
+
+	if mc.conn.Server.AOF.Enabled() {
+		_, err = mc.conn.Server.BackgroundRewriteAppendOnly()
+		if err != nil {
+			fmt.Printf("failed to rewrite append only file after full sync: %v\n", err)
+		}
+	}
 
 	return nil
 }
 
 func (mc *MasterConnection) Handshake(ctx context.Context) error {
+	mc.conn.Server.ServerInfo.Replication.MasterLink.SetState(replStateHandshake)
+
 	err := mc.handshakePING(ctx)
 	if err != nil {
 		return err
@@ -100,31 +226,76 @@ func (mc *MasterConnection) Handshake(ctx context.Context) error {
 	return nil
 }
 
+// HandleMaster applies the commands the master streams. A transaction is
+// applied at once when its EXEC arrives, so clients of this replica never see
+// only part of it.
 func (mc *MasterConnection) HandleMaster(ctx context.Context) error {
+	var transaction []RESPValue
 	for {
 		resp, err := mc.conn.Conn.NextRESP(ctx)
 		if err != nil {
 			return err
 		}
+		mc.conn.Server.ServerInfo.Replication.MasterLink.Touch()
 
 		parseInfo, err := mc.conn.Conn.GetArgs(resp)
 		if err != nil {
 			return err
 		}
 
-		vals := mc.conn.ResponseFromArgs(ctx, parseInfo)
+		if command, _, ok := checkCall(parseInfo); ok {
+			mc.conn.feedMonitors(command, parseInfo, mc.conn.Conn.RemoteAddr())
+		}
+
+		if parseInfo.Command == "MULTI" {
+			transaction = []RESPValue{resp}
+			continue
+		} else if transaction != nil {
+			transaction = append(transaction, resp)
+			if parseInfo.Command == "EXEC" {
+				err = mc.applyTransaction(ctx, transaction)
+				transaction = nil
+			}
+
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		vals := mc.conn.Server.ExecuteFromMaster([]RESPValue{resp}, isWriteCommand(parseInfo), func() []RESPValue {
+			return mc.conn.ResponseFromArgs(ctx, parseInfo)
+		})
+
 		if isAcknowledgementRequest(parseInfo) {
 			err := mc.conn.Conn.RespondRESPValues(vals)
 			if err != nil {
 				return err
 			}
 		}
+	}
+}
 
-		err = mc.conn.Server.ProcessBytes(resp)
+// applyTransaction applies the commands between a MULTI and EXEC the master
+// streamed, and forwards and logs the whole block.
+func (mc *MasterConnection) applyTransaction(ctx context.Context, transaction []RESPValue) error {
+	commands := []ParseInfo{}
+	for _, resp := range transaction[1 : len(transaction)-1] {
+		parseInfo, err := mc.conn.Conn.GetArgs(resp)
 		if err != nil {
 			return err
 		}
+		commands = append(commands, parseInfo)
 	}
+
+	mc.conn.Server.ExecuteFromMaster(transaction, true, func() []RESPValue {
+		for _, parseInfo := range commands {
+			mc.conn.ResponseFromArgs(ctx, parseInfo)
+		}
+		return []RESPValue{}
+	})
+
+	return nil
 }
 
 func (mc *MasterConnection) Close() error {
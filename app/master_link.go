@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// States a replica's link to its master goes through: it connects, runs the
+// handshake, receives the RDB in transfer when it can't continue partially, and
+// is connected while streaming writes.
+const (
+	replStateConnect    = "connect"
+	replStateConnecting = "connecting"
+	replStateHandshake  = "handshake"
+	replStateTransfer   = "transfer"
+	replStateConnected  = "connected"
+)
+
+type MasterLink struct {
+	state     string
+	lastIO    time.Time
+	downSince time.Time
+	lock      sync.Mutex
+}
+
+func NewMasterLink() *MasterLink {
+	return &MasterLink{state: replStateConnect}
+}
+
+func (ml *MasterLink) SetState(state string) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	if state == replStateConnect && ml.state == replStateConnected {
+		ml.downSince = time.Now()
+	}
+	ml.state = state
+	ml.lastIO = time.Now()
+}
+
+func (ml *MasterLink) State() string {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	return ml.state
+}
+
+// Touch records that something was just received from the master.
+func (ml *MasterLink) Touch() {
+	ml.lock.Lock()
+	ml.lastIO = time.Now()
+	ml.lock.Unlock()
+}
+
+func (ml *MasterLink) LastIOSecondsAgo() int {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	if ml.state == replStateConnect {
+		return -1
+	}
+
+	return int(time.Since(ml.lastIO).Seconds())
+}
+
+// DownSinceSeconds returns how long ago the link was lost, or -1 if it was
+// never up.
+func (ml *MasterLink) DownSinceSeconds() int {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	if ml.downSince.IsZero() {
+		return -1
+	}
+
+	return int(time.Since(ml.downSince).Seconds())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Monitors sends a line describing every command the server processes to the
+// clients that called MONITOR. Lines are queued on their subscribers, so a
+// slow monitor is disconnected instead of holding back commands.
+type Monitors struct {
+	subs map[*Subscriber]bool
+	lock sync.Mutex
+}
+
+func NewMonitors() *Monitors {
+	return &Monitors{subs: map[*Subscriber]bool{}}
+}
+
+func (m *Monitors) Add(sub *Subscriber) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.subs[sub] = true
+}
+
+func (m *Monitors) Remove(sub *Subscriber) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.subs, sub)
+}
+
+// Feed sends monitors the command made of args, run by the client at source.
+func (m *Monitors) Feed(source string, args []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(m.subs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	line := fmt.Sprintf("%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, source)
+	for _, arg := range args {
+		line += " " + quoteArg(arg)
+	}
+
+	for sub := range m.subs {
+		sub.Push(RESPValue{Type: SimpleString, Value: line})
+	}
+}
+
+// quoteArg quotes arg as Redis does, escaping quotes, backslashes and
+// characters that are not printable.
+func quoteArg(arg string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString("\\n")
+		case '\r':
+			quoted.WriteString("\\r")
+		case '\t':
+			quoted.WriteString("\\t")
+		case '\a':
+			quoted.WriteString("\\a")
+		case '\b':
+			quoted.WriteString("\\b")
+		default:
+			if c < ' ' || c > '~' {
+				fmt.Fprintf(&quoted, "\\x%02x", c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// expectMonitored reads the next line a monitor is sent, failing the test
+// unless it shows the command made of args.
+func expectMonitored(t *testing.T, monitor *testClient, args string) {
+	t.Helper()
+
+	resp, err := monitor.receive()
+	if err != nil {
+		t.Fatalf("failed to read monitored command: %v", err)
+	}
+
+	if line, _ := resp.Value.(string); !strings.HasSuffix(line, "] "+args) {
+		t.Fatalf("monitor got %v, want %s", resp, args)
+	}
+}
+
+func TestMonitorShowsCommandsBeforeTheyRun(t *testing.T) {
+	port := startTestServer(t, "")
+
+	monitor := newTestClient(t, port)
+	if resp := monitor.do("MONITOR"); resp.Value != "OK" {
+		t.Fatalf("MONITOR returned %v", resp)
+	}
+
+	client := newTestClient(t, port)
+	client.do("MULTI")
+	expectMonitored(t, monitor, `"multi"`)
+	client.do("SET", "queued", "1")
+	expectMonitored(t, monitor, `"set" "queued" "1"`)
+	client.do("EXEC")
+	expectMonitored(t, monitor, `"exec"`)
+
+	start := time.Now()
+	err := client.conn.RespondRESP(CommandRESP("WAIT", "1", "2000"))
+	if err != nil {
+		t.Fatalf("failed to send WAIT: %v", err)
+	}
+	expectMonitored(t, monitor, `"wait" "1" "2000"`)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("WAIT was only shown after %v", elapsed)
+	}
+
+	if resp, err := client.receive(); err != nil || resp.Value != 0 {
+		t.Fatalf("WAIT returned %v, %v", resp, err)
+	}
+}
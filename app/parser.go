@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strconv"
@@ -12,16 +13,40 @@ type ParseInfo struct {
 	Args    []RESPValue
 }
 
+// Parser consumes RESP values from a buffer of raw input. When the buffer ends
+// in the middle of a value the parser rewinds and returns io.EOF, so the caller
+// can feed it more input and try again.
 type Parser struct {
-	ts *TokenStream
+	buffer []byte
+	pos    int
 }
 
 func NewParser() *Parser {
-	return &Parser{ts: NewTokenStream([]string{})}
+	return &Parser{buffer: []byte{}, pos: 0}
+}
+
+func (p *Parser) readLine() (string, error) {
+	end := bytes.Index(p.buffer[p.pos:], []byte("\r\n"))
+	if end == -1 {
+		return "", io.EOF
+	}
+
+	line := string(p.buffer[p.pos : p.pos+end])
+	p.pos += end + 2
+	return line, nil
+}
+
+func (p *Parser) readBytes(size int) (string, error) {
+	if len(p.buffer)-p.pos < size {
+		return "", io.EOF
+	}
+
+	str := string(p.buffer[p.pos : p.pos+size])
+	p.pos += size
+	return str, nil
 }
 
 func (p *Parser) parseSimpleString(token string) RESPValue {
-	p.ts.Advance()
 	return RESPValue{Type: SimpleString, Value: token[1:]}
 }
 
@@ -32,17 +57,17 @@ func (p *Parser) parseBulkString(token string) (RESPValue, error) {
 	}
 
 	if size == -1 {
-		p.ts.Advance()
 		return RESPValue{Type: NullBulkString, Value: nil}, nil
 	}
 
-	p.ts.Advance()
-	str := p.ts.Curr()[:size]
+	str, err := p.readBytes(size)
+	if err != nil {
+		return RESPValue{}, err
+	}
 
-	if len(p.ts.Curr()) > size {
-		p.ts.AdvanceCurr(size)
-	} else {
-		p.ts.Advance()
+	_, err = p.readBytes(2)
+	if err != nil {
+		return RESPValue{}, err
 	}
 
 	return RESPValue{Type: BulkString, Value: str}, nil
@@ -54,7 +79,6 @@ func (p *Parser) parseInteger(token string) (RESPValue, error) {
 		return RESPValue{}, fmt.Errorf("failed to convert integer token %s into number: %v", token[1:], err)
 	}
 
-	p.ts.Advance()
 	return RESPValue{Type: Integer, Value: num}, nil
 }
 
@@ -64,12 +88,16 @@ func (p *Parser) parseArray(token string) (RESPValue, error) {
 		return RESPValue{}, fmt.Errorf("failed to convert array token %s into number: %v", token[1:], err)
 	}
 
-	p.ts.Advance()
+	if size == -1 {
+		return RESPValue{Type: Null, Value: nil}, nil
+	}
 
 	elements := []RESPValue{}
 	for i := 0; i < size; i++ {
 		val, err := p.parseExpression()
-		if err != nil {
+		if err == io.EOF {
+			return RESPValue{}, err
+		} else if err != nil {
 			return RESPValue{}, fmt.Errorf("failed to parse array element at index %d: %v", i, err)
 		}
 
@@ -80,23 +108,34 @@ func (p *Parser) parseArray(token string) (RESPValue, error) {
 }
 
 func (p *Parser) parseNull() RESPValue {
-	p.ts.Advance()
 	return RESPValue{Type: Null, Value: nil}
 }
 
 func (p *Parser) parseSimpleError(token string) RESPValue {
-	p.ts.Advance()
 	return RESPValue{Type: SimpleError, Value: token[1:]}
 }
 
+func (p *Parser) parseInline(token string) (RESPValue, error) {
+	args := []RESPValue{}
+	for _, arg := range strings.Fields(token) {
+		args = append(args, RESPValue{Type: BulkString, Value: arg})
+	}
+
+	if len(args) == 0 {
+		return RESPValue{}, fmt.Errorf("failed to get expression from token %s", token)
+	}
+
+	return RESPValue{Type: Array, Value: args}, nil
+}
+
 func (p *Parser) parseExpression() (RESPValue, error) {
-	for p.ts.Curr() == "" {
-		p.ts.Advance()
+	token, err := p.readLine()
+	for err == nil && token == "" {
+		token, err = p.readLine()
 	}
 
-	token := p.ts.Curr()
-	if token == EOFToken {
-		return RESPValue{}, io.EOF
+	if err != nil {
+		return RESPValue{}, err
 	}
 
 	switch token[0] {
@@ -113,22 +152,67 @@ func (p *Parser) parseExpression() (RESPValue, error) {
 	case '-':
 		return p.parseSimpleError(token), nil
 	default:
-		return RESPValue{}, fmt.Errorf("failed to get expression from token %s", token)
+		return p.parseInline(token)
 	}
 }
 
+func (p *Parser) parseLine() (RESPValue, error) {
+	line, err := p.readLine()
+	if err != nil {
+		return RESPValue{}, err
+	}
+
+	return RESPValue{Type: RawString, Value: line}, nil
+}
+
+func (p *Parser) parse(parseExpression func() (RESPValue, error)) (RESPValue, error) {
+	start := p.pos
+
+	val, err := parseExpression()
+	if err != nil {
+		p.pos = start
+		return RESPValue{}, err
+	}
+
+	return val, nil
+}
+
 func (p *Parser) ParseNext() (RESPValue, error) {
-	return p.parseExpression()
+	return p.parse(p.parseExpression)
 }
 
-func (p *Parser) ParseInput(input string) (RESPValue, error) {
-	p.ts.NextInput(strings.Split(input, "\r\n"))
-	return p.ParseNext()
+func (p *Parser) ParseNextLine() (RESPValue, error) {
+	return p.parse(p.parseLine)
+}
+
+// ReadRaw consumes buffered input that is not RESP encoded, such as the body
+// of an RDB transfer.
+func (p *Parser) ReadRaw(buf []byte) int {
+	n := copy(buf, p.buffer[p.pos:])
+	p.pos += n
+	return n
+}
+
+// Feed appends input to the buffer, discarding what was already consumed once
+// that makes up most of the buffer.
+func (p *Parser) Feed(input string) {
+	if p.pos > 0 && p.pos >= len(p.buffer)/2 {
+		p.buffer = append(p.buffer[:0], p.buffer[p.pos:]...)
+		p.pos = 0
+	}
+
+	p.buffer = append(p.buffer, input...)
+}
+
+// Buffered returns the number of bytes fed to the parser that have not been
+// consumed yet.
+func (p *Parser) Buffered() int {
+	return len(p.buffer) - p.pos
 }
 
 func (p *Parser) GetArgs(arr RESPValue) (ParseInfo, error) {
 	args, ok := arr.Value.([]RESPValue)
-	if !ok {
+	if !ok || len(args) == 0 {
 		str, err := arr.ToString()
 		if err != nil {
 			return ParseInfo{}, fmt.Errorf("RESPValue is not an array and could not convert to string: %v", err)
@@ -136,6 +220,10 @@ func (p *Parser) GetArgs(arr RESPValue) (ParseInfo, error) {
 		return ParseInfo{}, fmt.Errorf("RESPValue is not an array, value is %s", str)
 	}
 
-	command := strings.ToUpper(args[0].Value.(string))
-	return ParseInfo{Command: command, Args: args[1:]}, nil
+	command, ok := args[0].Value.(string)
+	if !ok {
+		return ParseInfo{}, fmt.Errorf("RESPValue command is not a string")
+	}
+
+	return ParseInfo{Command: strings.ToUpper(command), Args: args[1:]}, nil
 }
@@ -1,6 +1,46 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
 type PersistenceInfo struct {
-	Dir        string
-	Dbfilename string
+	Dir                      string
+	Dbfilename               string
+	SavePoints               []SavePoint
+	AppendOnly               bool
+	AppendFilename           string
+	AppendDirname            string
+	AutoAofRewritePercentage int
+	AutoAofRewriteMinSize    int64
+	LastSave                 time.Time
+	LastBgsaveTry            time.Time
+	LastBgsaveStatus         string
+	BgsaveInProgress         bool
+	lock                     sync.Mutex
+}
+
+func (info *PersistenceInfo) Acquire() {
+	info.lock.Lock()
+}
+
+func (info *PersistenceInfo) Release() {
+	info.lock.Unlock()
+}
+
+func (info *PersistenceInfo) ToString(changesSinceLastSave int) string {
+	info.Acquire()
+	defer info.Release()
+
+	sb := strings.Builder{}
+	WriteLine(&sb, "# Persistence")
+	WriteLine(&sb, fmt.Sprintf("rdb_changes_since_last_save:%d", changesSinceLastSave))
+	WriteLine(&sb, fmt.Sprintf("rdb_bgsave_in_progress:%d", boolToInt(info.BgsaveInProgress)))
+	WriteLine(&sb, fmt.Sprintf("rdb_last_save_time:%d", info.LastSave.Unix()))
+	WriteLine(&sb, fmt.Sprintf("rdb_last_bgsave_status:%s", info.LastBgsaveStatus))
+
+	return sb.String()
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// PubSub delivers messages published to a channel to the subscribers of the
+// channel and of the patterns matching it. Shard channels are a separate
+// namespace, without patterns, whose channels hash to slots like keys.
+type PubSub struct {
+	channels      map[string]map[*Subscriber]bool
+	patterns      map[string]map[*Subscriber]bool
+	shardChannels map[string]map[*Subscriber]bool
+	lock          sync.Mutex
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{channels: map[string]map[*Subscriber]bool{}, patterns: map[string]map[*Subscriber]bool{}, shardChannels: map[string]map[*Subscriber]bool{}}
+}
+
+func subscriptionRESP(kind string, name RESPValue, count int) RESPValue {
+	return RESPValue{Type: Array, Value: []RESPValue{{Type: BulkString, Value: kind}, name, {Type: Integer, Value: count}}}
+}
+
+// subscribe adds sub to registry under each name and confirms each with a
+// reply of the given kind carrying count. The reply is queued before any
+// message sent to the new subscription.
+func (ps *PubSub) subscribe(registry map[string]map[*Subscriber]bool, own map[string]bool, kind string, count func() int, sub *Subscriber, names []string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, name := range names {
+		if !own[name] {
+			own[name] = true
+			if registry[name] == nil {
+				registry[name] = map[*Subscriber]bool{}
+			}
+			registry[name][sub] = true
+		}
+
+		sub.Send(subscriptionRESP(kind, RESPValue{Type: BulkString, Value: name}, count()))
+	}
+}
+
+// unsubscribe removes sub from registry under each name, or under every name
+// it is subscribed to when names is empty, confirming each with a reply of
+// the given kind carrying count.
+func (ps *PubSub) unsubscribe(registry map[string]map[*Subscriber]bool, own map[string]bool, kind string, count func() int, sub *Subscriber, names []string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	if len(names) == 0 {
+		sub.Send(subscriptionRESP(kind, RESPValue{Type: NullBulkString}, count()))
+		return
+	}
+
+	for _, name := range names {
+		if own[name] {
+			delete(own, name)
+			delete(registry[name], sub)
+			if len(registry[name]) == 0 {
+				delete(registry, name)
+			}
+		}
+
+		sub.Send(subscriptionRESP(kind, RESPValue{Type: BulkString, Value: name}, count()))
+	}
+}
+
+func (ps *PubSub) Subscribe(sub *Subscriber, channels []string) {
+	ps.subscribe(ps.channels, sub.channels, "subscribe", sub.Count, sub, channels)
+}
+
+func (ps *PubSub) Unsubscribe(sub *Subscriber, channels []string) {
+	ps.unsubscribe(ps.channels, sub.channels, "unsubscribe", sub.Count, sub, channels)
+}
+
+func (ps *PubSub) PSubscribe(sub *Subscriber, patterns []string) {
+	ps.subscribe(ps.patterns, sub.patterns, "psubscribe", sub.Count, sub, patterns)
+}
+
+func (ps *PubSub) PUnsubscribe(sub *Subscriber, patterns []string) {
+	ps.unsubscribe(ps.patterns, sub.patterns, "punsubscribe", sub.Count, sub, patterns)
+}
+
+func (ps *PubSub) SSubscribe(sub *Subscriber, channels []string) {
+	ps.subscribe(ps.shardChannels, sub.shardChannels, "ssubscribe", sub.ShardCount, sub, channels)
+}
+
+func (ps *PubSub) SUnsubscribe(sub *Subscriber, channels []string) {
+	ps.unsubscribe(ps.shardChannels, sub.shardChannels, "sunsubscribe", sub.ShardCount, sub, channels)
+}
+
+// Remove drops every subscription of sub, for when its connection closes.
+func (ps *PubSub) Remove(sub *Subscriber) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for channel := range sub.channels {
+		delete(ps.channels[channel], sub)
+		if len(ps.channels[channel]) == 0 {
+			delete(ps.channels, channel)
+		}
+	}
+
+	for pattern := range sub.patterns {
+		delete(ps.patterns[pattern], sub)
+		if len(ps.patterns[pattern]) == 0 {
+			delete(ps.patterns, pattern)
+		}
+	}
+
+	for channel := range sub.shardChannels {
+		delete(ps.shardChannels[channel], sub)
+		if len(ps.shardChannels[channel]) == 0 {
+			delete(ps.shardChannels, channel)
+		}
+	}
+
+	sub.channels = map[string]bool{}
+	sub.patterns = map[string]bool{}
+	sub.shardChannels = map[string]bool{}
+}
+
+// Counts returns how many channels, patterns and shard channels sub is
+// subscribed to.
+func (ps *PubSub) Counts(sub *Subscriber) (int, int, int) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return len(sub.channels), len(sub.patterns), len(sub.shardChannels)
+}
+
+// Subscriptions returns how many channels, patterns and shard channels sub is
+// subscribed to.
+func (ps *PubSub) Subscriptions(sub *Subscriber) int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return sub.Count() + sub.ShardCount()
+}
+
+// Publish sends message to the subscribers of channel and of the patterns
+// matching it, and returns how many messages were sent.
+func (ps *PubSub) Publish(channel string, message string) int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	receivers := 0
+	for sub := range ps.channels[channel] {
+		sub.Send(CommandRESP("message", channel, message))
+		receivers += 1
+	}
+
+	for pattern, subs := range ps.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+
+		for sub := range subs {
+			sub.Send(CommandRESP("pmessage", pattern, channel, message))
+			receivers += 1
+		}
+	}
+
+	return receivers
+}
+
+// SPublish sends message to the subscribers of the shard channel, and returns
+// how many messages were sent.
+func (ps *PubSub) SPublish(channel string, message string) int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for sub := range ps.shardChannels[channel] {
+		sub.Send(CommandRESP("smessage", channel, message))
+	}
+
+	return len(ps.shardChannels[channel])
+}
+
+// Channels lists the channels with subscribers that match pattern.
+func (ps *PubSub) Channels(pattern string) []string {
+	return ps.matching(ps.channels, pattern)
+}
+
+// ShardChannels lists the shard channels with subscribers that match pattern.
+func (ps *PubSub) ShardChannels(pattern string) []string {
+	return ps.matching(ps.shardChannels, pattern)
+}
+
+func (ps *PubSub) matching(registry map[string]map[*Subscriber]bool, pattern string) []string {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	channels := []string{}
+	for channel := range registry {
+		if globMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+func (ps *PubSub) NumSub(channel string) int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return len(ps.channels[channel])
+}
+
+func (ps *PubSub) ShardNumSub(channel string) int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return len(ps.shardChannels[channel])
+}
+
+func (ps *PubSub) NumPat() int {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return len(ps.patterns)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// messageFields joins the fields of a message pushed to a subscriber.
+func messageFields(resp RESPValue) string {
+	fields := []string{}
+	for _, field := range resp.Value.([]RESPValue) {
+		fields = append(fields, fmt.Sprint(field.Value))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// expectMessage reads the next message pushed to a subscriber and checks its
+// fields, in order.
+func expectMessage(t *testing.T, subscriber *testClient, fields ...string) {
+	t.Helper()
+
+	resp, err := subscriber.receive()
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+
+	if got := messageFields(resp); got != strings.Join(fields, " ") {
+		t.Fatalf("subscriber got %s, want %v", got, fields)
+	}
+}
+
+func TestPatternSubscribersReceiveMatchingMessages(t *testing.T) {
+	port := startTestServer(t, "")
+
+	subscriber := newTestClient(t, port)
+	subscriber.do("PSUBSCRIBE", "news.*", "*.sport")
+	expectMessage(t, subscriber, "psubscribe", "*.sport", "2")
+	if resp := subscriber.do("SUBSCRIBE", "news.sport"); messageFields(resp) != "subscribe news.sport 3" {
+		t.Fatalf("SUBSCRIBE returned %v", resp)
+	}
+
+	publisher := newTestClient(t, port)
+	if resp := publisher.do("PUBLISH", "news.sport", "goal"); resp.Value != 3 {
+		t.Fatalf("PUBLISH to a channel and two matching patterns returned %v", resp)
+	}
+	expectMessage(t, subscriber, "message", "news.sport", "goal")
+
+	// Patterns are matched in no particular order.
+	pending := map[string]bool{"pmessage news.* news.sport goal": true, "pmessage *.sport news.sport goal": true}
+	for range 2 {
+		resp, err := subscriber.receive()
+		if err != nil {
+			t.Fatalf("failed to read message: %v", err)
+		}
+		if !pending[messageFields(resp)] {
+			t.Fatalf("pattern subscriber got %s, want one of %v", messageFields(resp), pending)
+		}
+		delete(pending, messageFields(resp))
+	}
+
+	if resp := publisher.do("PUBLISH", "weather", "rain"); resp.Value != 0 {
+		t.Fatalf("PUBLISH to a channel nobody matches returned %v", resp)
+	}
+	if resp := publisher.do("PUBLISH", "news.weather", "rain"); resp.Value != 1 {
+		t.Fatalf("PUBLISH to a channel one pattern matches returned %v", resp)
+	}
+	expectMessage(t, subscriber, "pmessage", "news.*", "news.weather", "rain")
+
+	if resp := subscriber.do("PUNSUBSCRIBE", "news.*"); messageFields(resp) != "punsubscribe news.* 2" {
+		t.Fatalf("PUNSUBSCRIBE returned %v", resp)
+	}
+	if resp := publisher.do("PUBLISH", "news.weather", "sun"); resp.Value != 0 {
+		t.Fatalf("PUBLISH after PUNSUBSCRIBE returned %v", resp)
+	}
+}
+
+func TestShardSubscribersReceiveOnlyShardMessages(t *testing.T) {
+	port := startTestServer(t, "")
+
+	subscriber := newTestClient(t, port)
+	if resp := subscriber.do("SSUBSCRIBE", "orders"); messageFields(resp) != "ssubscribe orders 1" {
+		t.Fatalf("SSUBSCRIBE returned %v", resp)
+	}
+	if resp := subscriber.do("SUBSCRIBE", "orders"); messageFields(resp) != "subscribe orders 1" {
+		t.Fatalf("SUBSCRIBE returned %v", resp)
+	}
+
+	publisher := newTestClient(t, port)
+	if resp := publisher.do("SPUBLISH", "orders", "42"); resp.Value != 1 {
+		t.Fatalf("SPUBLISH returned %v", resp)
+	}
+	expectMessage(t, subscriber, "smessage", "orders", "42")
+
+	// Shard channels and channels of the same name are separate.
+	if resp := publisher.do("PUBLISH", "orders", "43"); resp.Value != 1 {
+		t.Fatalf("PUBLISH returned %v", resp)
+	}
+	expectMessage(t, subscriber, "message", "orders", "43")
+
+	if resp := subscriber.do("SUNSUBSCRIBE"); messageFields(resp) != "sunsubscribe orders 0" {
+		t.Fatalf("SUNSUBSCRIBE returned %v", resp)
+	}
+	if resp := publisher.do("SPUBLISH", "orders", "44"); resp.Value != 0 {
+		t.Fatalf("SPUBLISH after SUNSUBSCRIBE returned %v", resp)
+	}
+	if resp := publisher.do("PUBLISH", "orders", "45"); resp.Value != 1 {
+		t.Fatalf("PUBLISH after SUNSUBSCRIBE returned %v", resp)
+	}
+	expectMessage(t, subscriber, "message", "orders", "45")
+}
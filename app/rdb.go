@@ -0,0 +1,39 @@
+package main
+
+import "hash/crc64"
+
+const (
+	rdbVersion = 11
+
+	rdbOpcodeFunction2    = 0xF5
+	rdbOpcodeModuleAux    = 0xF7
+	rdbOpcodeIdle         = 0xF8
+	rdbOpcodeFreq         = 0xF9
+	rdbOpcodeAux          = 0xFA
+	rdbOpcodeResizeDB     = 0xFB
+	rdbOpcodeExpireTimeMs = 0xFC
+	rdbOpcodeExpireTime   = 0xFD
+	rdbOpcodeSelectDB     = 0xFE
+	rdbOpcodeEOF          = 0xFF
+
+	rdbTypeString           = 0
+	rdbTypeStreamListpacks  = 15
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeStreamListpacks3 = 21
+
+	rdbEncodingInt8  = 0
+	rdbEncodingInt16 = 1
+	rdbEncodingInt32 = 2
+	rdbEncodingLZF   = 3
+
+	streamItemFlagDeleted    = 1
+	streamItemFlagSameFields = 2
+	streamNodeMaxEntries     = 100
+)
+
+// rdbCRCTable is the reflected Jones polynomial Redis uses for RDB checksums.
+var rdbCRCTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+func rdbChecksum(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, rdbCRCTable, p)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const bgsaveRetryDelay = 5 * time.Second
+
+func (rs *RedisServer) rdbPath() (string, string) {
+	rs.ServerInfo.Persistence.Acquire()
+	defer rs.ServerInfo.Persistence.Release()
+
+	return rs.ServerInfo.Persistence.Dir, rs.ServerInfo.Persistence.Dbfilename
+}
+
+// writeRDBFile writes the snapshot to a temporary file in dir and renames it
+// over the RDB file, so a crash mid-save never leaves a partial RDB behind.
+func writeRDBFile(dir string, dbfilename string, snapshot *DatabaseSnapshot) error {
+	return replaceRDBFile(dir, dbfilename, func(w io.Writer) error {
+		return NewRDBWriter(w).WriteSnapshot(snapshot)
+	})
+}
+
+// replaceRDBFile writes an RDB file with write, through a temporary file that
+// is renamed into place once complete.
+func replaceRDBFile(dir string, dbfilename string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf("temp-%d-*.rdb", os.Getpid()))
+	if err != nil {
+		return fmt.Errorf("failed to create temp RDB file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp RDB file: %v", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temp RDB file: %v", err)
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to set temp RDB file permissions: %v", err)
+	}
+
+	err = os.Rename(tmp.Name(), filepath.Join(dir, dbfilename))
+	if err != nil {
+		return fmt.Errorf("failed to rename temp RDB file: %v", err)
+	}
+
+	return nil
+}
+
+func (rs *RedisServer) recordSave(snapshot *DatabaseSnapshot) {
+	rs.Database.ClearDirty(snapshot.Dirty())
+
+	rs.ServerInfo.Persistence.Acquire()
+	rs.ServerInfo.Persistence.LastSave = time.Now()
+	rs.ServerInfo.Persistence.Release()
+}
+
+func (rs *RedisServer) saveSnapshot(snapshot *DatabaseSnapshot) error {
+	dir, dbfilename := rs.rdbPath()
+	err := writeRDBFile(dir, dbfilename, snapshot)
+	snapshot.Release()
+	if err != nil {
+		return err
+	}
+
+	rs.recordSave(snapshot)
+	return nil
+}
+
+// saveSnapshotForSync saves the snapshot and opens the RDB file before the
+// snapshot is released, so no later save can replace the file first.
+func (rs *RedisServer) saveSnapshotForSync(snapshot *DatabaseSnapshot) (*os.File, error) {
+	dir, dbfilename := rs.rdbPath()
+	err := writeRDBFile(dir, dbfilename, snapshot)
+	if err != nil {
+		snapshot.Release()
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(dir, dbfilename))
+	snapshot.Release()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RDB file: %v", err)
+	}
+
+	rs.recordSave(snapshot)
+	return file, nil
+}
+
+// snapshotForSave freezes the database for SAVE and BGSAVE, which fail rather
+// than wait while another snapshot is in progress. It is frozen under the
+// write lock, so no transaction or script is half applied in it; held tells
+// the caller holds the lock already, as a SAVE in a transaction does.
+func (rs *RedisServer) snapshotForSave(held bool) (*DatabaseSnapshot, error) {
+	if held {
+		return rs.Database.Snapshot()
+	}
+
+	select {
+	case <-rs.Database.SnapshotReleased():
+	default:
+		return nil, fmt.Errorf("snapshot already in progress")
+	}
+
+	return rs.snapshotAtomically(context.Background(), func() error { return nil })
+}
+
+// Save writes the RDB file before returning.
+func (rs *RedisServer) Save(held bool) error {
+	snapshot, err := rs.snapshotForSave(held)
+	if err != nil {
+		return fmt.Errorf("background save already in progress")
+	}
+
+	return rs.saveSnapshot(snapshot)
+}
+
+// BackgroundSave freezes the database and writes the RDB file from another
+// goroutine, so clients keep being served while the file is written.
+func (rs *RedisServer) BackgroundSave(held bool) error {
+	snapshot, err := rs.snapshotForSave(held)
+	if err != nil {
+		return fmt.Errorf("background save already in progress")
+	}
+
+	rs.ServerInfo.Persistence.Acquire()
+	rs.ServerInfo.Persistence.BgsaveInProgress = true
+	rs.ServerInfo.Persistence.LastBgsaveTry = time.Now()
+	rs.ServerInfo.Persistence.Release()
+
+	go func() {
+		err := rs.saveSnapshot(snapshot)
+
+		status := "ok"
+		if err != nil {
+			fmt.Printf("failed to run background save: %v\n", err)
+			status = "err"
+		}
+
+		rs.ServerInfo.Persistence.Acquire()
+		rs.ServerInfo.Persistence.BgsaveInProgress = false
+		rs.ServerInfo.Persistence.LastBgsaveStatus = status
+		rs.ServerInfo.Persistence.Release()
+	}()
+
+	return nil
+}
+
+func (rs *RedisServer) savePointReached() bool {
+	dirty := rs.Database.Dirty()
+
+	rs.ServerInfo.Persistence.Acquire()
+	defer rs.ServerInfo.Persistence.Release()
+
+	info := rs.ServerInfo.Persistence
+	if info.BgsaveInProgress {
+		return false
+	}
+
+	if info.LastBgsaveStatus == "err" && time.Since(info.LastBgsaveTry) < bgsaveRetryDelay {
+		return false
+	}
+
+	for _, savePoint := range info.SavePoints {
+		elapsed := time.Since(info.LastSave)
+		if dirty >= savePoint.Changes && elapsed >= time.Duration(savePoint.Seconds)*time.Second {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rs *RedisServer) persistenceCron(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if rs.savePointReached() {
+				rs.BackgroundSave(false)
+			} else if rs.appendOnlyRewriteNeeded() {
+				rs.BackgroundRewriteAppendOnly()
+			}
+
+			err := rs.AOF.FlushToDisk()
+			if err != nil {
+				fmt.Printf("failed to fsync append only file: %v\n", err)
+			}
+		}
+	}
+}
+
+func (rs *RedisServer) loadRDB() error {
+	dir, dbfilename := rs.rdbPath()
+
+	file, err := os.Open(filepath.Join(dir, dbfilename))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open RDB file: %v", err)
+	}
+	defer file.Close()
+
+	err = NewRDBReader(file).Load(rs.Database)
+	if err != nil {
+		return err
+	}
+
+	rs.Database.ClearDirty(rs.Database.Dirty())
+	return nil
+}
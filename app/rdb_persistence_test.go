@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// loadSavedRDB reads the RDB file the server last saved into a new database.
+func loadSavedRDB(t *testing.T, rs *RedisServer) *Database {
+	t.Helper()
+
+	dir, dbfilename := rs.rdbPath()
+	file, err := os.Open(filepath.Join(dir, dbfilename))
+	if err != nil {
+		t.Fatalf("failed to open RDB file: %v", err)
+	}
+	defer file.Close()
+
+	database := NewDatabase()
+	err = NewRDBReader(file).Load(database)
+	if err != nil {
+		t.Fatalf("failed to load RDB file: %v", err)
+	}
+
+	return database
+}
+
+func TestSaveNeverSnapshotsHalfAppliedTransaction(t *testing.T) {
+	rs, port := runTestServer(t, "")
+
+	client := newTestClient(t, port)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 20; i++ {
+			commands := [][]string{{"MULTI"}, {"SET", "first", strconv.Itoa(i)}}
+			for j := 0; j < 2000; j++ {
+				commands = append(commands, []string{"SET", "padding", strconv.Itoa(j)})
+			}
+			commands = append(commands, []string{"SET", "last", strconv.Itoa(i)}, []string{"EXEC"})
+
+			for _, args := range commands {
+				err := client.conn.RespondRESP(CommandRESP(args...))
+				if err != nil {
+					t.Errorf("%s failed: %v", args[0], err)
+					return
+				}
+			}
+			for range commands {
+				_, err := client.receive()
+				if err != nil {
+					t.Errorf("failed to read reply: %v", err)
+					return
+				}
+			}
+		}
+	}()
+
+	for saved := 0; ; saved++ {
+		select {
+		case <-done:
+			if saved == 0 {
+				t.Fatalf("no save completed while transactions ran")
+			}
+			return
+		default:
+		}
+
+		err := rs.Save(false)
+		if err != nil {
+			t.Fatalf("SAVE failed: %v", err)
+		}
+
+		database := loadSavedRDB(t, rs)
+		first, last := database.GetValue("first"), database.GetValue("last")
+		if first.Value != last.Value {
+			t.Fatalf("RDB has first=%v and last=%v from different transactions", first.Value, last.Value)
+		}
+	}
+}
+
+func TestSaveInTransaction(t *testing.T) {
+	rs, port := runTestServer(t, "")
+
+	client := newTestClient(t, port)
+	replies := client.pipeline([]string{"MULTI"}, []string{"SET", "key", "1"}, []string{"SAVE"}, []string{"EXEC"})
+	results, ok := replies[3].Value.([]RESPValue)
+	if !ok || len(results) != 2 || results[1].Value != "OK" {
+		t.Fatalf("EXEC returned %v", replies[3])
+	}
+
+	if val := loadSavedRDB(t, rs).GetValue("key"); val.Value != "1" {
+		t.Fatalf("saved key was %v", val)
+	}
+
+	if resp := client.do("EVAL", "return redis.call('SAVE')", "0"); resp.Type != SimpleError {
+		t.Fatalf("SAVE from a script returned %v", resp)
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RDBReader struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+}
+
+func NewRDBReader(r io.Reader) *RDBReader {
+	return &RDBReader{r: bufio.NewReader(r), crc: 0, version: 0}
+}
+
+func (rr *RDBReader) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(rr.r, buf)
+	if err != nil {
+		return []byte{}, fmt.Errorf("failed to read RDB: %v", err)
+	}
+
+	rr.crc = rdbChecksum(rr.crc, buf)
+	return buf, nil
+}
+
+func (rr *RDBReader) readByte() (byte, error) {
+	buf, err := rr.read(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return buf[0], nil
+}
+
+// readLength returns a length encoded value, or the special string encoding
+// when the top two bits are set.
+func (rr *RDBReader) readLength() (uint64, bool, error) {
+	first, err := rr.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3F), false, nil
+	case 1:
+		next, err := rr.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		if first == 0x80 {
+			buf, err := rr.read(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		} else if first == 0x81 {
+			buf, err := rr.read(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("failed to read RDB length: invalid encoding %x", first)
+	default:
+		return uint64(first & 0x3F), true, nil
+	}
+}
+
+func (rr *RDBReader) readPlainLength() (uint64, error) {
+	length, special, err := rr.readLength()
+	if err != nil {
+		return 0, err
+	}
+
+	if special {
+		return 0, fmt.Errorf("failed to read RDB length: unexpected string encoding")
+	}
+
+	return length, nil
+}
+
+func (rr *RDBReader) readString() (string, error) {
+	length, special, err := rr.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	if !special {
+		buf, err := rr.read(int(length))
+		return string(buf), err
+	}
+
+	switch length {
+	case rdbEncodingInt8:
+		buf, err := rr.read(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(buf[0]))), nil
+	case rdbEncodingInt16:
+		buf, err := rr.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case rdbEncodingInt32:
+		buf, err := rr.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case rdbEncodingLZF:
+		return rr.readLZFString()
+	default:
+		return "", fmt.Errorf("failed to read RDB string: invalid encoding %d", length)
+	}
+}
+
+func (rr *RDBReader) readLZFString() (string, error) {
+	compressedLength, err := rr.readPlainLength()
+	if err != nil {
+		return "", err
+	}
+
+	length, err := rr.readPlainLength()
+	if err != nil {
+		return "", err
+	}
+
+	compressed, err := rr.read(int(compressedLength))
+	if err != nil {
+		return "", err
+	}
+
+	decompressed, err := LZFDecompress(compressed, int(length))
+	if err != nil {
+		return "", err
+	}
+
+	return string(decompressed), nil
+}
+
+func parseStreamIDBytes(key string) (StreamID, error) {
+	if len(key) != 16 {
+		return StreamID{}, fmt.Errorf("invalid stream node key of length %d", len(key))
+	}
+
+	return StreamID{Ms: binary.BigEndian.Uint64([]byte(key[:8])), Seq: binary.BigEndian.Uint64([]byte(key[8:]))}, nil
+}
+
+// streamNodeEntries decodes the listpack of a single stream node into its
+// entries, skipping any that were marked as deleted.
+func streamNodeEntries(master StreamID, elements []string) ([]StreamEntry, error) {
+	atoi := func(i int) (int64, error) {
+		if i >= len(elements) {
+			return 0, fmt.Errorf("failed to decode stream node: truncated listpack")
+		}
+		return strconv.ParseInt(elements[i], 10, 64)
+	}
+
+	numFields, err := atoi(2)
+	if err != nil {
+		return []StreamEntry{}, err
+	}
+
+	if 3+int(numFields) >= len(elements) {
+		return []StreamEntry{}, fmt.Errorf("failed to decode stream node: truncated master entry")
+	}
+	masterFields := elements[3 : 3+numFields]
+
+	entries := []StreamEntry{}
+	i := 4 + int(numFields)
+	for i < len(elements) {
+		flags, err := atoi(i)
+		if err != nil {
+			return []StreamEntry{}, err
+		}
+
+		msDiff, err := atoi(i + 1)
+		if err != nil {
+			return []StreamEntry{}, err
+		}
+
+		seqDiff, err := atoi(i + 2)
+		if err != nil {
+			return []StreamEntry{}, err
+		}
+		i += 3
+
+		fields := []Pair{}
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range masterFields {
+				if i >= len(elements) {
+					return []StreamEntry{}, fmt.Errorf("failed to decode stream node: truncated entry")
+				}
+				fields = append(fields, Pair{Key: field, Val: elements[i]})
+				i += 1
+			}
+		} else {
+			count, err := atoi(i)
+			if err != nil {
+				return []StreamEntry{}, err
+			}
+			i += 1
+
+			for j := 0; j < int(count); j++ {
+				if i+1 >= len(elements) {
+					return []StreamEntry{}, fmt.Errorf("failed to decode stream node: truncated entry")
+				}
+				fields = append(fields, Pair{Key: elements[i], Val: elements[i+1]})
+				i += 2
+			}
+		}
+
+		// skip lp-count
+		i += 1
+
+		if flags&streamItemFlagDeleted != 0 {
+			continue
+		}
+
+		id := StreamID{Ms: master.Ms + uint64(msDiff), Seq: master.Seq + uint64(seqDiff)}
+		entries = append(entries, StreamEntry{Id: id.String(), Fields: fields})
+	}
+
+	return entries, nil
+}
+
+func (rr *RDBReader) skipConsumerGroups(valueType byte) error {
+	groups, err := rr.readPlainLength()
+	if err != nil {
+		return err
+	}
+
+	for g := uint64(0); g < groups; g++ {
+		_, err = rr.readString()
+		if err != nil {
+			return err
+		}
+
+		fields := 2
+		if valueType >= rdbTypeStreamListpacks2 {
+			fields = 3
+		}
+		for i := 0; i < fields; i++ {
+			_, err = rr.readPlainLength()
+			if err != nil {
+				return err
+			}
+		}
+
+		pending, err := rr.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for p := uint64(0); p < pending; p++ {
+			_, err = rr.read(16 + 8)
+			if err != nil {
+				return err
+			}
+			_, err = rr.readPlainLength()
+			if err != nil {
+				return err
+			}
+		}
+
+		consumers, err := rr.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for c := uint64(0); c < consumers; c++ {
+			_, err = rr.readString()
+			if err != nil {
+				return err
+			}
+
+			seen := 8
+			if valueType >= rdbTypeStreamListpacks3 {
+				seen = 16
+			}
+			_, err = rr.read(seen)
+			if err != nil {
+				return err
+			}
+
+			owned, err := rr.readPlainLength()
+			if err != nil {
+				return err
+			}
+			_, err = rr.read(16 * int(owned))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (rr *RDBReader) readStream(name string, valueType byte) (StreamLog, error) {
+	nodes, err := rr.readPlainLength()
+	if err != nil {
+		return StreamLog{}, err
+	}
+
+	entries := []StreamEntry{}
+	for n := uint64(0); n < nodes; n++ {
+		key, err := rr.readString()
+		if err != nil {
+			return StreamLog{}, err
+		}
+
+		master, err := parseStreamIDBytes(key)
+		if err != nil {
+			return StreamLog{}, err
+		}
+
+		lp, err := rr.readString()
+		if err != nil {
+			return StreamLog{}, err
+		}
+
+		elements, err := DecodeListpack([]byte(lp))
+		if err != nil {
+			return StreamLog{}, err
+		}
+
+		nodeEntries, err := streamNodeEntries(master, elements)
+		if err != nil {
+			return StreamLog{}, err
+		}
+
+		entries = append(entries, nodeEntries...)
+	}
+
+	// length and last id, followed by first id, max deleted id and entries added in newer formats
+	metadata := 3
+	if valueType >= rdbTypeStreamListpacks2 {
+		metadata = 8
+	}
+	for i := 0; i < metadata; i++ {
+		_, err = rr.readPlainLength()
+		if err != nil {
+			return StreamLog{}, err
+		}
+	}
+
+	err = rr.skipConsumerGroups(valueType)
+	if err != nil {
+		return StreamLog{}, err
+	}
+
+	return StreamLog{Name: name, Entries: entries}, nil
+}
+
+func (rr *RDBReader) readValue(key string, valueType byte) (RESPValue, error) {
+	switch valueType {
+	case rdbTypeString:
+		str, err := rr.readString()
+		return RESPValue{Type: BulkString, Value: str}, err
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		stream, err := rr.readStream(key, valueType)
+		return RESPValue{Type: Stream, Value: stream}, err
+	default:
+		return RESPValue{}, fmt.Errorf("failed to read RDB value: unsupported type %d", valueType)
+	}
+}
+
+func (rr *RDBReader) readHeader() error {
+	header, err := rr.read(9)
+	if err != nil {
+		return err
+	}
+
+	if string(header[:5]) != "REDIS" {
+		return fmt.Errorf("failed to read RDB: invalid header %q", header)
+	}
+
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version > rdbVersion {
+		return fmt.Errorf("failed to read RDB: unsupported version %q", header[5:])
+	}
+
+	rr.version = version
+	return nil
+}
+
+func (rr *RDBReader) verifyChecksum() error {
+	if rr.version < 5 {
+		return nil
+	}
+
+	expected := rr.crc
+	buf := make([]byte, 8)
+	_, err := io.ReadFull(rr.r, buf)
+	if err != nil {
+		return fmt.Errorf("failed to read RDB checksum: %v", err)
+	}
+
+	checksum := binary.LittleEndian.Uint64(buf)
+	if checksum != 0 && checksum != expected {
+		return fmt.Errorf("failed to read RDB: checksum mismatch")
+	}
+
+	return nil
+}
+
+// Load reads a complete RDB payload into database. Keys that have already
+// expired are skipped.
+func (rr *RDBReader) Load(database *Database) error {
+	err := rr.readHeader()
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Time{}
+	for {
+		opcode, err := rr.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case rdbOpcodeEOF:
+			return rr.verifyChecksum()
+		case rdbOpcodeAux:
+			_, err = rr.readString()
+			if err == nil {
+				_, err = rr.readString()
+			}
+		case rdbOpcodeResizeDB:
+			_, err = rr.readPlainLength()
+			if err == nil {
+				_, err = rr.readPlainLength()
+			}
+		case rdbOpcodeSelectDB, rdbOpcodeIdle:
+			_, err = rr.readPlainLength()
+		case rdbOpcodeFreq:
+			_, err = rr.readByte()
+		case rdbOpcodeExpireTimeMs:
+			var buf []byte
+			buf, err = rr.read(8)
+			if err == nil {
+				expiry = time.UnixMilli(int64(binary.LittleEndian.Uint64(buf)))
+			}
+		case rdbOpcodeExpireTime:
+			var buf []byte
+			buf, err = rr.read(4)
+			if err == nil {
+				expiry = time.Unix(int64(binary.LittleEndian.Uint32(buf)), 0)
+			}
+		case rdbOpcodeFunction2:
+			err = rr.loadFunction(database)
+		case rdbOpcodeModuleAux:
+			return fmt.Errorf("failed to read RDB: module data is not supported")
+		default:
+			err = rr.loadEntry(database, opcode, expiry)
+			expiry = time.Time{}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (rr *RDBReader) loadFunction(database *Database) error {
+	code, err := rr.readString()
+	if err != nil {
+		return err
+	}
+
+	library, err := LoadFunctionLibrary(code)
+	if err != nil {
+		return fmt.Errorf("failed to load function library: %v", err)
+	}
+
+	libraries, err := database.Libraries().With(library, false)
+	if err != nil {
+		return fmt.Errorf("failed to load function library: %v", err)
+	}
+
+	database.SetLibraries(libraries)
+	return nil
+}
+
+// ReadFunctions reads the libraries in a FUNCTION DUMP payload, checking the
+// RDB version and checksum at its end.
+func ReadFunctions(payload string) ([]*FunctionLibrary, error) {
+	if len(payload) < 10 {
+		return nil, fmt.Errorf("payload version or checksum are wrong")
+	}
+
+	body, footer := payload[:len(payload)-10], []byte(payload[len(payload)-10:])
+	version := binary.LittleEndian.Uint16(footer[:2])
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+	if version > rdbVersion || checksum != rdbChecksum(0, []byte(payload[:len(payload)-8])) {
+		return nil, fmt.Errorf("payload version or checksum are wrong")
+	}
+
+	rr := NewRDBReader(strings.NewReader(body))
+	libraries := []*FunctionLibrary{}
+	for {
+		_, err := rr.r.Peek(1)
+		if err == io.EOF {
+			return libraries, nil
+		}
+
+		opcode, err := rr.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if opcode != rdbOpcodeFunction2 {
+			return nil, fmt.Errorf("given type is not a function")
+		}
+
+		code, err := rr.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		library, err := LoadFunctionLibrary(code)
+		if err != nil {
+			return nil, err
+		}
+
+		libraries = append(libraries, library)
+	}
+}
+
+func (rr *RDBReader) loadEntry(database *Database, valueType byte, expiry time.Time) error {
+	key, err := rr.readString()
+	if err != nil {
+		return err
+	}
+
+	val, err := rr.readValue(key, valueType)
+	if err != nil {
+		return fmt.Errorf("failed to load key %s: %v", key, err)
+	}
+
+	result := ResultData{Value: val, Expiry: expiry}
+	if !isExpired(result) {
+		database.SetResult(key, result)
+	}
+
+	return nil
+}
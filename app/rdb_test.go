@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// emptyRedisRDB is the RDB file Redis 7.2.0 saves for an empty dataset.
+const emptyRedisRDB = "UkVESVMwMDEx+glyZWRpcy12ZXIFNy4yLjD6CnJlZGlzLWJpdHPAQPoFY3RpbWXCbQi8ZfoIdXNlZC1tZW3CsMQQAPoIYW9mLWJhc2XAAP/wbjv+wP9aog=="
+
+func TestLoadRDBSavedByRedis(t *testing.T) {
+	payload, _ := base64.StdEncoding.DecodeString(emptyRedisRDB)
+
+	database := NewDatabase()
+	err := NewRDBReader(bytes.NewReader(payload)).Load(database)
+	if err != nil {
+		t.Fatalf("failed to load RDB saved by Redis: %v", err)
+	}
+
+	if size := database.Size(); size != 0 {
+		t.Fatalf("empty RDB loaded %d keys", size)
+	}
+
+	payload[len(payload)-1] ^= 0xFF
+	err = NewRDBReader(bytes.NewReader(payload)).Load(NewDatabase())
+	if err == nil {
+		t.Fatalf("RDB with a corrupt checksum loaded")
+	}
+}
+
+func TestReadLZFString(t *testing.T) {
+	// An LZF encoded string of 30 bytes compressed to 5: "a" then a back
+	// reference copying it 29 times.
+	payload := []byte{0xC3, 0x05, 0x1E, 0x00, 'a', 0xE0, 0x14, 0x00}
+
+	s, err := NewRDBReader(bytes.NewReader(payload)).readString()
+	if err != nil {
+		t.Fatalf("failed to read LZF string: %v", err)
+	}
+
+	if want := string(bytes.Repeat([]byte{'a'}, 30)); s != want {
+		t.Fatalf("read %q, want %q", s, want)
+	}
+}
+
+func TestRDBRoundTrip(t *testing.T) {
+	rs, port := runTestServer(t, "")
+	client := newTestClient(t, port)
+
+	client.do("SET", "plain", "value")
+	client.do("SET", "number", "-12345")
+	client.do("SET", "volatile", "soon gone", "EX", "1000")
+	client.do("SET", "empty", "")
+
+	commands := [][]string{}
+	for i := 1; i <= 250; i++ {
+		fields := []string{"name", "entry" + strconv.Itoa(i), "count", strconv.Itoa(i)}
+		if i%7 == 0 {
+			fields = []string{"other", "fields"}
+		}
+		commands = append(commands, append([]string{"XADD", "stream", strconv.Itoa(i) + "-" + strconv.Itoa(i%3)}, fields...))
+	}
+	client.pipeline(commands...)
+
+	library := "#!lua name=saved\nredis.register_function('hello', function() return 'hello' end)"
+	if resp := client.do("FUNCTION", "LOAD", library); !isBulk(resp, "saved") {
+		t.Fatalf("FUNCTION LOAD returned %v", resp)
+	}
+
+	if resp := client.do("SAVE"); resp.Value != "OK" {
+		t.Fatalf("SAVE returned %v", resp)
+	}
+
+	loaded := loadSavedRDB(t, rs)
+	if size := loaded.Size(); size != 5 {
+		t.Fatalf("loaded %d keys, want 5", size)
+	}
+
+	for _, key := range []string{"plain", "number", "volatile", "empty", "stream"} {
+		saved, _ := rs.Database.lookup(key)
+		val, ok := loaded.lookup(key)
+		if !ok {
+			t.Fatalf("key %s wasn't loaded", key)
+		}
+
+		if !reflect.DeepEqual(val.Value, saved.Value) {
+			t.Fatalf("key %s loaded as %v, saved as %v", key, val.Value, saved.Value)
+		}
+
+		if val.Expiry.UnixMilli() != saved.Expiry.UnixMilli() || val.Expiry.IsZero() != saved.Expiry.IsZero() {
+			t.Fatalf("key %s loaded expiring at %v, saved expiring at %v", key, val.Expiry, saved.Expiry)
+		}
+	}
+
+	libraries := loaded.Libraries()
+	if saved, ok := libraries["saved"]; !ok || saved.code != library {
+		t.Fatalf("function library wasn't loaded: %v", libraries)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type RDBWriter struct {
+	w   *bufio.Writer
+	crc uint64
+}
+
+func NewRDBWriter(w io.Writer) *RDBWriter {
+	return &RDBWriter{w: bufio.NewWriter(w), crc: 0}
+}
+
+func (rw *RDBWriter) write(p []byte) error {
+	rw.crc = rdbChecksum(rw.crc, p)
+	_, err := rw.w.Write(p)
+	return err
+}
+
+func (rw *RDBWriter) writeByte(b byte) error {
+	return rw.write([]byte{b})
+}
+
+func (rw *RDBWriter) writeLength(length uint64) error {
+	switch {
+	case length < 1<<6:
+		return rw.write([]byte{byte(length)})
+	case length < 1<<14:
+		return rw.write([]byte{0x40 | byte(length>>8), byte(length)})
+	case length <= 0xFFFFFFFF:
+		return rw.write(binary.BigEndian.AppendUint32([]byte{0x80}, uint32(length)))
+	default:
+		return rw.write(binary.BigEndian.AppendUint64([]byte{0x81}, length))
+	}
+}
+
+func (rw *RDBWriter) writeString(s string) error {
+	err := rw.writeLength(uint64(len(s)))
+	if err != nil {
+		return err
+	}
+
+	return rw.write([]byte(s))
+}
+
+func (rw *RDBWriter) writeAux(key string, val string) error {
+	err := rw.writeByte(rdbOpcodeAux)
+	if err != nil {
+		return err
+	}
+
+	err = rw.writeString(key)
+	if err != nil {
+		return err
+	}
+
+	return rw.writeString(val)
+}
+
+func (rw *RDBWriter) writeHeader() error {
+	err := rw.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+	if err != nil {
+		return err
+	}
+
+	aux := []Pair{
+		{Key: "redis-ver", Val: redisVersion},
+		{Key: "redis-bits", Val: "64"},
+		{Key: "ctime", Val: strconv.FormatInt(time.Now().Unix(), 10)},
+		{Key: "aof-base", Val: "0"},
+	}
+
+	for _, pair := range aux {
+		err = rw.writeAux(pair.Key, pair.Val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func streamIDBytes(id StreamID) string {
+	key := binary.BigEndian.AppendUint64([]byte{}, id.Ms)
+	return string(binary.BigEndian.AppendUint64(key, id.Seq))
+}
+
+func sameFieldNames(a []Pair, b []Pair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Key != b[i].Key {
+			return false
+		}
+	}
+
+	return true
+}
+
+func streamNodeListpack(master StreamID, ids []StreamID, entries []StreamEntry) []byte {
+	masterFields := entries[0].Fields
+
+	lp := NewListpack()
+	lp.AppendInt(int64(len(entries)))
+	lp.AppendInt(0)
+	lp.AppendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.AppendString(field.Key)
+	}
+	lp.AppendInt(0)
+
+	for i, entry := range entries {
+		flags := 0
+		if sameFieldNames(entry.Fields, masterFields) {
+			flags |= streamItemFlagSameFields
+		}
+
+		lp.AppendInt(int64(flags))
+		lp.AppendInt(int64(ids[i].Ms - master.Ms))
+		lp.AppendInt(int64(ids[i].Seq - master.Seq))
+
+		if flags&streamItemFlagSameFields != 0 {
+			for _, field := range entry.Fields {
+				lp.AppendString(field.Val)
+			}
+			lp.AppendInt(int64(len(entry.Fields) + 3))
+		} else {
+			lp.AppendInt(int64(len(entry.Fields)))
+			for _, field := range entry.Fields {
+				lp.AppendString(field.Key)
+				lp.AppendString(field.Val)
+			}
+			lp.AppendInt(int64(2*len(entry.Fields) + 4))
+		}
+	}
+
+	return lp.Bytes()
+}
+
+func (rw *RDBWriter) writeStream(stream StreamLog) error {
+	ids := make([]StreamID, len(stream.Entries))
+	for i, entry := range stream.Entries {
+		id, err := ParseStreamID(entry.Id)
+		if err != nil {
+			return fmt.Errorf("failed to write stream %s: %v", stream.Name, err)
+		}
+		ids[i] = id
+	}
+
+	nodes := (len(stream.Entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	err := rw.writeLength(uint64(nodes))
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(stream.Entries); start += streamNodeMaxEntries {
+		end := Min(start+streamNodeMaxEntries, len(stream.Entries))
+
+		err = rw.writeString(streamIDBytes(ids[start]))
+		if err != nil {
+			return err
+		}
+
+		err = rw.writeString(string(streamNodeListpack(ids[start], ids[start:end], stream.Entries[start:end])))
+		if err != nil {
+			return err
+		}
+	}
+
+	lastID := StreamID{}
+	if len(ids) > 0 {
+		lastID = ids[len(ids)-1]
+	}
+
+	for _, length := range []uint64{uint64(len(stream.Entries)), lastID.Ms, lastID.Seq, 0} {
+		err = rw.writeLength(length)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rdbValueType(val RESPValue) (byte, error) {
+	switch val.Type {
+	case BulkString, SimpleString:
+		return rdbTypeString, nil
+	case Stream:
+		return rdbTypeStreamListpacks, nil
+	default:
+		return 0, fmt.Errorf("unsupported value type %d", val.Type)
+	}
+}
+
+func (rw *RDBWriter) writeValue(val RESPValue) error {
+	switch val.Type {
+	case Stream:
+		return rw.writeStream(val.Value.(StreamLog))
+	default:
+		return rw.writeString(val.Value.(string))
+	}
+}
+
+func (rw *RDBWriter) writeEntry(key string, val ResultData) error {
+	valueType, err := rdbValueType(val.Value)
+	if err != nil {
+		return fmt.Errorf("failed to write key %s: %v", key, err)
+	}
+
+	if !val.Expiry.IsZero() {
+		err = rw.writeByte(rdbOpcodeExpireTimeMs)
+		if err != nil {
+			return err
+		}
+
+		err = rw.write(binary.LittleEndian.AppendUint64([]byte{}, uint64(val.Expiry.UnixMilli())))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rw.writeByte(valueType)
+	if err != nil {
+		return err
+	}
+
+	err = rw.writeString(key)
+	if err != nil {
+		return err
+	}
+
+	return rw.writeValue(val.Value)
+}
+
+func (rw *RDBWriter) writeFunction(library *FunctionLibrary) error {
+	err := rw.writeByte(rdbOpcodeFunction2)
+	if err != nil {
+		return err
+	}
+
+	return rw.writeString(library.code)
+}
+
+// WriteFunctions serializes libraries as returned by FUNCTION DUMP: the
+// libraries as stored in an RDB file, followed by the RDB version and a
+// checksum.
+func (rw *RDBWriter) WriteFunctions(libraries FunctionLibraries) error {
+	for _, library := range libraries.Sorted() {
+		err := rw.writeFunction(library)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := rw.write(binary.LittleEndian.AppendUint16([]byte{}, rdbVersion))
+	if err != nil {
+		return err
+	}
+
+	_, err = rw.w.Write(binary.LittleEndian.AppendUint64([]byte{}, rw.crc))
+	if err != nil {
+		return err
+	}
+
+	return rw.w.Flush()
+}
+
+// WriteSnapshot serializes every key and function library in the snapshot as
+// an RDB file.
+func (rw *RDBWriter) WriteSnapshot(snapshot *DatabaseSnapshot) error {
+	err := rw.writeHeader()
+	if err != nil {
+		return fmt.Errorf("failed to write RDB header: %v", err)
+	}
+
+	for _, library := range snapshot.Libraries().Sorted() {
+		err = rw.writeFunction(library)
+		if err != nil {
+			return fmt.Errorf("failed to write RDB function library: %v", err)
+		}
+	}
+
+	err = rw.write([]byte{rdbOpcodeSelectDB, 0, rdbOpcodeResizeDB})
+	if err != nil {
+		return fmt.Errorf("failed to write RDB database selector: %v", err)
+	}
+
+	err = rw.writeLength(uint64(snapshot.Size()))
+	if err != nil {
+		return err
+	}
+
+	err = rw.writeLength(uint64(snapshot.Expires()))
+	if err != nil {
+		return err
+	}
+
+	err = snapshot.ForEach(rw.writeEntry)
+	if err != nil {
+		return fmt.Errorf("failed to write RDB entry: %v", err)
+	}
+
+	err = rw.writeByte(rdbOpcodeEOF)
+	if err != nil {
+		return err
+	}
+
+	_, err = rw.w.Write(binary.LittleEndian.AppendUint64([]byte{}, rw.crc))
+	if err != nil {
+		return err
+	}
+
+	return rw.w.Flush()
+}
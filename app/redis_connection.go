@@ -2,25 +2,59 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// RedisConnection serves a client. What other connections read about it, its
+// subscriber, protocol and the details CLIENT LIST shows, is only changed by
+// the connection itself, under lock.
 type RedisConnection struct {
-	Conn      *RESPConnection
-	Server    *RedisServer
-	Processed chan int
+	Conn        *RESPConnection
+	Server      *RedisServer
+	Processed   chan int
+	id          int
+	protocol    int
+	name        string
+	created     time.Time
+	lastActive  time.Time
+	lastCommand string
+	queued      int
+	isReplica   bool
+	noEvict     bool
+	monitoring  bool
+	propagated  []RESPValue
+	multi       *Transaction
+	inExec      bool // running a transaction's commands, under the write lock
+	watched     *WatchedKeys
+	subscriber  *Subscriber
+	cachingYes  bool
+	cachingNo   bool
+	repliesOff  bool
+	skipReply   bool
+	quit        bool
+	replicant   *ReplicantConnection
+	replPort    string
+	replCapaEOF bool
+	lock        sync.Mutex
 }
 
 func NewRedisConnection(conn *RESPConnection, server *RedisServer) *RedisConnection {
-	return &RedisConnection{Conn: conn, Server: server, Processed: make(chan int)}
+	now := time.Now()
+	return &RedisConnection{Conn: conn, Server: server, Processed: make(chan int, 1), protocol: 2, created: now, lastActive: now, queued: -1, watched: NewWatchedKeys()}
 }
 
 func isWriteCommand(parseInfo ParseInfo) bool {
-	return parseInfo.Command == "SET"
+	command, ok := lookupCommand(parseInfo)
+	return ok && command.HasFlag(commandWrite)
+}
+
+func isErrorResponse(responses []RESPValue) bool {
+	return len(responses) > 0 && responses[0].Type == SimpleError
 }
 
 func isAcknowledgementRequest(parseInfo ParseInfo) bool {
@@ -41,7 +75,165 @@ func isAcknowledgementResponse(parseInfo ParseInfo) bool {
 	return false
 }
 
+// rejection returns the error a client is answered with when command can't
+// run in the server's replication state: writes on a read only replica or on
+// a master without enough good replicas, and anything that needs fresh data
+// while a replica's link to its master is down.
+func (rc *RedisConnection) rejection(command Command) (RESPValue, bool) {
+	info := rc.Server.ServerInfo.Replication
+	if info.Role() != "slave" {
+		if command.HasFlag(commandWrite) && !rc.Server.EnoughGoodReplicas() {
+			return RESPValue{Type: SimpleError, Value: RESPError{Error: "NOREPLICAS", Message: "Not enough good replicas to write."}}, true
+		}
+
+		return RESPValue{}, false
+	}
+
+	info.Acquire()
+	readOnly, serveStaleData := info.ReadOnly, info.ServeStaleData
+	info.Release()
+
+	if readOnly && command.HasFlag(commandWrite) {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "READONLY", Message: "You can't write against a read only replica."}}, true
+	}
+
+	if !serveStaleData && !command.HasFlag(commandStale) && info.MasterLink.State() != replStateConnected {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "MASTERDOWN", Message: "Link with MASTER is down and replica-serve-stale-data is set to 'no'."}}, true
+	}
+
+	return RESPValue{}, false
+}
+
+func (rc *RedisConnection) execute(ctx context.Context, resp RESPValue, parseInfo ParseInfo) []RESPValue {
+	command, ok := lookupCommand(parseInfo)
+	busy, isBusy := rc.Server.RunningScript.Busy()
+	if isBusy && !command.HasFlag(commandAllowBusy) {
+		return []RESPValue{busy}
+	}
+
+	if rc.subscribed() && !command.HasFlag(commandSubscribed) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(parseInfo.Command))}}}
+	}
+
+	// Replicas are never paused, and neither is CLIENT UNPAUSE, so a pause
+	// of every command can still be lifted early.
+	if rc.replicant == nil && command.Name != "CLIENT|UNPAUSE" {
+		write := command.HasFlag(commandWrite) || command.HasFlag(commandMayReplicate) || (parseInfo.Command == "EXEC" && rc.multi != nil && rc.multi.Writes())
+		rc.Server.Pause.Wait(ctx, write)
+	}
+
+	// Shown before running, so commands queued in a transaction show as they
+	// are queued and blocking commands as they start to wait.
+	if called, _, valid := checkCall(parseInfo); valid {
+		rc.feedMonitors(called, parseInfo, rc.Conn.RemoteAddr())
+	}
+
+	if rc.multi != nil && !isTransactionControl(parseInfo) {
+		return []RESPValue{rc.queue(resp, parseInfo)}
+	}
+
+	// CLIENT CACHING applies to the next command, or to the whole of the
+	// transaction it is followed by.
+	if command.Name != "CLIENT|CACHING" {
+		defer func() {
+			if rc.multi == nil {
+				rc.cachingYes, rc.cachingNo = false, false
+			}
+		}()
+	}
+
+	if ok && (command.HasFlag(commandWrite) || command.HasFlag(commandMayReplicate)) {
+		return rc.Server.ExecuteWrite(func() ([]RESPValue, []RESPValue) {
+			// Checked once the write runs, since a write held back by a
+			// failover may find the server has become a replica.
+			rejection, rejected := rc.rejection(command)
+			if rejected {
+				return []RESPValue{rejection}, []RESPValue{}
+			}
+
+			return rc.call(ctx, resp, parseInfo, command)
+		})
+	}
+
+	if ok {
+		rejection, rejected := rc.rejection(command)
+		if rejected {
+			return []RESPValue{rejection}
+		}
+	}
+
+	if ok && command.HasFlag(commandRead) {
+		return rc.Server.ExecuteRead(func() []RESPValue {
+			return rc.ResponseFromArgs(ctx, parseInfo)
+		})
+	}
+
+	return rc.ResponseFromArgs(ctx, parseInfo)
+}
+
+// call runs a command under the write lock, returning its responses and the
+// commands to propagate for it. A failed write is not propagated, but the
+// writes a failed script made before failing are.
+func (rc *RedisConnection) call(ctx context.Context, resp RESPValue, parseInfo ParseInfo, command Command) ([]RESPValue, []RESPValue) {
+	rc.propagated = []RESPValue{}
+	if command.HasFlag(commandWrite) {
+		rc.propagated = []RESPValue{resp}
+	}
+
+	responses := rc.ResponseFromArgs(ctx, parseInfo)
+	if isErrorResponse(responses) && !command.HasFlag(commandMayReplicate) {
+		return responses, []RESPValue{}
+	}
+
+	return responses, rc.propagated
+}
+
+func isTransactionControl(parseInfo ParseInfo) bool {
+	switch parseInfo.Command {
+	case "MULTI", "EXEC", "DISCARD", "WATCH":
+		return true
+	}
+
+	return false
+}
+
+// queue adds a command to the transaction, aborting the transaction when the
+// command could not run.
+func (rc *RedisConnection) queue(resp RESPValue, parseInfo ParseInfo) RESPValue {
+	command, callErr, ok := checkCall(parseInfo)
+	if !ok {
+		rc.multi.Abort()
+		return callErr
+	}
+
+	if command.HasFlag(commandNoMulti) {
+		rc.multi.Abort()
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Command not allowed inside a transaction"}}
+	}
+
+	rejection, rejected := rc.rejection(command)
+	if rejected {
+		rc.multi.Abort()
+		return rejection
+	}
+
+	rc.multi.Queue(resp, parseInfo, command)
+	return RESPValue{Type: SimpleString, Value: "QUEUED"}
+}
+
+// propagateAs replaces the commands sent to replicas and the AOF for the write
+// being executed, for writes whose effect depends on when they run.
+func (rc *RedisConnection) propagateAs(commands ...RESPValue) {
+	rc.propagated = commands
+}
+
 func (rc *RedisConnection) HandleRequests(ctx context.Context) error {
+	rc.Server.Clients.Register(rc)
+	defer rc.Server.Clients.Unregister(rc)
+	defer rc.Server.Tracking.Disable(rc.id)
+	defer rc.Server.Database.Unwatch(rc.watched)
+	defer rc.unsubscribeAll()
+
 	for {
 		resp, err := rc.Conn.NextRESP(ctx)
 		if err != nil {
@@ -53,225 +245,1811 @@ func (rc *RedisConnection) HandleRequests(ctx context.Context) error {
 			return err
 		}
 
-		if isWriteCommand(parseInfo) {
-			rc.Server.ServerInfo.Replication.Replicants.Propogate(resp)
-			rc.Server.ProcessBytes(resp)
+		rc.started(parseInfo)
+		skip := rc.skipReply
+		rc.skipReply = false
+
+		responses := rc.execute(ctx, resp, parseInfo)
+		rc.finished()
+
+		if !skip && !rc.repliesOff {
+			err = rc.respond(responses)
+			if err != nil {
+				return err
+			}
 		}
 
-		responses := rc.ResponseFromArgs(ctx, parseInfo)
-		err = rc.Conn.RespondRESPValues(responses)
-		if err != nil {
-			return err
+		if rc.quit {
+			rc.unsubscribeAll()
+			return rc.Close()
 		}
 	}
 }
 
-func (rc *RedisConnection) responsePING(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	return []RESPValue{{Type: SimpleString, Value: "PONG"}}
+// started records the command the client is about to run, for CLIENT LIST.
+func (rc *RedisConnection) started(parseInfo ParseInfo) {
+	name := strings.ToLower(parseInfo.Command)
+	if command, ok := lookupCommand(parseInfo); ok && command.Name != "" {
+		name = strings.ToLower(command.Name)
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	rc.lastCommand = name
+	rc.lastActive = time.Now()
 }
 
-func (rc *RedisConnection) responseECHO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	return []RESPValue{{Type: BulkString, Value: parseInfo.Args[0].Value.(string)}}
+func (rc *RedisConnection) finished() {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	rc.queued = -1
+	if rc.multi != nil {
+		rc.queued = rc.multi.Size()
+	}
+	rc.isReplica = rc.replicant != nil
 }
 
-func (rc *RedisConnection) responseGET(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	key := parseInfo.Args[0].Value.(string)
-	return []RESPValue{rc.Server.GetValue(key)}
+// clientType returns the type CLIENT LIST and CLIENT KILL know the client
+// as.
+func (rc *RedisConnection) clientType() string {
+	rc.lock.Lock()
+	isReplica, sub := rc.isReplica, rc.subscriber
+	rc.lock.Unlock()
+
+	if isReplica {
+		return "replica"
+	}
+
+	if sub != nil && rc.Server.PubSub.Subscriptions(sub) > 0 {
+		return "pubsub"
+	}
+
+	return "normal"
 }
 
-func (rc *RedisConnection) responseSET(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	key := parseInfo.Args[0].Value.(string)
-	value := parseInfo.Args[1]
-	expiry := -1
+// info describes the client as a line of CLIENT LIST.
+func (rc *RedisConnection) info() string {
+	rc.lock.Lock()
+	name, created, lastActive, lastCommand, queued, isReplica, noEvict, monitoring, protocol, sub := rc.name, rc.created, rc.lastActive, rc.lastCommand, rc.queued, rc.isReplica, rc.noEvict, rc.monitoring, rc.protocol, rc.subscriber
+	rc.lock.Unlock()
 
-	if len(parseInfo.Args) >= 4 &&
-		strings.ToUpper(parseInfo.Args[2].Value.(string)) == "PX" {
-		expiryStr := parseInfo.Args[3].Value.(string)
+	channels, patterns, shardChannels := 0, 0, 0
+	if sub != nil {
+		channels, patterns, shardChannels = rc.Server.PubSub.Counts(sub)
+	}
 
-		exp, err := strconv.Atoi(expiryStr)
-		if err != nil {
-			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("failed to parse expiry: %v", err)}}}
-		}
+	flags := ""
+	if isReplica {
+		flags += "S"
+	}
+	if monitoring {
+		flags += "O"
+	}
+	if channels+patterns+shardChannels > 0 {
+		flags += "P"
+	}
+	if queued != -1 {
+		flags += "x"
+	}
+	if noEvict {
+		flags += "e"
+	}
 
-		expiry = exp
+	redirect := -1
+	if client, ok := rc.Server.Tracking.Client(rc.id); ok {
+		flags += "t"
+		redirect = client.redirect
 	}
 
-	rc.Server.SetValue(key, value, expiry)
-	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+	if flags == "" {
+		flags = "N"
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d ssub=%d multi=%d cmd=%s user=default redir=%d resp=%d",
+		rc.id, rc.Conn.RemoteAddr(), rc.Conn.LocalAddr(), name, int(time.Since(created).Seconds()), int(time.Since(lastActive).Seconds()), flags,
+		channels, patterns, shardChannels, queued, lastCommand, redirect, protocol)
 }
 
-func (rc *RedisConnection) responseINFO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	category := parseInfo.Args[0].Value.(string)
+// kill disconnects the client, once it has been answered when it is the one
+// killing itself.
+func (rc *RedisConnection) kill(self *RedisConnection) {
+	if rc == self {
+		rc.quit = true
+		return
+	}
 
-	switch category {
-	case "replication":
-		return []RESPValue{{Type: BulkString, Value: rc.Server.ServerInfo.Replication.ToString()}}
+	rc.Conn.Close()
+}
+
+// respond writes responses to the client, through its subscriber once it has
+// used pub/sub so they are kept in order with the messages it receives.
+func (rc *RedisConnection) respond(responses []RESPValue) error {
+	if rc.subscriber != nil {
+		rc.subscriber.Push(responses...)
+		return nil
 	}
 
-	return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "failed to specify a valid info error"}}}
+	return rc.Conn.RespondRESPValues(responses)
 }
 
-func getBytesProcessed(parseInfo ParseInfo) (int, error) {
-	processed, ok := parseInfo.Args[1].Value.(string)
-	if !ok {
-		return 0, fmt.Errorf("failed to get bytes processed: processed not a string")
+// subscribed reports whether the client is a RESP2 client subscribed to any
+// channel or pattern, which limits the commands it may call.
+func (rc *RedisConnection) subscribed() bool {
+	return rc.protocol < 3 && rc.subscriber != nil && rc.Server.PubSub.Subscriptions(rc.subscriber) > 0
+}
+
+// subscriberForClient returns the client's subscriber, starting it on first
+// use.
+func (rc *RedisConnection) subscriberForClient() *Subscriber {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.subscriber == nil {
+		rc.subscriber = NewSubscriber(rc.Conn)
+		rc.subscriber.SetPush(rc.protocol == 3)
 	}
 
-	asInt, err := strconv.Atoi(processed)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get bytes processed: %v", err)
+	return rc.subscriber
+}
+
+// unsubscribeAll drops the client's subscriptions once it disconnects, after
+// writing what is still queued for it.
+func (rc *RedisConnection) unsubscribeAll() {
+	rc.lock.Lock()
+	sub := rc.subscriber
+	rc.subscriber = nil
+	rc.lock.Unlock()
+
+	if sub == nil {
+		return
 	}
 
-	return asInt, nil
+	rc.Server.PubSub.Remove(sub)
+	rc.Server.Monitors.Remove(sub)
+	sub.Close()
 }
 
-func (rc *RedisConnection) responseREPLCONF(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	if isAcknowledgementRequest(parseInfo) {
-		bytesProcessed := strconv.Itoa(rc.Server.ServerInfo.Replication.MasterReplOffset)
-		res := []RESPValue{{Type: BulkString, Value: "REPLCONF"}, {Type: BulkString, Value: "ACK"}, {Type: BulkString, Value: bytesProcessed}}
-		return []RESPValue{{Type: Array, Value: res}}
-	} else if isAcknowledgementResponse(parseInfo) {
-		bytes, _ := getBytesProcessed(parseInfo)
-		rc.Processed <- bytes
-		return []RESPValue{}
+// mapRESP returns pairs as a map for RESP3 clients, and as a flat array
+// otherwise.
+func (rc *RedisConnection) mapRESP(pairs ...RESPValue) RESPValue {
+	if rc.protocol == 3 {
+		return RESPValue{Type: Map, Value: pairs}
+	}
+
+	return RESPValue{Type: Array, Value: pairs}
+}
+
+// pushInvalidation sends the client an invalidation of keys, or of every key
+// when keys is nil: as a push to a RESP3 client, or as a message on
+// __redis__:invalidate to a subscribed RESP2 client, as clients redirecting
+// their invalidations to it expect.
+func (rc *RedisConnection) pushInvalidation(keys []string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.subscriber == nil {
+		return
+	}
+
+	invalidated := CommandRESP(keys...)
+	if rc.protocol == 3 {
+		if keys == nil {
+			invalidated = RESPValue{Type: Null}
+		}
+
+		rc.subscriber.Push(RESPValue{Type: Push, Value: []RESPValue{{Type: BulkString, Value: "invalidate"}, invalidated}})
+	} else if rc.Server.PubSub.Subscriptions(rc.subscriber) > 0 {
+		if keys == nil {
+			invalidated = RESPValue{Type: NullArray}
+		}
+
+		rc.subscriber.Push(RESPValue{Type: Array, Value: []RESPValue{{Type: BulkString, Value: "message"}, {Type: BulkString, Value: "__redis__:invalidate"}, invalidated}})
+	}
+}
+
+// pushRedirectBroken tells a RESP3 client that the client it redirects its
+// invalidations to is gone.
+func (rc *RedisConnection) pushRedirectBroken(redirect int) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.subscriber != nil && rc.protocol == 3 {
+		rc.subscriber.Push(RESPValue{Type: Push, Value: []RESPValue{{Type: BulkString, Value: "tracking-redir-broken"}, {Type: Integer, Value: redirect}}})
+	}
+}
+
+// track remembers the keys a read made by a client tracking keys, unless it
+// opted out of caching them, and sends invalidations for the keys a write
+// modified.
+func (rc *RedisConnection) track(command Command, parseInfo ParseInfo) {
+	keys := command.Keys(parseInfo.Args)
+	if len(keys) == 0 {
+		return
+	}
+
+	if command.HasFlag(commandWrite) {
+		rc.Server.Tracking.Invalidate(keys, rc.id)
+		return
+	}
+
+	client, ok := rc.Server.Tracking.Client(rc.id)
+	if !ok || (client.optIn && !rc.cachingYes) || (client.optOut && rc.cachingNo) {
+		return
+	}
+
+	rc.Server.Tracking.Remember(rc.id, keys)
+}
+
+// invoke runs the handler of command and keeps the tracking table up to date
+// with the keys it read or modified.
+func (rc *RedisConnection) invoke(ctx context.Context, command Command, parseInfo ParseInfo) []RESPValue {
+	responses := command.Handler(rc, ctx, parseInfo)
+	if !isErrorResponse(responses) {
+		rc.track(command, parseInfo)
+	}
+
+	return responses
+}
+
+func (rc *RedisConnection) responsePING(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if len(parseInfo.Args) > 1 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'ping' command"}}}
+	}
+
+	message := ""
+	if len(parseInfo.Args) == 1 {
+		message = parseInfo.Args[0].Value.(string)
+	}
+
+	if rc.subscribed() {
+		return []RESPValue{CommandRESP("pong", message)}
+	}
+
+	if len(parseInfo.Args) == 1 {
+		return []RESPValue{{Type: BulkString, Value: message}}
 	}
 
+	return []RESPValue{{Type: SimpleString, Value: "PONG"}}
+}
+
+func (rc *RedisConnection) responseQUIT(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.quit = true
 	return []RESPValue{{Type: SimpleString, Value: "OK"}}
 }
 
-func emptyRDBRESP() RESPValue {
-	rdbFileHex := "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
-	decoded, err := hex.DecodeString(rdbFileHex)
-	if err != nil {
-		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("failed to decode RDB File hex: %v", err)}}
+func argStrings(args []RESPValue) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.Value.(string)
 	}
-	return RESPValue{Type: RDBFile, Value: string(decoded)}
+
+	return strs
 }
 
-func (rc *RedisConnection) responsePSYNC(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	fullResync := RESPValue{Type: SimpleString, Value: fmt.Sprintf("FULLRESYNC %s 0", ReplicationID)}
-	emptyRDB := emptyRDBRESP()
+func (rc *RedisConnection) responseSUBSCRIBE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.PubSub.Subscribe(rc.subscriberForClient(), argStrings(parseInfo.Args))
+	return []RESPValue{}
+}
 
-	rc.Server.ServerInfo.Replication.Replicants.Add(&ReplicantConnection{conn: rc})
+func (rc *RedisConnection) responseUNSUBSCRIBE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.PubSub.Unsubscribe(rc.subscriberForClient(), argStrings(parseInfo.Args))
+	return []RESPValue{}
+}
 
-	return []RESPValue{fullResync, emptyRDB}
+func (rc *RedisConnection) responsePSUBSCRIBE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.PubSub.PSubscribe(rc.subscriberForClient(), argStrings(parseInfo.Args))
+	return []RESPValue{}
 }
 
-func (rc *RedisConnection) responseWAIT(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	replicants, err := strconv.Atoi(parseInfo.Args[0].Value.(string))
-	if err != nil {
-		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("number of replicants for WAIT command could not be converted to an int: %v", err)}}}
+func (rc *RedisConnection) responsePUNSUBSCRIBE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.PubSub.PUnsubscribe(rc.subscriberForClient(), argStrings(parseInfo.Args))
+	return []RESPValue{}
+}
+
+// sameSlot reports whether every channel hashes to the same slot, as the
+// shard channels a command names must.
+func sameSlot(channels []string) bool {
+	for _, channel := range channels {
+		if keyHashSlot(channel) != keyHashSlot(channels[0]) {
+			return false
+		}
 	}
 
-	timeout, err := strconv.Atoi(parseInfo.Args[1].Value.(string))
-	if err != nil {
-		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("deadline for WAIT command could not be converted to an int: %v", err)}}}
+	return true
+}
+
+func crossSlotError() RESPValue {
+	return RESPValue{Type: SimpleError, Value: RESPError{Error: "CROSSSLOT", Message: "Keys in request don't hash to the same slot"}}
+}
+
+func (rc *RedisConnection) responseSSUBSCRIBE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	channels := argStrings(parseInfo.Args)
+	if !sameSlot(channels) {
+		return []RESPValue{crossSlotError()}
 	}
-	processedThresh := rc.Server.ServerInfo.Replication.MasterReplOffset
-	consistent := rc.Server.ServerInfo.Replication.Replicants.WaitForConsistency(ctx, replicants, time.Millisecond*time.Duration(timeout), processedThresh)
-	return []RESPValue{{Type: Integer, Value: consistent}}
+
+	rc.Server.PubSub.SSubscribe(rc.subscriberForClient(), channels)
+	return []RESPValue{}
 }
 
-func (rc *RedisConnection) responseCONFIG(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	action, ok := parseInfo.Args[0].Value.(string)
-	if !ok {
-		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "failed to convert CONFIG arg 0 to string"}}}
+func (rc *RedisConnection) responseSUNSUBSCRIBE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	channels := argStrings(parseInfo.Args)
+	if !sameSlot(channels) {
+		return []RESPValue{crossSlotError()}
+	}
+
+	rc.Server.PubSub.SUnsubscribe(rc.subscriberForClient(), channels)
+	return []RESPValue{}
+}
+
+func (rc *RedisConnection) responseSPUBLISH(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	receivers := rc.Server.PubSub.SPublish(parseInfo.Args[0].Value.(string), parseInfo.Args[1].Value.(string))
+	return []RESPValue{{Type: Integer, Value: receivers}}
+}
+
+func (rc *RedisConnection) responsePUBLISH(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	receivers := rc.Server.PubSub.Publish(parseInfo.Args[0].Value.(string), parseInfo.Args[1].Value.(string))
+	return []RESPValue{{Type: Integer, Value: receivers}}
+}
+
+func (rc *RedisConnection) pubsubCHANNELS(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	pattern := "*"
+	if len(parseInfo.Args) == 2 {
+		pattern = parseInfo.Args[1].Value.(string)
 	}
 
-	if action != "GET" {
-		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "CONFIG arg must be GET"}}}
+	return []RESPValue{CommandRESP(rc.Server.PubSub.Channels(pattern)...)}
+}
+
+func (rc *RedisConnection) pubsubNUMSUB(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	counts := []RESPValue{}
+	for _, arg := range parseInfo.Args[1:] {
+		channel := arg.Value.(string)
+		counts = append(counts, RESPValue{Type: BulkString, Value: channel}, RESPValue{Type: Integer, Value: rc.Server.PubSub.NumSub(channel)})
 	}
 
-	arg, ok := parseInfo.Args[1].Value.(string)
-	if !ok {
-		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "failed to convert CONFIG arg 1 to string"}}}
+	return []RESPValue{{Type: Array, Value: counts}}
+}
+
+func (rc *RedisConnection) pubsubSHARDCHANNELS(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	pattern := "*"
+	if len(parseInfo.Args) == 2 {
+		pattern = parseInfo.Args[1].Value.(string)
 	}
 
-	val := ""
-	if arg == "dir" {
-		val = rc.Server.ServerInfo.Persistence.Dir
-	} else if arg == "dbfilename" {
-		val = rc.Server.ServerInfo.Persistence.Dbfilename
-	} else {
-		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Invalid CONFIG arg 2"}}}
+	return []RESPValue{CommandRESP(rc.Server.PubSub.ShardChannels(pattern)...)}
+}
+
+func (rc *RedisConnection) pubsubSHARDNUMSUB(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	counts := []RESPValue{}
+	for _, arg := range parseInfo.Args[1:] {
+		channel := arg.Value.(string)
+		counts = append(counts, RESPValue{Type: BulkString, Value: channel}, RESPValue{Type: Integer, Value: rc.Server.PubSub.ShardNumSub(channel)})
 	}
 
-	res := RESPValue{Type: Array, Value: []RESPValue{{Type: BulkString, Value: arg}, {Type: BulkString, Value: val}}}
+	return []RESPValue{{Type: Array, Value: counts}}
+}
 
-	return []RESPValue{res}
+func (rc *RedisConnection) pubsubNUMPAT(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	return []RESPValue{{Type: Integer, Value: rc.Server.PubSub.NumPat()}}
 }
 
-func typeFromVal(val RESPValue) string {
-	switch val.Type {
-	case NullBulkString:
-		return "none"
-	case Stream:
-		return "stream"
-	default:
-		return "string"
+// responseMONITOR answers the client, then has every command the server
+// processes from then on shown to it.
+func (rc *RedisConnection) responseMONITOR(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.replicant != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Replica can't interact with the keyspace"}}}
 	}
+
+	sub := rc.subscriberForClient()
+	sub.Push(RESPValue{Type: SimpleString, Value: "OK"})
+	rc.Server.Monitors.Add(sub)
+
+	rc.lock.Lock()
+	rc.monitoring = true
+	rc.lock.Unlock()
+
+	return []RESPValue{}
 }
 
-func (rc *RedisConnection) responseTYPE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	key := parseInfo.Args[0].Value.(string)
-	val := rc.Server.GetValue(key)
-	return []RESPValue{{Type: SimpleString, Value: typeFromVal(val)}}
+func (rc *RedisConnection) responseHELLO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	name, setName := "", false
+	for i := 1; i < len(parseInfo.Args); i++ {
+		option := parseInfo.Args[i].Value.(string)
+		if strings.ToUpper(option) != "SETNAME" || i+1 >= len(parseInfo.Args) {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Syntax error in HELLO option '%s'", option)}}}
+		}
+
+		name, setName = parseInfo.Args[i+1].Value.(string), true
+		i++
+	}
+
+	if setName && !validClientName(name) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Client names cannot contain spaces, newlines or special characters."}}}
+	}
+
+	if len(parseInfo.Args) > 0 {
+		protocol, err := strconv.Atoi(parseInfo.Args[0].Value.(string))
+		if err != nil {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Protocol version is not an integer or out of range"}}}
+		}
+
+		if protocol != 2 && protocol != 3 {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "NOPROTO", Message: "unsupported protocol version"}}}
+		}
+
+		// RESP3 clients may be sent pushes at any time, so their replies are
+		// queued with them.
+		if protocol == 3 {
+			rc.subscriberForClient()
+		}
+
+		rc.lock.Lock()
+		rc.protocol = protocol
+		if rc.subscriber != nil {
+			rc.subscriber.SetPush(protocol == 3)
+		}
+		if setName {
+			rc.name = name
+		}
+		rc.lock.Unlock()
+	}
+
+	role := "master"
+	if rc.Server.ServerInfo.Replication.Role() == "slave" {
+		role = "replica"
+	}
+
+	return []RESPValue{rc.mapRESP(
+		RESPValue{Type: BulkString, Value: "server"}, RESPValue{Type: BulkString, Value: "redis"},
+		RESPValue{Type: BulkString, Value: "version"}, RESPValue{Type: BulkString, Value: redisVersion},
+		RESPValue{Type: BulkString, Value: "proto"}, RESPValue{Type: Integer, Value: rc.protocol},
+		RESPValue{Type: BulkString, Value: "id"}, RESPValue{Type: Integer, Value: rc.id},
+		RESPValue{Type: BulkString, Value: "mode"}, RESPValue{Type: BulkString, Value: "standalone"},
+		RESPValue{Type: BulkString, Value: "role"}, RESPValue{Type: BulkString, Value: role},
+		RESPValue{Type: BulkString, Value: "modules"}, RESPValue{Type: Array, Value: []RESPValue{}},
+	)}
 }
 
-func (rc *RedisConnection) responseXADD(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	streamName := parseInfo.Args[0].Value.(string)
-	id := parseInfo.Args[1].Value.(string)
-	fields := []Pair{}
+func (rc *RedisConnection) clientID(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	return []RESPValue{{Type: Integer, Value: rc.id}}
+}
 
-	i := 2
-	for i+1 < len(parseInfo.Args) {
-		argName := parseInfo.Args[i].Value.(string)
-		argVal := parseInfo.Args[i+1].Value.(string)
-		fields = append(fields, Pair{Key: argName, Val: argVal})
-		i += 2
+// validClientName reports whether name has no spaces, newlines or other
+// special characters.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
 	}
 
-	streamEntry := StreamEntry{Id: id, Fields: fields}
+	return true
+}
 
-	entries := []StreamEntry{}
-	existing := rc.Server.GetValue(streamName)
-	if existing.Type == Stream {
-		entries = existing.Value.(StreamLog).Entries
+func (rc *RedisConnection) clientSETNAME(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	name := parseInfo.Args[1].Value.(string)
+	if !validClientName(name) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Client names cannot contain spaces, newlines or special characters."}}}
 	}
 
-	entries = append(entries, streamEntry)
-	rc.Server.SetValue(streamName, RESPValue{Type: Stream, Value: StreamLog{Name: streamName, Entries: entries}}, -1)
+	rc.lock.Lock()
+	rc.name = name
+	rc.lock.Unlock()
 
-	return []RESPValue{{Type: SimpleString, Value: id}}
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) clientGETNAME(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.name == "" {
+		return []RESPValue{{Type: NullBulkString}}
+	}
+
+	return []RESPValue{{Type: BulkString, Value: rc.name}}
+}
+
+func validClientType(clientType string) (string, bool) {
+	switch strings.ToLower(clientType) {
+	case "normal", "master", "pubsub":
+		return strings.ToLower(clientType), true
+	case "replica", "slave":
+		return "replica", true
+	}
+
+	return "", false
+}
+
+func (rc *RedisConnection) clientLIST(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	clientType := ""
+	ids := map[int]bool{}
+	args := parseInfo.Args[1:]
+	for len(args) > 0 {
+		switch {
+		case strings.ToUpper(args[0].Value.(string)) == "TYPE" && len(args) == 2:
+			valid, ok := validClientType(args[1].Value.(string))
+			if !ok {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Unknown client type '%s'", args[1].Value.(string))}}}
+			}
+
+			clientType = valid
+			args = nil
+		case strings.ToUpper(args[0].Value.(string)) == "ID" && len(args) > 1:
+			for _, arg := range args[1:] {
+				id, err := strconv.Atoi(arg.Value.(string))
+				if err != nil || id <= 0 {
+					return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Invalid client ID"}}}
+				}
+				ids[id] = true
+			}
+			args = nil
+		default:
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+	}
+
+	var list strings.Builder
+	for _, client := range rc.Server.Clients.List() {
+		if (clientType != "" && client.clientType() != clientType) || (len(ids) > 0 && !ids[client.id]) {
+			continue
+		}
+
+		list.WriteString(client.info() + "\n")
+	}
+
+	return []RESPValue{{Type: BulkString, Value: list.String()}}
+}
+
+func (rc *RedisConnection) clientINFO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	return []RESPValue{{Type: BulkString, Value: rc.info() + "\n"}}
+}
+
+// clientKILL disconnects the client with the address given, or, given
+// filters, every client matching all of them, except the caller unless
+// SKIPME no is given.
+func (rc *RedisConnection) clientKILL(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	args := parseInfo.Args[1:]
+	if len(args) == 1 {
+		addr := args[0].Value.(string)
+		for _, client := range rc.Server.Clients.List() {
+			if client.Conn.RemoteAddr() == addr {
+				client.kill(rc)
+				return []RESPValue{{Type: SimpleString, Value: "OK"}}
+			}
+		}
+
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "No such client"}}}
+	}
+
+	if len(args)%2 != 0 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	id, clientType, addr, laddr, skipMe, maxAge := 0, "", "", "", true, int64(0)
+	for i := 0; i < len(args); i += 2 {
+		val := args[i+1].Value.(string)
+		switch strings.ToUpper(args[i].Value.(string)) {
+		case "ID":
+			num, err := strconv.Atoi(val)
+			if err != nil || num <= 0 {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "client-id should be greater than 0"}}}
+			}
+			id = num
+		case "TYPE":
+			valid, ok := validClientType(val)
+			if !ok {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Unknown client type '%s'", val)}}}
+			}
+			clientType = valid
+		case "USER":
+			if val != "default" {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("No such user '%s'", val)}}}
+			}
+		case "ADDR":
+			addr = val
+		case "LADDR":
+			laddr = val
+		case "SKIPME":
+			switch strings.ToLower(val) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+			}
+		case "MAXAGE":
+			num, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "value is not an integer or out of range"}}}
+			}
+			maxAge = num
+		default:
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+	}
+
+	killed := 0
+	for _, client := range rc.Server.Clients.List() {
+		switch {
+		case id != 0 && client.id != id:
+		case clientType != "" && client.clientType() != clientType:
+		case addr != "" && client.Conn.RemoteAddr() != addr:
+		case laddr != "" && client.Conn.LocalAddr() != laddr:
+		case skipMe && client == rc:
+		case maxAge != 0 && int64(time.Since(client.created).Seconds()) < maxAge:
+		default:
+			client.kill(rc)
+			killed += 1
+		}
+	}
+
+	return []RESPValue{{Type: Integer, Value: killed}}
+}
+
+func (rc *RedisConnection) clientPAUSE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	timeout, err := strconv.ParseInt(parseInfo.Args[1].Value.(string), 10, 64)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "timeout is not an integer or out of range"}}}
+	}
+
+	if timeout < 0 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "timeout is negative"}}}
+	}
+
+	mode := pauseAll
+	if len(parseInfo.Args) == 3 {
+		switch strings.ToUpper(parseInfo.Args[2].Value.(string)) {
+		case "WRITE":
+			mode = pauseWrite
+		case "ALL":
+		default:
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+	}
+
+	rc.Server.Pause.Pause(mode, time.Now().Add(time.Duration(timeout)*time.Millisecond))
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) clientUNPAUSE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.Pause.Unpause()
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) clientNOEVICT(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	noEvict := false
+	switch strings.ToUpper(parseInfo.Args[1].Value.(string)) {
+	case "ON":
+		noEvict = true
+	case "OFF":
+	default:
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	rc.lock.Lock()
+	rc.noEvict = noEvict
+	rc.lock.Unlock()
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+// clientREPLY turns the replies to the client's commands on or off, or skips
+// the reply to its next command. Only turning them on is answered.
+func (rc *RedisConnection) clientREPLY(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	switch strings.ToUpper(parseInfo.Args[1].Value.(string)) {
+	case "ON":
+		rc.repliesOff = false
+		return []RESPValue{{Type: SimpleString, Value: "OK"}}
+	case "OFF":
+		rc.repliesOff = true
+	case "SKIP":
+		rc.skipReply = !rc.repliesOff
+	default:
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	return []RESPValue{}
+}
+
+// prefixOverlap returns a prefix of prefixes that prefix overlaps with, one
+// of them starting with the other.
+func prefixOverlap(prefix string, prefixes []string) (string, bool) {
+	for _, other := range prefixes {
+		if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+			return other, true
+		}
+	}
+
+	return "", false
+}
+
+func (rc *RedisConnection) clientTRACKING(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	syntaxError := []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	client := TrackingClient{id: rc.id}
+
+	on := false
+	switch strings.ToUpper(parseInfo.Args[1].Value.(string)) {
+	case "ON":
+		on = true
+	case "OFF":
+	default:
+		return syntaxError
+	}
+
+	for i := 2; i < len(parseInfo.Args); i++ {
+		option := strings.ToUpper(parseInfo.Args[i].Value.(string))
+		switch {
+		case option == "BCAST":
+			client.bcast = true
+		case option == "OPTIN":
+			client.optIn = true
+		case option == "OPTOUT":
+			client.optOut = true
+		case option == "NOLOOP":
+			client.noLoop = true
+		case option == "REDIRECT" && i+1 < len(parseInfo.Args):
+			redirect, err := strconv.Atoi(parseInfo.Args[i+1].Value.(string))
+			if err != nil {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "value is not an integer or out of range"}}}
+			}
+
+			if _, ok := rc.Server.Clients.Get(redirect); !ok || redirect == rc.id {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "The client ID you want redirect to does not exist"}}}
+			}
+
+			client.redirect = redirect
+			i++
+		case option == "PREFIX" && i+1 < len(parseInfo.Args):
+			client.prefixes = append(client.prefixes, parseInfo.Args[i+1].Value.(string))
+			i++
+		default:
+			return syntaxError
+		}
+	}
+
+	if !on {
+		rc.Server.Tracking.Disable(rc.id)
+		return []RESPValue{{Type: SimpleString, Value: "OK"}}
+	}
+
+	existing, tracking := rc.Server.Tracking.Client(rc.id)
+	if !client.bcast && len(client.prefixes) > 0 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "PREFIX option requires BCAST mode to be enabled"}}}
+	}
+
+	if tracking && existing.bcast != client.bcast {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."}}}
+	}
+
+	if client.optIn && client.optOut {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "You can't use both OPTIN and OPTOUT"}}}
+	}
+
+	if client.bcast && (client.optIn || client.optOut) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "OPTIN and OPTOUT are not compatible with BCAST"}}}
+	}
+
+	if tracking && (existing.optIn != client.optIn || existing.optOut != client.optOut) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode."}}}
+	}
+
+	for i, prefix := range client.prefixes {
+		if other, ok := prefixOverlap(prefix, existing.prefixes); ok {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, other)}}}
+		}
+
+		if other, ok := prefixOverlap(prefix, client.prefixes[i+1:]); ok {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Prefix '%s' overlaps with another provided prefix '%s'. Prefixes for a single client must not overlap.", prefix, other)}}}
+		}
+	}
+
+	// Enabling tracking again adds to the prefixes and options already set.
+	if tracking {
+		client.prefixes = append(existing.prefixes, client.prefixes...)
+		client.noLoop = client.noLoop || existing.noLoop
+	}
+
+	rc.Server.Tracking.Enable(client)
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) clientCACHING(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	client, ok := rc.Server.Tracking.Client(rc.id)
+	if !ok || (!client.optIn && !client.optOut) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled"}}}
+	}
+
+	switch strings.ToUpper(parseInfo.Args[1].Value.(string)) {
+	case "YES":
+		if !client.optIn {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode."}}}
+		}
+		rc.cachingYes = true
+	case "NO":
+		if !client.optOut {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode."}}}
+		}
+		rc.cachingNo = true
+	default:
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) clientGETREDIR(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	client, ok := rc.Server.Tracking.Client(rc.id)
+	if !ok {
+		return []RESPValue{{Type: Integer, Value: -1}}
+	}
+
+	return []RESPValue{{Type: Integer, Value: client.redirect}}
+}
+
+func (rc *RedisConnection) clientTRACKINGINFO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	client, ok := rc.Server.Tracking.Client(rc.id)
+	flags := []string{}
+	redirect := -1
+	if !ok {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		redirect = client.redirect
+		for flag, set := range map[string]bool{"bcast": client.bcast, "optin": client.optIn, "optout": client.optOut, "caching-yes": rc.cachingYes, "caching-no": rc.cachingNo, "noloop": client.noLoop} {
+			if set {
+				flags = append(flags, flag)
+			}
+		}
+		sort.Strings(flags[1:])
+
+		if _, ok := rc.Server.Clients.Get(client.redirect); client.redirect != 0 && !ok {
+			flags = append(flags, "broken_redirect")
+		}
+	}
+
+	prefixes := client.prefixes
+	if len(prefixes) == 1 && prefixes[0] == "" {
+		prefixes = nil
+	}
+
+	return []RESPValue{rc.mapRESP(
+		RESPValue{Type: BulkString, Value: "flags"}, CommandRESP(flags...),
+		RESPValue{Type: BulkString, Value: "redirect"}, RESPValue{Type: Integer, Value: redirect},
+		RESPValue{Type: BulkString, Value: "prefixes"}, CommandRESP(prefixes...),
+	)}
+}
+
+func (rc *RedisConnection) responseECHO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	return []RESPValue{{Type: BulkString, Value: parseInfo.Args[0].Value.(string)}}
+}
+
+func (rc *RedisConnection) responseGET(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	key := parseInfo.Args[0].Value.(string)
+	val := rc.Server.GetValue(key)
+	if val.Type == NullBulkString {
+		rc.Server.Database.Notify(notifyKeyMiss, "keymiss", key)
+	}
+
+	return []RESPValue{val}
+}
+
+func parseSetExpiry(option string, arg RESPValue) (time.Time, error) {
+	num, err := strconv.ParseInt(arg.Value.(string), 10, 64)
+	if err != nil || num <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expire time in 'set' command")
+	}
+
+	switch option {
+	case "EX":
+		return time.Now().Add(time.Duration(num) * time.Second), nil
+	case "PX":
+		return time.Now().Add(time.Duration(num) * time.Millisecond), nil
+	case "EXAT":
+		return time.Unix(num, 0), nil
+	default:
+		return time.UnixMilli(num), nil
+	}
+}
+
+func (rc *RedisConnection) responseSET(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	key := parseInfo.Args[0].Value.(string)
+	value := parseInfo.Args[1]
+	expiry := time.Time{}
+
+	for i := 2; i < len(parseInfo.Args); i++ {
+		option := strings.ToUpper(parseInfo.Args[i].Value.(string))
+		if (option != "EX" && option != "PX" && option != "EXAT" && option != "PXAT") || i+1 >= len(parseInfo.Args) {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+
+		exp, err := parseSetExpiry(option, parseInfo.Args[i+1])
+		if err != nil {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+		}
+
+		expiry = exp
+		i++
+	}
+
+	rc.Server.Database.SetResult(key, ResultData{Value: value, Expiry: expiry})
+	rc.Server.Database.Notify(notifyString, "set", key)
+	if !expiry.IsZero() {
+		rc.Server.Database.Notify(notifyGeneric, "expire", key)
+		rc.propagateAs(CommandRESP("SET", key, value.Value.(string), "PXAT", strconv.FormatInt(expiry.UnixMilli(), 10)))
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) persistenceInfo() string {
+	return rc.Server.ServerInfo.Persistence.ToString(rc.Server.Database.Dirty()) + rc.Server.AOF.ToString()
+}
+
+func (rc *RedisConnection) responseINFO(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	category := "all"
+	if len(parseInfo.Args) > 0 {
+		category = strings.ToLower(parseInfo.Args[0].Value.(string))
+	}
+
+	switch category {
+	case "replication":
+		return []RESPValue{{Type: BulkString, Value: rc.Server.ServerInfo.Replication.ToString()}}
+	case "persistence":
+		return []RESPValue{{Type: BulkString, Value: rc.persistenceInfo()}}
+	case "all", "default", "everything":
+		info := rc.persistenceInfo() + "\n" + rc.Server.ServerInfo.Replication.ToString()
+		return []RESPValue{{Type: BulkString, Value: info}}
+	}
+
+	return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "failed to specify a valid info error"}}}
+}
+
+func getBytesProcessed(parseInfo ParseInfo) (int, error) {
+	processed, ok := parseInfo.Args[1].Value.(string)
+	if !ok {
+		return 0, fmt.Errorf("failed to get bytes processed: processed not a string")
+	}
+
+	asInt, err := strconv.Atoi(processed)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get bytes processed: %v", err)
+	}
+
+	return asInt, nil
+}
+
+// reportProcessed hands the latest acknowledged offset to a waiting WAIT,
+// replacing an older one nobody has read yet.
+func (rc *RedisConnection) reportProcessed(bytes int) {
+	for {
+		select {
+		case rc.Processed <- bytes:
+			return
+		default:
+		}
+
+		select {
+		case <-rc.Processed:
+		default:
+		}
+	}
+}
+
+func (rc *RedisConnection) responseREPLCONF(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if isAcknowledgementRequest(parseInfo) {
+		bytesProcessed := strconv.Itoa(rc.Server.ServerInfo.Replication.MasterReplOffset)
+		res := []RESPValue{{Type: BulkString, Value: "REPLCONF"}, {Type: BulkString, Value: "ACK"}, {Type: BulkString, Value: bytesProcessed}}
+		return []RESPValue{{Type: Array, Value: res}}
+	} else if isAcknowledgementResponse(parseInfo) {
+		bytes, _ := getBytesProcessed(parseInfo)
+		if rc.replicant != nil {
+			rc.replicant.Acknowledge(bytes)
+		} else {
+			rc.reportProcessed(bytes)
+		}
+		return []RESPValue{}
+	}
+
+	for i := 0; i+1 < len(parseInfo.Args); i += 2 {
+		option := strings.ToLower(parseInfo.Args[i].Value.(string))
+		val := parseInfo.Args[i+1].Value.(string)
+		if option == "listening-port" {
+			rc.replPort = val
+		} else if option == "capa" && strings.ToLower(val) == "eof" {
+			rc.replCapaEOF = true
+		}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responsePSYNC(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.replicant = NewReplicantConnection(rc)
+
+	if len(parseInfo.Args) >= 2 {
+		replid, _ := parseInfo.Args[0].Value.(string)
+		if len(parseInfo.Args) >= 3 && strings.ToUpper(parseInfo.Args[2].Value.(string)) == "FAILOVER" {
+			// The master hands over to this replica, which takes over its
+			// history so the master can continue from it as a replica.
+			if replid != rc.Server.ServerInfo.Replication.MasterReplid {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "PSYNC FAILOVER replid must match my replid."}}}
+			}
+
+			fmt.Printf("Failover request received for replid %s.\n", replid)
+			rc.Server.PromoteToMaster()
+		}
+
+		offset, err := strconv.Atoi(parseInfo.Args[1].Value.(string))
+		if err == nil {
+			continued, err := rc.Server.PartialSync(rc.replicant, replid, offset)
+			if err != nil {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+			} else if continued {
+				return []RESPValue{}
+			}
+		}
+	}
+
+	err := rc.Server.FullSync(ctx, rc.replicant)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{}
+}
+
+func (rc *RedisConnection) responseREPLICAOF(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	host := parseInfo.Args[0].Value.(string)
+	port := parseInfo.Args[1].Value.(string)
+
+	if rc.Server.ServerInfo.Replication.Failover.State() != failoverStateNone {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "REPLICAOF not allowed while failing over."}}}
+	}
+
+	if strings.ToUpper(host) == "NO" && strings.ToUpper(port) == "ONE" {
+		rc.Server.PromoteToMaster()
+		return []RESPValue{{Type: SimpleString, Value: "OK"}}
+	}
+
+	_, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Invalid master port"}}}
+	}
+
+	if !rc.Server.ReplicaOf(ctx, host, port) {
+		return []RESPValue{{Type: SimpleString, Value: "OK Already connected to specified master"}}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseFAILOVER(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	host, port := "", ""
+	timeout := int64(0)
+	force, abort := false, false
+
+	for i := 0; i < len(parseInfo.Args); i++ {
+		switch strings.ToUpper(parseInfo.Args[i].Value.(string)) {
+		case "TO":
+			if i+2 >= len(parseInfo.Args) {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+			}
+
+			host, port = parseInfo.Args[i+1].Value.(string), parseInfo.Args[i+2].Value.(string)
+			_, err := strconv.ParseUint(port, 10, 16)
+			if err != nil {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Invalid port"}}}
+			}
+			i += 2
+		case "TIMEOUT":
+			if i+1 >= len(parseInfo.Args) {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+			}
+
+			t, err := strconv.ParseInt(parseInfo.Args[i+1].Value.(string), 10, 64)
+			if err != nil || t <= 0 {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "FAILOVER timeout must be greater than 0"}}}
+			}
+			timeout = t
+			i++
+		case "FORCE":
+			force = true
+		case "ABORT":
+			abort = true
+		default:
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+	}
+
+	if abort {
+		if len(parseInfo.Args) > 1 {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+
+		if !rc.Server.ServerInfo.Replication.Failover.Abort() {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "No failover in progress."}}}
+		}
+
+		return []RESPValue{{Type: SimpleString, Value: "OK"}}
+	}
+
+	if force && (host == "" || timeout == 0) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "FAILOVER with force option requires both a timeout and target HOST and IP."}}}
+	}
+
+	err := rc.Server.StartFailover(ctx, host, port, time.Duration(timeout)*time.Millisecond, force)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseWAIT(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.Server.ServerInfo.Replication.Role() == "slave" {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated."}}}
+	}
+
+	replicants, err := strconv.Atoi(parseInfo.Args[0].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("number of replicants for WAIT command could not be converted to an int: %v", err)}}}
+	}
+
+	timeout, err := strconv.Atoi(parseInfo.Args[1].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("deadline for WAIT command could not be converted to an int: %v", err)}}}
+	}
+	processedThresh := rc.Server.ServerInfo.Replication.MasterReplOffset
+	consistent := rc.Server.ServerInfo.Replication.Replicants.WaitForConsistency(ctx, replicants, time.Millisecond*time.Duration(timeout), processedThresh, rc.Server.RequestAcknowledgements)
+	return []RESPValue{{Type: Integer, Value: consistent}}
+}
+
+func (rc *RedisConnection) configGET(parseInfo ParseInfo) []RESPValue {
+	res := []RESPValue{}
+	for _, arg := range parseInfo.Args[1:] {
+		name := strings.ToLower(arg.Value.(string))
+		val, err := rc.Server.ConfigGet(name)
+		if err != nil {
+			continue
+		}
+
+		res = append(res, RESPValue{Type: BulkString, Value: name}, RESPValue{Type: BulkString, Value: val})
+	}
+
+	return []RESPValue{{Type: Array, Value: res}}
+}
+
+func (rc *RedisConnection) configSET(parseInfo ParseInfo) []RESPValue {
+	if len(parseInfo.Args) < 3 || len(parseInfo.Args)%2 != 1 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'config|set' command"}}}
+	}
+
+	for i := 1; i+1 < len(parseInfo.Args); i += 2 {
+		err := rc.Server.ConfigSet(parseInfo.Args[i].Value.(string), parseInfo.Args[i+1].Value.(string))
+		if err != nil {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+		}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseCONFIG(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if len(parseInfo.Args) < 2 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'config' command"}}}
+	}
+
+	action, ok := parseInfo.Args[0].Value.(string)
+	if !ok {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "failed to convert CONFIG arg 0 to string"}}}
+	}
+
+	switch strings.ToUpper(action) {
+	case "GET":
+		return rc.configGET(parseInfo)
+	case "SET":
+		return rc.configSET(parseInfo)
+	}
+
+	return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "CONFIG arg must be GET or SET"}}}
+}
+
+func (rc *RedisConnection) responseSAVE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	err := rc.Server.Save(rc.inExec)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseBGSAVE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	err := rc.Server.BackgroundSave(rc.inExec)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "Background saving started"}}
+}
+
+func (rc *RedisConnection) responseBGREWRITEAOF(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	scheduled, err := rc.Server.BackgroundRewriteAppendOnly()
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	if scheduled {
+		return []RESPValue{{Type: SimpleString, Value: "Background append only file rewriting scheduled"}}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "Background append only file rewriting started"}}
+}
+
+func (rc *RedisConnection) responseFLUSHDB(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if !parseFlushMode(parseInfo.Args) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	rc.Server.Database.Flush()
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+// responseSHUTDOWN stops the server, saving the RDB file first when save
+// points are configured or SAVE is given, unless NOSAVE is. Only SHUTDOWN
+// NOSAVE runs while a script is busy, as saving would wait for the script.
+func (rc *RedisConnection) responseSHUTDOWN(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.ServerInfo.Persistence.Acquire()
+	save := len(rc.Server.ServerInfo.Persistence.SavePoints) > 0
+	rc.Server.ServerInfo.Persistence.Release()
+
+	noSave := false
+	for _, arg := range parseInfo.Args {
+		switch strings.ToUpper(arg.Value.(string)) {
+		case "NOSAVE":
+			save, noSave = false, true
+		case "SAVE":
+			save = true
+		default:
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+		}
+	}
+
+	busy, isBusy := rc.Server.RunningScript.Busy()
+	if isBusy && !noSave {
+		return []RESPValue{busy}
+	}
+
+	err := rc.Server.Shutdown(save)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Errors trying to SHUTDOWN. Check logs."}}}
+	}
+
+	rc.quit = true
+	return []RESPValue{}
+}
+
+func (rc *RedisConnection) responseLASTSAVE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.ServerInfo.Persistence.Acquire()
+	lastSave := rc.Server.ServerInfo.Persistence.LastSave
+	rc.Server.ServerInfo.Persistence.Release()
+
+	return []RESPValue{{Type: Integer, Value: int(lastSave.Unix())}}
+}
+
+func typeFromVal(val RESPValue) string {
+	switch val.Type {
+	case NullBulkString:
+		return "none"
+	case Stream:
+		return "stream"
+	default:
+		return "string"
+	}
+}
+
+func (rc *RedisConnection) responseDEL(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	deleted := 0
+	for _, arg := range parseInfo.Args {
+		key := arg.Value.(string)
+		if rc.Server.Database.Delete(key) {
+			rc.Server.Database.Notify(notifyGeneric, "del", key)
+			deleted += 1
+		}
+	}
+
+	return []RESPValue{{Type: Integer, Value: deleted}}
+}
+
+func (rc *RedisConnection) responseTYPE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	key := parseInfo.Args[0].Value.(string)
+	val := rc.Server.GetValue(key)
+	return []RESPValue{{Type: SimpleString, Value: typeFromVal(val)}}
+}
+
+func (rc *RedisConnection) responseXADD(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	streamName := parseInfo.Args[0].Value.(string)
+	fields := []Pair{}
+
+	if len(parseInfo.Args)%2 != 0 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'xadd' command"}}}
+	}
+
+	i := 2
+	for i+1 < len(parseInfo.Args) {
+		argName := parseInfo.Args[i].Value.(string)
+		argVal := parseInfo.Args[i+1].Value.(string)
+		fields = append(fields, Pair{Key: argName, Val: argVal})
+		i += 2
+	}
+
+	stream := StreamLog{Name: streamName, Entries: []StreamEntry{}}
+	existing := rc.Server.GetValue(streamName)
+	if existing.Type == Stream {
+		stream = existing.Value.(StreamLog)
+	} else if existing.Type != NullBulkString {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "WRONGTYPE", Message: "Operation against a key holding the wrong kind of value"}}}
+	}
+
+	id, err := NextStreamID(parseInfo.Args[1].Value.(string), stream.LastID(), time.Now())
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	stream.Entries = append(stream.Entries, StreamEntry{Id: id.String(), Fields: fields})
+	rc.Server.SetValue(streamName, RESPValue{Type: Stream, Value: stream}, -1)
+	rc.Server.Database.Notify(notifyStream, "xadd", streamName)
+
+	args := []string{"XADD", streamName, id.String()}
+	for _, field := range fields {
+		args = append(args, field.Key, field.Val)
+	}
+	rc.propagateAs(CommandRESP(args...))
+
+	return []RESPValue{{Type: BulkString, Value: id.String()}}
+}
+
+func (rc *RedisConnection) responseMULTI(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.multi != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "MULTI calls can not be nested"}}}
+	}
+
+	rc.multi = NewTransaction()
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseDISCARD(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.multi == nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "DISCARD without MULTI"}}}
+	}
+
+	rc.multi = nil
+	rc.Server.Database.Unwatch(rc.watched)
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseWATCH(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.multi != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "WATCH inside MULTI is not allowed"}}}
+	}
+
+	for _, arg := range parseInfo.Args {
+		rc.Server.Database.Watch(rc.watched, arg.Value.(string))
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) responseUNWATCH(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	rc.Server.Database.Unwatch(rc.watched)
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+// responseEXEC runs the queued commands under the write lock, so no other
+// write is applied in between, and propagates their writes as one block. It
+// runs nothing and replies with a null array when a watched key was modified.
+func (rc *RedisConnection) responseEXEC(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if rc.multi == nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "EXEC without MULTI"}}}
+	}
+
+	transaction := rc.multi
+	rc.multi = nil
+	defer rc.Server.Database.Unwatch(rc.watched)
+
+	if transaction.Aborted() {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "EXECABORT", Message: "Transaction discarded because of previous errors."}}}
+	}
+
+	return rc.Server.ExecuteWrite(func() ([]RESPValue, []RESPValue) {
+		if rc.Server.Database.WatchedModified(rc.watched) {
+			return []RESPValue{{Type: NullArray}}, []RESPValue{}
+		}
+
+		rc.inExec = true
+		defer func() { rc.inExec = false }()
+
+		results := []RESPValue{}
+		propagated := []RESPValue{}
+		for _, queued := range transaction.commands {
+			if queued.command.HasFlag(commandWrite) {
+				rejection, rejected := rc.rejection(queued.command)
+				if rejected {
+					results = append(results, rejection)
+					continue
+				}
+			}
+
+			responses, written := rc.call(ctx, queued.resp, queued.parseInfo, queued.command)
+			results = append(results, responses...)
+			propagated = append(propagated, written...)
+		}
+
+		return []RESPValue{{Type: Array, Value: results}}, propagated
+	})
+}
+
+// parseScriptArgs splits the arguments of EVAL and EVALSHA after the script
+// into its keys and other arguments.
+func parseScriptArgs(args []RESPValue) ([]RESPValue, []RESPValue, error) {
+	numkeys, err := strconv.Atoi(args[0].Value.(string))
+	if err != nil {
+		return nil, nil, fmt.Errorf("value is not an integer or out of range")
+	}
+
+	if numkeys < 0 {
+		return nil, nil, fmt.Errorf("Number of keys can't be negative")
+	}
+
+	if numkeys > len(args)-1 {
+		return nil, nil, fmt.Errorf("Number of keys can't be greater than number of args")
+	}
+
+	return args[1 : numkeys+1], args[numkeys+1:], nil
+}
+
+func (rc *RedisConnection) responseEVAL(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	keys, argv, err := parseScriptArgs(parseInfo.Args[1:])
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	script, err := rc.Server.Scripts.Load(parseInfo.Args[0].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return rc.runScript(ctx, script, keys, argv)
+}
+
+func (rc *RedisConnection) responseEVALSHA(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	keys, argv, err := parseScriptArgs(parseInfo.Args[1:])
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	script, ok := rc.Server.Scripts.Get(parseInfo.Args[0].Value.(string))
+	if !ok {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "NOSCRIPT", Message: "No matching script. Please use EVAL."}}}
+	}
+
+	return rc.runScript(ctx, script, keys, argv)
+}
+
+func (rc *RedisConnection) scriptLOAD(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	script, err := rc.Server.Scripts.Load(parseInfo.Args[1].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{{Type: BulkString, Value: script.sha}}
+}
+
+func (rc *RedisConnection) scriptEXISTS(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	exists := []RESPValue{}
+	for _, arg := range parseInfo.Args[1:] {
+		_, ok := rc.Server.Scripts.Get(arg.Value.(string))
+		exists = append(exists, RESPValue{Type: Integer, Value: boolToInt(ok)})
+	}
+
+	return []RESPValue{{Type: Array, Value: exists}}
+}
+
+// parseFlushMode checks the optional ASYNC or SYNC argument of the flush
+// commands. Flushing is always synchronous.
+func parseFlushMode(args []RESPValue) bool {
+	if len(args) > 1 {
+		return false
+	}
+
+	if len(args) == 1 {
+		mode := strings.ToUpper(args[0].Value.(string))
+		return mode == "ASYNC" || mode == "SYNC"
+	}
+
+	return true
+}
+
+func (rc *RedisConnection) scriptFLUSH(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if !parseFlushMode(parseInfo.Args[1:]) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "SCRIPT FLUSH only support SYNC|ASYNC option"}}}
+	}
+
+	rc.Server.Scripts.Flush()
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) scriptKILL(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	killErr, ok := rc.Server.RunningScript.Kill(false)
+	if !ok {
+		return []RESPValue{killErr}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+// responseFCALL calls a library function for FCALL and FCALL_RO, which may
+// only call functions flagged no-writes.
+func (rc *RedisConnection) responseFCALL(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	keys, argv, err := parseScriptArgs(parseInfo.Args[1:])
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	library, function, ok := rc.Server.Database.Libraries().Find(parseInfo.Args[0].Value.(string))
+	if !ok {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Function not found"}}}
+	}
+
+	readOnly := parseInfo.Command == "FCALL_RO"
+	if readOnly && !function.HasFlag("no-writes") {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Can not execute a script with write flag using *_ro command."}}}
+	}
+
+	return rc.runFunction(ctx, library, function, keys, argv, readOnly)
+}
+
+func (rc *RedisConnection) functionLOAD(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	args := parseInfo.Args[1:]
+	replace := false
+	if len(args) > 2 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	if len(args) == 2 {
+		if strings.ToUpper(args[0].Value.(string)) != "REPLACE" {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Unknown option given: %s", args[0].Value.(string))}}}
+		}
+		replace = true
+	}
+
+	library, err := LoadFunctionLibrary(args[len(args)-1].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	libraries, err := rc.Server.Database.Libraries().With(library, replace)
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	rc.Server.Database.SetLibraries(libraries)
+	return []RESPValue{{Type: BulkString, Value: library.name}}
+}
+
+func (rc *RedisConnection) functionDELETE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	name := parseInfo.Args[1].Value.(string)
+	libraries := rc.Server.Database.Libraries()
+	if _, ok := libraries[name]; !ok {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Library not found"}}}
+	}
+
+	rc.Server.Database.SetLibraries(libraries.Without(name))
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) functionFLUSH(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	if !parseFlushMode(parseInfo.Args[1:]) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "FUNCTION FLUSH only supports SYNC|ASYNC option"}}}
+	}
+
+	rc.Server.Database.SetLibraries(FunctionLibraries{})
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func (rc *RedisConnection) functionDUMP(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	payload := &strings.Builder{}
+	err := NewRDBWriter(payload).WriteFunctions(rc.Server.Database.Libraries())
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{{Type: BulkString, Value: payload.String()}}
+}
+
+// functionRESTORE adds the libraries in a FUNCTION DUMP payload. APPEND, the
+// default, fails if a library already exists, REPLACE replaces it, and FLUSH
+// deletes every library first.
+func (rc *RedisConnection) functionRESTORE(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	policy := "APPEND"
+	if len(parseInfo.Args) > 3 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "syntax error"}}}
+	}
+
+	if len(parseInfo.Args) == 3 {
+		policy = strings.ToUpper(parseInfo.Args[2].Value.(string))
+		if policy != "APPEND" && policy != "REPLACE" && policy != "FLUSH" {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE."}}}
+		}
+	}
+
+	restored, err := ReadFunctions(parseInfo.Args[1].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	libraries := rc.Server.Database.Libraries()
+	if policy == "FLUSH" {
+		libraries = FunctionLibraries{}
+	}
+
+	for _, library := range restored {
+		libraries, err = libraries.With(library, policy == "REPLACE")
+		if err != nil {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+		}
+	}
+
+	rc.Server.Database.SetLibraries(libraries)
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+func libraryRESP(library *FunctionLibrary, withCode bool) RESPValue {
+	names := []string{}
+	for name := range library.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	functions := []RESPValue{}
+	for _, name := range names {
+		function := library.functions[name]
+		description := RESPValue{Type: NullBulkString}
+		if function.description != "" {
+			description = RESPValue{Type: BulkString, Value: function.description}
+		}
+
+		functions = append(functions, RESPValue{Type: Array, Value: []RESPValue{
+			{Type: BulkString, Value: "name"}, {Type: BulkString, Value: function.name},
+			{Type: BulkString, Value: "description"}, description,
+			{Type: BulkString, Value: "flags"}, CommandRESP(function.flags...),
+		}})
+	}
+
+	fields := []RESPValue{
+		{Type: BulkString, Value: "library_name"}, {Type: BulkString, Value: library.name},
+		{Type: BulkString, Value: "engine"}, {Type: BulkString, Value: "LUA"},
+		{Type: BulkString, Value: "functions"}, {Type: Array, Value: functions},
+	}
+	if withCode {
+		fields = append(fields, RESPValue{Type: BulkString, Value: "library_code"}, RESPValue{Type: BulkString, Value: library.code})
+	}
+
+	return RESPValue{Type: Array, Value: fields}
+}
+
+func (rc *RedisConnection) functionLIST(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	withCode := false
+	pattern := "*"
+	for i := 1; i < len(parseInfo.Args); i++ {
+		switch strings.ToUpper(parseInfo.Args[i].Value.(string)) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(parseInfo.Args) {
+				return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "library name argument was not given"}}}
+			}
+			pattern = parseInfo.Args[i+1].Value.(string)
+			i++
+		default:
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Unknown argument %s", parseInfo.Args[i].Value.(string))}}}
+		}
+	}
+
+	libraries := []RESPValue{}
+	for _, library := range rc.Server.Database.Libraries().Sorted() {
+		if globMatch(pattern, library.name) {
+			libraries = append(libraries, libraryRESP(library, withCode))
+		}
+	}
+
+	return []RESPValue{{Type: Array, Value: libraries}}
+}
+
+func (rc *RedisConnection) functionSTATS(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	running := RESPValue{Type: NullBulkString}
+	name, duration, ok := rc.Server.RunningScript.Running(true)
+	if ok {
+		running = RESPValue{Type: Array, Value: []RESPValue{
+			{Type: BulkString, Value: "name"}, {Type: BulkString, Value: name},
+			{Type: BulkString, Value: "duration_ms"}, {Type: Integer, Value: int(duration.Milliseconds())},
+		}}
+	}
+
+	libraries := rc.Server.Database.Libraries()
+	engine := []RESPValue{
+		{Type: BulkString, Value: "libraries_count"}, {Type: Integer, Value: len(libraries)},
+		{Type: BulkString, Value: "functions_count"}, {Type: Integer, Value: libraries.Functions()},
+	}
+
+	return []RESPValue{{Type: Array, Value: []RESPValue{
+		{Type: BulkString, Value: "running_script"}, running,
+		{Type: BulkString, Value: "engines"}, {Type: Array, Value: []RESPValue{{Type: BulkString, Value: "LUA"}, {Type: Array, Value: engine}}},
+	}}}
+}
+
+func (rc *RedisConnection) functionKILL(ctx context.Context, parseInfo ParseInfo) []RESPValue {
+	killErr, ok := rc.Server.RunningScript.Kill(true)
+	if !ok {
+		return []RESPValue{killErr}
+	}
+
+	return []RESPValue{{Type: SimpleString, Value: "OK"}}
+}
+
+// checkCall looks up the command parseInfo calls, returning the error to
+// answer with when there is no such command or it has the wrong number of
+// arguments.
+func checkCall(parseInfo ParseInfo) (Command, RESPValue, bool) {
+	command, ok := commandTable[strings.ToUpper(parseInfo.Command)]
+	if !ok {
+		return Command{}, RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "command not found"}}, false
+	}
+
+	if !command.CheckArity(parseInfo.Args) {
+		return Command{}, RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(command.Name))}}, false
+	}
+
+	if command.Subcommands == nil {
+		return command, RESPValue{}, true
+	}
+
+	subcommand, ok := lookupCommand(parseInfo)
+	if !ok {
+		return Command{}, RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("unknown subcommand '%s'. Try %s HELP.", parseInfo.Args[0].Value.(string), command.Name)}}, false
+	}
+
+	if !subcommand.CheckArity(parseInfo.Args) {
+		return Command{}, RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(subcommand.Name))}}, false
+	}
+
+	return subcommand, RESPValue{}, true
 }
 
 func (rc *RedisConnection) ResponseFromArgs(ctx context.Context, parseInfo ParseInfo) []RESPValue {
-	switch parseInfo.Command {
-	case "PING":
-		return rc.responsePING(ctx, parseInfo)
-	case "ECHO":
-		return rc.responseECHO(ctx, parseInfo)
-	case "GET":
-		return rc.responseGET(ctx, parseInfo)
-	case "SET":
-		return rc.responseSET(ctx, parseInfo)
-	case "INFO":
-		return rc.responseINFO(ctx, parseInfo)
-	case "REPLCONF":
-		return rc.responseREPLCONF(ctx, parseInfo)
-	case "PSYNC":
-		return rc.responsePSYNC(ctx, parseInfo)
-	case "WAIT":
-		return rc.responseWAIT(ctx, parseInfo)
-	case "CONFIG":
-		return rc.responseCONFIG(ctx, parseInfo)
-	case "TYPE":
-		return rc.responseTYPE(ctx, parseInfo)
-	case "XADD":
-		return rc.responseXADD(ctx, parseInfo)
-	}
-
-	return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "command not found"}}}
+	command, callErr, ok := checkCall(parseInfo)
+	if !ok {
+		return []RESPValue{callErr}
+	}
+
+	return rc.invoke(ctx, command, parseInfo)
+}
+
+// feedMonitors shows a command run by the client at source to the clients
+// monitoring the server.
+func (rc *RedisConnection) feedMonitors(command Command, parseInfo ParseInfo, source string) {
+	if command.HasFlag(commandNoMonitor) {
+		return
+	}
+
+	args := []string{strings.ToLower(parseInfo.Command)}
+	for _, arg := range parseInfo.Args {
+		args = append(args, arg.Value.(string))
+	}
+
+	rc.Server.Monitors.Feed(source, args)
 }
 
 func (rc *RedisConnection) Close() error {
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// clientIDs returns the IDs of the clients in a CLIENT LIST reply.
+func clientIDs(list RESPValue) []string {
+	ids := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(list.Value.(string)), "\n") {
+		if id, ok := strings.CutPrefix(line, "id="); ok {
+			id, _, _ = strings.Cut(id, " ")
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// clientField returns a field of the CLIENT INFO line of client.
+func clientField(client *testClient, field string) string {
+	for _, pair := range strings.Fields(client.do("CLIENT", "INFO").Value.(string)) {
+		if name, val, ok := strings.Cut(pair, "="); ok && name == field {
+			return val
+		}
+	}
+
+	return ""
+}
+
+// expectClosed checks the server disconnected client.
+func expectClosed(t *testing.T, client *testClient) {
+	t.Helper()
+
+	if resp, err := client.try("PING"); err == nil {
+		t.Fatalf("killed client was answered %v", resp)
+	}
+}
+
+func TestClientListFilters(t *testing.T) {
+	port := startTestServer(t, "")
+
+	normal := newTestClient(t, port)
+	normalID := clientField(normal, "id")
+	subscriber := newTestClient(t, port)
+	subscriberID := clientField(subscriber, "id")
+	subscriber.do("SUBSCRIBE", "news")
+
+	if ids := clientIDs(normal.do("CLIENT", "LIST", "ID", normalID, "999")); len(ids) != 1 || ids[0] != normalID {
+		t.Fatalf("CLIENT LIST ID %s listed %v", normalID, ids)
+	}
+	if ids := clientIDs(normal.do("CLIENT", "LIST", "TYPE", "pubsub")); len(ids) != 1 || ids[0] != subscriberID {
+		t.Fatalf("CLIENT LIST TYPE pubsub listed %v, want %s", ids, subscriberID)
+	}
+	if ids := clientIDs(normal.do("CLIENT", "LIST", "TYPE", "normal")); len(ids) != 1 || ids[0] != normalID {
+		t.Fatalf("CLIENT LIST TYPE normal listed %v, want %s", ids, normalID)
+	}
+	if ids := clientIDs(normal.do("CLIENT", "LIST")); len(ids) != 2 {
+		t.Fatalf("CLIENT LIST listed %v", ids)
+	}
+
+	for _, args := range [][]string{{"TYPE", "bogus"}, {"ID", "0"}, {"ID"}, {"NAME", "x"}} {
+		if resp := normal.do(append([]string{"CLIENT", "LIST"}, args...)...); resp.Type != SimpleError {
+			t.Fatalf("CLIENT LIST %v returned %v", args, resp)
+		}
+	}
+}
+
+func TestClientKillFilters(t *testing.T) {
+	port := startTestServer(t, "")
+	admin := newTestClient(t, port)
+
+	byID := newTestClient(t, port)
+	if resp := admin.do("CLIENT", "KILL", "ID", clientField(byID, "id")); resp.Value != 1 {
+		t.Fatalf("CLIENT KILL ID returned %v", resp)
+	}
+	expectClosed(t, byID)
+
+	byAddr := newTestClient(t, port)
+	addr := clientField(byAddr, "addr")
+	if resp := admin.do("CLIENT", "KILL", addr); resp.Value != "OK" {
+		t.Fatalf("CLIENT KILL %s returned %v", addr, resp)
+	}
+	expectClosed(t, byAddr)
+	if resp := admin.do("CLIENT", "KILL", addr); !isError(resp, "ERR") {
+		t.Fatalf("CLIENT KILL of a closed client returned %v", resp)
+	}
+
+	// Every filter given must match.
+	byType := newTestClient(t, port)
+	subscriber := newTestClient(t, port)
+	subscriber.do("SUBSCRIBE", "news")
+	if resp := admin.do("CLIENT", "KILL", "TYPE", "pubsub", "ADDR", clientField(byType, "addr")); resp.Value != 0 {
+		t.Fatalf("CLIENT KILL matching no client returned %v", resp)
+	}
+	if resp := admin.do("CLIENT", "KILL", "MAXAGE", "3600"); resp.Value != 0 {
+		t.Fatalf("CLIENT KILL MAXAGE of new clients returned %v", resp)
+	}
+	if resp := admin.do("CLIENT", "KILL", "TYPE", "pubsub"); resp.Value != 1 {
+		t.Fatalf("CLIENT KILL TYPE pubsub returned %v", resp)
+	}
+	expectClosed(t, subscriber)
+
+	// The caller is skipped unless SKIPME no is given.
+	if resp := admin.do("CLIENT", "KILL", "TYPE", "normal"); resp.Value != 1 {
+		t.Fatalf("CLIENT KILL TYPE normal returned %v", resp)
+	}
+	expectClosed(t, byType)
+	if resp := admin.do("CLIENT", "KILL", "ID", clientField(admin, "id"), "SKIPME", "no"); resp.Value != 1 {
+		t.Fatalf("CLIENT KILL of the caller returned %v", resp)
+	}
+	expectClosed(t, admin)
+
+	client := newTestClient(t, port)
+	for _, args := range [][]string{{"ID", "0"}, {"TYPE", "bogus"}, {"USER", "nobody"}, {"SKIPME", "maybe"}, {"MAXAGE", "soon"}, {"ID"}} {
+		if resp := client.do(append([]string{"CLIENT", "KILL"}, args...)...); resp.Type != SimpleError {
+			t.Fatalf("CLIENT KILL %v returned %v", args, resp)
+		}
+	}
+	if resp := client.do("CLIENT", "KILL", "ID", strconv.Itoa(1<<30)); resp.Value != 0 {
+		t.Fatalf("CLIENT KILL of an unknown ID returned %v", resp)
+	}
+}
@@ -2,93 +2,232 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
+)
+
+const redisVersion = "7.2.0"
+
+const (
+	expireCycleInterval   = 100 * time.Millisecond
+	expireCycleSampleSize = 20
+	expireCycleTimeLimit  = 25 * time.Millisecond
 )
 
 type RedisServer struct {
 	Database         *Database
 	ServerInfo       ServerInfo
-	connectionBuffer Clients
+	AOF              *AppendOnlyFile
+	connectionBuffer *Clients
+	writeLock        sync.RWMutex
+	writesPaused     bool
+	writesResumed    *sync.Cond
+	Scripts          *ScriptCache
+	RunningScript    *RunningScript
+	PubSub           *PubSub
+	Notifier         *KeyspaceNotifier
+	Clients          *ClientRegistry
+	Tracking         *TrackingTable
+	Pause            *ClientPause
+	Monitors         *Monitors
+	loaded           bool
+	replicationLock  sync.Mutex
+	stopReplication  func()
+	disklessSync     *DisklessSync
+	disklessLock     sync.Mutex
+	shutdown         context.CancelFunc
 }
 
 func createServerInfo(port string, replicaOf string, dir string, dbfilename string) ServerInfo {
 	role := "master"
-	masterPort := port
+	masterHost, masterPort := "", port
 	if replicaOf != "" {
 		role = "slave"
-		_, masterPort, _ = strings.Cut(replicaOf, " ")
+		masterHost, masterPort, _ = strings.Cut(replicaOf, " ")
 	}
 
-	persistenceInfo := PersistenceInfo{Dir: dir, Dbfilename: dbfilename}
-	replicationInfo := ReplicationInfo{Role: role, Port: port, MasterPort: masterPort, MasterReplid: ReplicationID, MasterReplOffset: 0}
+	persistenceInfo := &PersistenceInfo{
+		Dir:                      dir,
+		Dbfilename:               dbfilename,
+		AppendFilename:           "appendonly.aof",
+		AppendDirname:            "appendonlydir",
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 * 1024 * 1024,
+		LastSave:                 time.Now(),
+		LastBgsaveStatus:         "ok",
+	}
+	replicationInfo := &ReplicationInfo{
+		role:             role,
+		Port:             port,
+		Replicants:       NewReplicants(),
+		masterHost:       masterHost,
+		masterPort:       masterPort,
+		MasterLink:       NewMasterLink(),
+		MasterReplid:     NewReplicationID(),
+		MasterReplid2:    noReplicationID,
+		MasterReplOffset: 0,
+		SecondReplOffset: -1,
+		ReadOnly:         true,
+		ServeStaleData:   true,
+		MinReplicasLag:   10,
+		ReplicaPriority:  100,
+		DisklessDelay:    5,
+		DisklessLoad:     disklessLoadDisabled,
+		ReplBacklogSize:  defaultReplBacklogSize,
+		Failover:         NewFailover(),
+	}
 	return ServerInfo{Persistence: persistenceInfo, Replication: replicationInfo}
 }
 
-func (rs *RedisServer) handleMaster(ctx context.Context, masterConn *MasterConnection) {
-	defer masterConn.Close()
-
-	err := masterConn.HandleMaster(ctx)
-	if err != nil && err != io.EOF {
-		fmt.Printf("failed to handle master requests: %v\n", err)
+func NewRedisServer(port string, replicaOf string, dir string, dbfilename string) (*RedisServer, error) {
+	rs := &RedisServer{
+		Database:         NewDatabase(),
+		ServerInfo:       createServerInfo(port, replicaOf, dir, dbfilename),
+		AOF:              NewAppendOnlyFile(appendFsyncEverysec),
+		connectionBuffer: NewClients(),
+		Pause:            NewClientPause(),
+		Monitors:         NewMonitors(),
+		Scripts:          NewScriptCache(),
+		RunningScript:    NewRunningScript(),
+		PubSub:           NewPubSub(),
 	}
+	rs.Notifier = NewKeyspaceNotifier(rs.PubSub)
+	rs.Database.SetNotifier(rs.Notifier)
+	rs.Clients = NewClientRegistry()
+	rs.Tracking = NewTrackingTable(rs.Clients)
+	rs.Database.SetTracking(rs.Tracking)
+	rs.writesResumed = sync.NewCond(&rs.writeLock)
+	return rs, nil
 }
 
-func (rs *RedisServer) handshake(ctx context.Context) error {
-	if rs.ServerInfo.Replication.Role == "slave" {
-		masterTCP, err := DialTCPConnection(":" + rs.ServerInfo.Replication.MasterPort)
+// load restores the dataset from the append only file when it is enabled and
+// exists, and from the RDB file otherwise.
+func (rs *RedisServer) load(ctx context.Context) error {
+	dir, filename := rs.aofPath()
+	if rs.appendOnlyConfigured() && AppendOnlyFileExists(dir, filename) {
+		err := rs.loadAppendOnly(ctx)
 		if err != nil {
-			return fmt.Errorf("error dialing connection: %v", err)
+			return fmt.Errorf("failed to load append only file: %v", err)
 		}
 
-		master := &MasterConnection{NewRedisConnection(NewRESPConnection(masterTCP), rs)}
-		err = master.Handshake(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to run handshake: %v", err)
-		}
+		return nil
+	}
 
-		go rs.handleMaster(ctx, master)
+	err := rs.loadRDB()
+	if err != nil {
+		return fmt.Errorf("failed to load RDB file: %v", err)
 	}
 
-	return nil
-}
+	if rs.appendOnlyConfigured() {
+		return rs.StartAppendOnly(ctx)
+	}
 
-func NewRedisServer(port string, replicaOf string, dir string, dbfilename string) (*RedisServer, error) {
-	rs := &RedisServer{Database: NewDatabase(), ServerInfo: createServerInfo(port, replicaOf, dir, dbfilename), connectionBuffer: Clients{}}
-	return rs, nil
+	return nil
 }
 
+// Run serves clients until ctx is done or the server is shut down.
 func (rs *RedisServer) Run(ctx context.Context) error {
+	ctx, rs.shutdown = context.WithCancel(ctx)
+	defer rs.shutdown()
+
+	err := rs.load(ctx)
+	if err != nil {
+		return err
+	}
+	rs.loaded = true
+
 	listener, err := rs.listen()
 	if err != nil {
 		return err
 	}
 
 	go rs.takeConnections(listener)
+	go rs.persistenceCron(ctx)
+	go rs.expireCron(ctx)
 
-	err = rs.handshake(ctx)
-	if err != nil {
-		return err
+	if rs.ServerInfo.Replication.Role() == "slave" {
+		rs.startReplication(ctx)
 	}
 
 	rs.handleClients(ctx)
+	listener.Close()
+
+	return nil
+}
+
+// Shutdown stops the server, after saving the RDB file when save is set. The
+// append only file is closed first, so a script cut short by the shutdown is
+// never logged half applied.
+func (rs *RedisServer) Shutdown(save bool) error {
+	if save {
+		snapshot, err := rs.snapshotAtomically(context.Background(), func() error { return nil })
+		if err == nil {
+			err = rs.saveSnapshot(snapshot)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save RDB file: %v", err)
+		}
+	}
+
+	rs.StopAppendOnly()
+	rs.shutdown()
+	for _, client := range rs.Clients.List() {
+		client.Close()
+	}
 
 	return nil
 }
 
 func (rs *RedisServer) handleClients(ctx context.Context) {
+	rs.connectionBuffer.HandleAll(ctx)
+}
+
+// expireCron actively deletes expired keys, so they are deleted, and reported
+// as expired, without being accessed. Replicas leave that to their master.
+func (rs *RedisServer) expireCron(ctx context.Context) {
+	ticker := time.NewTicker(expireCycleInterval)
+	defer ticker.Stop()
+
 	for {
-		rs.connectionBuffer.HandleAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rs.activeExpireCycle()
+		}
 	}
 }
 
+// activeExpireCycle runs an expire cycle under the write lock, propagating
+// the deletions like writes. Replicas leave it to their master, and it is
+// skipped while writes are paused.
+func (rs *RedisServer) activeExpireCycle() {
+	if rs.ServerInfo.Replication.Role() == "slave" {
+		return
+	}
+
+	rs.writeLock.Lock()
+	defer rs.writeLock.Unlock()
+
+	if rs.writesPaused {
+		return
+	}
+
+	rs.Database.ExpireCycle(expireCycleSampleSize, expireCycleTimeLimit)
+	rs.propagateExpired()
+}
+
 func (rs *RedisServer) takeConnections(listener net.Listener) {
 	for {
 		conn, err := AcceptTCPConnection(listener)
-		if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
 			fmt.Printf("error accepting connection: %v\n", err)
 			os.Exit(1)
 		}
@@ -109,22 +248,286 @@ func (rs *RedisServer) SetValue(key string, value RESPValue, expiry int) {
 	rs.Database.SetValue(key, value, expiry)
 }
 
-func GetBytes(resp RESPValue) (int, error) {
-	str, err := resp.ToString()
+// ExecuteWrite applies a write and propagates the commands it returns while
+// holding the write lock, so snapshots taken for replicas see either both or
+// neither. Several commands are wrapped in MULTI/EXEC so they are applied
+// atomically. Writes made directly on a writable replica are not part of the
+// master's stream, so they are only logged. While writes are paused, the
+// write waits until they resume.
+func (rs *RedisServer) ExecuteWrite(apply func() ([]RESPValue, []RESPValue)) []RESPValue {
+	rs.writeLock.Lock()
+	defer rs.writeLock.Unlock()
+
+	for rs.writesPaused {
+		rs.writesResumed.Wait()
+	}
+
+	rs.propagateExpired()
+	responses, propagated := apply()
+	if len(propagated) > 1 {
+		propagated = append([]RESPValue{CommandRESP("MULTI")}, append(propagated, CommandRESP("EXEC"))...)
+	}
+	propagated = append(rs.expiredDeletions(), propagated...)
+
+	for _, resp := range propagated {
+		if rs.ServerInfo.Replication.Role() != "slave" {
+			rs.propagate(resp)
+		}
+		rs.feedAppendOnly(resp)
+	}
+
+	return responses
+}
+
+// ExecuteRead runs a read under the write lock shared with other reads, so it
+// never sees a transaction or a script half applied. The keys it found
+// expired are propagated afterwards, under the write lock itself.
+func (rs *RedisServer) ExecuteRead(read func() []RESPValue) []RESPValue {
+	rs.writeLock.RLock()
+	responses := read()
+	rs.writeLock.RUnlock()
+
+	if rs.Database.HasExpired() {
+		rs.writeLock.Lock()
+		if !rs.writesPaused {
+			rs.propagateExpired()
+		}
+		rs.writeLock.Unlock()
+	}
+
+	return responses
+}
+
+// ExecuteFromMaster applies commands streamed by the master and forwards them
+// verbatim to this server's own replicas, so the replication ID and offsets
+// stay the same all along a chain of replicas.
+func (rs *RedisServer) ExecuteFromMaster(commands []RESPValue, write bool, apply func() []RESPValue) []RESPValue {
+	rs.writeLock.Lock()
+	defer rs.writeLock.Unlock()
+
+	responses := apply()
+	// Keys expired here are deleted once the master says so.
+	rs.Database.TakeExpired()
+	for _, resp := range commands {
+		rs.propagate(resp)
+		if write {
+			rs.feedAppendOnly(resp)
+		}
+	}
+
+	return responses
+}
+
+// expiredDeletions returns a DEL for every key that expired since it was last
+// called, as replicas and the AOF rely on the master to delete expired keys.
+// Replicas don't propagate their own. Called under the write lock.
+func (rs *RedisServer) expiredDeletions() []RESPValue {
+	expired := rs.Database.TakeExpired()
+	if rs.ServerInfo.Replication.Role() == "slave" {
+		return nil
+	}
+
+	deletions := []RESPValue{}
+	for _, key := range expired {
+		deletions = append(deletions, CommandRESP("DEL", key))
+	}
+
+	return deletions
+}
+
+// propagateExpired propagates the deletions of expired keys on their own.
+// Called under the write lock.
+func (rs *RedisServer) propagateExpired() {
+	for _, resp := range rs.expiredDeletions() {
+		rs.propagate(resp)
+		rs.feedAppendOnly(resp)
+	}
+}
+
+func (rs *RedisServer) propagate(resp RESPValue) {
+	rs.ServerInfo.Replication.Replicants.Propogate(resp)
+	rs.ProcessBytes(resp)
+}
+
+// RequestAcknowledgements asks every replica for its replication offset. The
+// request is part of the replication stream, so it counts towards the offset.
+func (rs *RedisServer) RequestAcknowledgements() {
+	rs.writeLock.Lock()
+	defer rs.writeLock.Unlock()
+
+	rs.propagate(RESPValue{Array, []RESPValue{{BulkString, "REPLCONF"}, {BulkString, "GETACK"}, {BulkString, "*"}}})
+}
+
+// createBacklog starts recording the replication stream the first time a
+// replica attaches.
+func (rs *RedisServer) createBacklog() {
+	info := rs.ServerInfo.Replication
+	if info.Backlog == nil {
+		info.Backlog = NewReplicationBacklog(info.ReplBacklogSize, info.MasterReplOffset)
+	}
+}
+
+// snapshotAtomically waits until no other snapshot is in progress, then freezes
+// the database and runs during under the write lock, so nothing written after
+// the snapshot can be missed by during.
+func (rs *RedisServer) snapshotAtomically(ctx context.Context, during func() error) (*DatabaseSnapshot, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-rs.Database.SnapshotReleased():
+		}
+
+		rs.writeLock.Lock()
+		snapshot, err := rs.Database.Snapshot()
+		if err != nil {
+			rs.writeLock.Unlock()
+			continue
+		}
+
+		err = during()
+		rs.writeLock.Unlock()
+		if err != nil {
+			snapshot.Release()
+			return nil, err
+		}
+
+		return snapshot, nil
+	}
+}
+
+// startFullSync registers the replicant at the point the snapshot is taken, so
+// the snapshot, the replication offset and the commands buffered for the
+// replicant line up.
+func (rs *RedisServer) startFullSync(ctx context.Context, replicant *ReplicantConnection) (*DatabaseSnapshot, int, error) {
+	offset := 0
+	snapshot, err := rs.snapshotAtomically(ctx, func() error {
+		rs.createBacklog()
+		rs.ServerInfo.Replication.Replicants.Add(replicant)
+		offset = rs.ServerInfo.Replication.MasterReplOffset
+		return nil
+	})
+
+	return snapshot, offset, err
+}
+
+// FullSync sends the replicant an RDB of the current dataset followed by every
+// write that happened while it was being generated.
+func (rs *RedisServer) FullSync(ctx context.Context, replicant *ReplicantConnection) error {
+	rs.ServerInfo.Replication.Acquire()
+	diskless := rs.ServerInfo.Replication.DisklessSync
+	rs.ServerInfo.Replication.Release()
+
+	if diskless && replicant.conn.replCapaEOF {
+		return rs.disklessFullSync(ctx, replicant)
+	}
+
+	snapshot, offset, err := rs.startFullSync(ctx, replicant)
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("failed to start full sync: %v", err)
 	}
 
-	return len([]byte(str)), nil
+	err = rs.fullSync(replicant, snapshot, offset)
+	if err != nil {
+		rs.ServerInfo.Replication.Replicants.Remove(replicant)
+		return fmt.Errorf("failed to run full sync: %v", err)
+	}
+
+	return nil
 }
 
+func (rs *RedisServer) fullSync(replicant *ReplicantConnection, snapshot *DatabaseSnapshot, offset int) error {
+	fullResync := RESPValue{Type: SimpleString, Value: fmt.Sprintf("FULLRESYNC %s %d", rs.ServerInfo.Replication.MasterReplid, offset)}
+	err := replicant.conn.Conn.RespondRESP(fullResync)
+	if err != nil {
+		snapshot.Release()
+		return err
+	}
+
+	file, err := rs.saveSnapshotForSync(snapshot)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	replicant.SetSendingBulk()
+	err = replicant.conn.Conn.RespondRDB(file, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	return replicant.FinishSync()
+}
+
+// continuation returns the part of the replication stream a replica is missing
+// when it can continue from offset in the history named by replid.
+func (rs *RedisServer) continuation(replid string, offset int) (string, bool) {
+	info := rs.ServerInfo.Replication
+	if info.Backlog == nil {
+		return "", false
+	}
+
+	if replid != info.MasterReplid && (replid != info.MasterReplid2 || offset > info.SecondReplOffset) {
+		return "", false
+	}
+
+	return info.Backlog.Since(offset)
+}
+
+// PartialSync continues replicating to a replicant from offset using the
+// backlog. It returns false when the replicant needs a full sync instead.
+func (rs *RedisServer) PartialSync(replicant *ReplicantConnection, replid string, offset int) (bool, error) {
+	rs.writeLock.Lock()
+	missing, ok := rs.continuation(replid, offset)
+	if ok {
+		rs.ServerInfo.Replication.Replicants.Add(replicant)
+	}
+	rs.writeLock.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	err := rs.partialSync(replicant, missing)
+	if err != nil {
+		rs.ServerInfo.Replication.Replicants.Remove(replicant)
+		return true, fmt.Errorf("failed to run partial sync: %v", err)
+	}
+
+	return true, nil
+}
+
+func (rs *RedisServer) partialSync(replicant *ReplicantConnection, missing string) error {
+	err := replicant.conn.Conn.RespondRESP(RESPValue{Type: SimpleString, Value: "CONTINUE " + rs.ServerInfo.Replication.MasterReplid})
+	if err != nil {
+		return err
+	}
+
+	err = replicant.conn.Conn.Respond(missing)
+	if err != nil {
+		return err
+	}
+
+	return replicant.FinishSync()
+}
+
+// ProcessBytes advances the replication offset past resp and records it in
+// the backlog.
 func (rs *RedisServer) ProcessBytes(resp RESPValue) error {
-	bytes, err := GetBytes(resp)
+	str, err := resp.ToString()
 	if err != nil {
 		return err
 	}
 
-	rs.ServerInfo.Replication.MasterReplOffset += bytes
+	if rs.ServerInfo.Replication.Backlog != nil {
+		rs.ServerInfo.Replication.Backlog.Feed(str)
+	}
+	rs.ServerInfo.Replication.MasterReplOffset += len(str)
 
 	return nil
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+// waitForListening waits until something accepts connections on port.
+func waitForListening(t *testing.T, port string) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("server never started listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startTestServer runs a server on a free port until the test ends, after
+// applying config as pairs of names and values, and returns its port.
+func startTestServer(t *testing.T, replicaOf string, config ...string) string {
+	t.Helper()
+
+	_, port := runTestServer(t, replicaOf, config...)
+	return port
+}
+
+// runTestServer is startTestServer for tests that also look at the server.
+func runTestServer(t *testing.T, replicaOf string, config ...string) (*RedisServer, string) {
+	t.Helper()
+
+	return runTestServerIn(t, t.TempDir(), replicaOf, config...)
+}
+
+// runTestServerIn is runTestServer keeping its files in dir, for tests that
+// restart a server on the files of another.
+func runTestServerIn(t *testing.T, dir string, replicaOf string, config ...string) (*RedisServer, string) {
+	t.Helper()
+
+	port := freePort(t)
+	rs, err := NewRedisServer(port, replicaOf, dir, "dump.rdb")
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	for i := 0; i+1 < len(config); i += 2 {
+		err = rs.ConfigSet(config[i], config[i+1])
+		if err != nil {
+			t.Fatalf("failed to set %s: %v", config[i], err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go rs.Run(ctx)
+
+	waitForListening(t, port)
+	return rs, port
+}
+
+type testClient struct {
+	t    *testing.T
+	conn *RESPConnection
+}
+
+func newTestClient(t *testing.T, port string) *testClient {
+	t.Helper()
+
+	tcp, err := DialTCPConnection("127.0.0.1:" + port)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	conn := NewRESPConnection(tcp)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+// pipeline sends every command before reading any of their replies.
+func (c *testClient) pipeline(commands ...[]string) []RESPValue {
+	c.t.Helper()
+
+	for _, args := range commands {
+		err := c.conn.RespondRESP(CommandRESP(args...))
+		if err != nil {
+			c.t.Fatalf("%s failed: %v", strings.Join(args, " "), err)
+		}
+	}
+
+	replies := []RESPValue{}
+	for range commands {
+		resp, err := c.receive()
+		if err != nil {
+			c.t.Fatalf("failed to read reply: %v", err)
+		}
+		replies = append(replies, resp)
+	}
+
+	return replies
+}
+
+// try sends a command and reads its reply, for goroutines other than the
+// test's own, which must not fail the test directly.
+func (c *testClient) try(args ...string) (RESPValue, error) {
+	err := c.conn.RespondRESP(CommandRESP(args...))
+	if err != nil {
+		return RESPValue{}, err
+	}
+
+	return c.receive()
+}
+
+func (c *testClient) receive() (RESPValue, error) {
+	c.conn.SetDeadline(time.Now().Add(testTimeout))
+	return c.conn.NextRESP(context.Background())
+}
+
+func (c *testClient) do(args ...string) RESPValue {
+	c.t.Helper()
+
+	resp, err := c.try(args...)
+	if err != nil {
+		c.t.Fatalf("%s failed: %v", strings.Join(args, " "), err)
+	}
+
+	return resp
+}
+
+// waitFor polls until done reports true, failing the test after testTimeout.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func isError(resp RESPValue, prefix string) bool {
+	message, ok := resp.Value.(string)
+	return resp.Type == SimpleError && ok && strings.HasPrefix(message, prefix+" ")
+}
+
+func isBulk(resp RESPValue, value string) bool {
+	return resp.Type == BulkString && resp.Value == value
+}
+
+// expectShutdown checks SHUTDOWN with args closes the connection, and that
+// the server stops listening.
+func expectShutdown(t *testing.T, port string, client *testClient, args ...string) {
+	t.Helper()
+
+	if resp, err := client.try(append([]string{"SHUTDOWN"}, args...)...); err == nil {
+		t.Fatalf("SHUTDOWN %v returned %v", args, resp)
+	}
+
+	waitFor(t, "the server to stop listening", func() bool {
+		conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	})
+}
+
+func TestShutdownSavesUnlessNosave(t *testing.T) {
+	rs, port := runTestServer(t, "")
+	client := newTestClient(t, port)
+	client.do("SET", "key", "1")
+	if resp := client.do("SHUTDOWN", "NOW"); !isError(resp, "ERR") {
+		t.Fatalf("SHUTDOWN NOW returned %v", resp)
+	}
+	expectShutdown(t, port, client, "SAVE")
+	if !rdbSaved(rs) {
+		t.Fatalf("SHUTDOWN SAVE did not save the RDB file")
+	}
+
+	rs, port = runTestServer(t, "", "save", "3600 1")
+	client = newTestClient(t, port)
+	client.do("SET", "key", "1")
+	expectShutdown(t, port, client, "NOSAVE")
+	if rdbSaved(rs) {
+		t.Fatalf("SHUTDOWN NOSAVE saved the RDB file")
+	}
+
+	// The append only file is kept whether or not the RDB file is saved.
+	dir := t.TempDir()
+	_, port = runTestServerIn(t, dir, "", "appendonly", "yes")
+	client = newTestClient(t, port)
+	client.do("SET", "logged", "1")
+	expectShutdown(t, port, client)
+
+	_, port = runTestServerIn(t, dir, "", "appendonly", "yes")
+	if resp := newTestClient(t, port).do("GET", "logged"); !isBulk(resp, "1") {
+		t.Fatalf("GET after restarting on the append only file returned %v", resp)
+	}
+}
+
+func TestShutdownNosaveStopsBusyScript(t *testing.T) {
+	rs, port := runTestServer(t, "", "busy-reply-threshold", "50", "save", "3600 1")
+
+	script := newTestClient(t, port)
+	err := script.conn.RespondRESP(CommandRESP("EVAL", "redis.call('SET', 'key', '1') while true do end", "0"))
+	if err != nil {
+		t.Fatalf("EVAL failed: %v", err)
+	}
+
+	client := newTestClient(t, port)
+	waitFor(t, "the script to be busy", func() bool {
+		return isError(client.do("PING"), "BUSY")
+	})
+	for _, args := range [][]string{{"SHUTDOWN"}, {"SHUTDOWN", "SAVE"}} {
+		if resp := client.do(args...); !isError(resp, "BUSY") {
+			t.Fatalf("%v while a script is busy returned %v", args, resp)
+		}
+	}
+	if resp := client.do("SCRIPT", "KILL"); !isError(resp, "UNKILLABLE") {
+		t.Fatalf("SCRIPT KILL of a script that wrote returned %v", resp)
+	}
+
+	expectShutdown(t, port, client, "NOSAVE")
+	waitFor(t, "the script to stop", func() bool {
+		_, _, running := rs.RunningScript.Running(false)
+		return !running
+	})
+	if rdbSaved(rs) {
+		t.Fatalf("SHUTDOWN NOSAVE saved the RDB file")
+	}
+}
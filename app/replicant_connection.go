@@ -2,38 +2,184 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
 )
 
+// States of a replicant as listed in INFO replication: it waits for the
+// snapshot it will be sent, receives it, and is online once it streams writes.
+const (
+	replicantStateWaitBgsave = "wait_bgsave"
+	replicantStateSendBulk   = "send_bulk"
+	replicantStateOnline     = "online"
+)
+
+// ReplicantConnection is the master's side of a replica. While the replica is
+// receiving its initial RDB, propagated commands are held in pending and sent
+// once the transfer is done.
 type ReplicantConnection struct {
-	conn *RedisConnection
+	conn      *RedisConnection
+	ip        string
+	port      string
+	state     string
+	pending   []RESPValue
+	ackOffset int
+	ackTime   time.Time
+	// streamOnAck holds back the writes buffered during a diskless sync until
+	// the replica acknowledges having loaded the RDB, since the transfer's end
+	// can only be detected when nothing follows it.
+	streamOnAck bool
+	lock        sync.Mutex
 }
 
-func (rc *ReplicantConnection) ProcessedThresh(thresh int) bool {
-	maxProcessed := 0
-	for len(rc.conn.Processed) > 0 {
-		processed := <-rc.conn.Processed
-		maxProcessed = Max(maxProcessed, processed)
+func NewReplicantConnection(conn *RedisConnection) *ReplicantConnection {
+	return &ReplicantConnection{
+		conn:    conn,
+		ip:      conn.Conn.RemoteIP(),
+		port:    conn.replPort,
+		state:   replicantStateWaitBgsave,
+		pending: []RESPValue{},
+		ackTime: time.Now(),
+	}
+}
+
+func (rc *ReplicantConnection) Send(resp RESPValue) error {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.state != replicantStateOnline {
+		rc.pending = append(rc.pending, resp)
+		return nil
 	}
 
-	return maxProcessed >= thresh
+	return rc.conn.Conn.RespondRESP(resp)
 }
 
-func (rc *ReplicantConnection) sendAcknowledgement() error {
-	err := rc.conn.Conn.RespondRESP(RESPValue{Array, []RESPValue{{BulkString, "REPLCONF"}, {BulkString, "GETACK"}, {BulkString, "*"}}})
+// FinishSync flushes the commands buffered during the initial sync and
+// starts streaming new ones directly.
+func (rc *ReplicantConnection) FinishSync() error {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	err := rc.conn.Conn.RespondRESPValues(rc.pending)
 	if err != nil {
 		return err
 	}
 
+	rc.pending = []RESPValue{}
+	rc.state = replicantStateOnline
+
 	return nil
 }
 
-func (rc *ReplicantConnection) WaitUntilConsistent(ctx context.Context, done chan bool, processedThresh int) {
-	for {
-		err := rc.sendAcknowledgement()
+// Acknowledge records the offset the replicant reported having processed.
+func (rc *ReplicantConnection) Acknowledge(offset int) {
+	rc.lock.Lock()
+	rc.ackOffset = offset
+	rc.ackTime = time.Now()
+	streamOnAck := rc.streamOnAck
+	rc.streamOnAck = false
+	rc.lock.Unlock()
+
+	if streamOnAck {
+		err := rc.FinishSync()
 		if err != nil {
-			return
+			rc.Close()
 		}
+	}
+
+	rc.conn.reportProcessed(offset)
+}
+
+// StreamOnAck finishes the sync once the replicant first acknowledges.
+func (rc *ReplicantConnection) StreamOnAck() {
+	rc.lock.Lock()
+	rc.streamOnAck = true
+	rc.lock.Unlock()
+}
+
+// Lag returns how long ago the replicant last acknowledged, or false while it
+// is still receiving its initial sync.
+func (rc *ReplicantConnection) Lag() (time.Duration, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if rc.state != replicantStateOnline {
+		return 0, false
+	}
+
+	return time.Since(rc.ackTime), true
+}
+
+func (rc *ReplicantConnection) Online() bool {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	return rc.state == replicantStateOnline
+}
 
+// CaughtUp reports whether the replicant is online and acknowledged offset.
+func (rc *ReplicantConnection) CaughtUp(offset int) bool {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	return rc.state == replicantStateOnline && rc.ackOffset >= offset
+}
+
+// Is reports whether the replicant listens at host and port, resolving host
+// when it is a name rather than the replicant's IP.
+func (rc *ReplicantConnection) Is(host string, port string) bool {
+	if rc.port == "" || rc.port != port {
+		return false
+	}
+
+	if host == rc.ip {
+		return true
+	}
+
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return false
+	}
+
+	return slices.Contains(addrs, rc.ip)
+}
+
+func (rc *ReplicantConnection) SetSendingBulk() {
+	rc.lock.Lock()
+	rc.state = replicantStateSendBulk
+	rc.lock.Unlock()
+}
+
+func (rc *ReplicantConnection) ToString() string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	lag := 0
+	if rc.state == replicantStateOnline {
+		lag = int(time.Since(rc.ackTime).Seconds())
+	}
+
+	return fmt.Sprintf("ip=%s,port=%s,state=%s,offset=%d,lag=%d", rc.ip, rc.port, rc.state, rc.ackOffset, lag)
+}
+
+func (rc *ReplicantConnection) ProcessedThresh(thresh int) bool {
+	maxProcessed := 0
+	for len(rc.conn.Processed) > 0 {
+		processed := <-rc.conn.Processed
+		maxProcessed = Max(maxProcessed, processed)
+	}
+
+	return maxProcessed >= thresh
+}
+
+// WaitUntilConsistent reports on done once the replicant acknowledges having
+// processed processedThresh bytes.
+func (rc *ReplicantConnection) WaitUntilConsistent(ctx context.Context, done chan bool, processedThresh int) {
+	for {
 		select {
 		case <-ctx.Done():
 			return
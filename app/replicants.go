@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,8 +13,8 @@ type Replicants struct {
 	lock        sync.Mutex
 }
 
-func NewReplicants() Replicants {
-	return Replicants{connections: []*ReplicantConnection{}, lock: sync.Mutex{}}
+func NewReplicants() *Replicants {
+	return &Replicants{connections: []*ReplicantConnection{}, lock: sync.Mutex{}}
 }
 
 func (r *Replicants) Add(c *ReplicantConnection) {
@@ -21,12 +23,36 @@ func (r *Replicants) Add(c *ReplicantConnection) {
 	r.lock.Unlock()
 }
 
+func (r *Replicants) Remove(c *ReplicantConnection) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, replicant := range r.connections {
+		if replicant == c {
+			r.connections = append(r.connections[:i], r.connections[i+1:]...)
+			return
+		}
+	}
+}
+
+// DisconnectAll closes the connection to every replicant, making them
+// reconnect and sync again.
+func (r *Replicants) DisconnectAll() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, replicant := range r.connections {
+		replicant.Close()
+	}
+	r.connections = []*ReplicantConnection{}
+}
+
 func (r *Replicants) Propogate(resp RESPValue) {
 	r.lock.Lock()
 
 	rem := []*ReplicantConnection{}
 	for _, replicant := range r.connections {
-		err := replicant.conn.Conn.RespondRESP(resp)
+		err := replicant.Send(resp)
 		if err != nil {
 			continue
 		}
@@ -47,7 +73,7 @@ func (r *Replicants) cloneConnections() []*ReplicantConnection {
 	return connections
 }
 
-func (r *Replicants) WaitForConsistency(ctx context.Context, replicantsNeeded int, timeout time.Duration, bytesNeeded int) int {
+func (r *Replicants) WaitForConsistency(ctx context.Context, replicantsNeeded int, timeout time.Duration, bytesNeeded int, requestAck func()) int {
 	if timeout.Milliseconds() > 0 {
 		c, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
@@ -66,6 +92,10 @@ func (r *Replicants) WaitForConsistency(ctx context.Context, replicantsNeeded in
 		}
 	}
 
+	if synced < replicantsNeeded {
+		requestAck()
+	}
+
 	for synced < replicantsNeeded {
 		select {
 		case <-ctx.Done():
@@ -78,6 +108,59 @@ func (r *Replicants) WaitForConsistency(ctx context.Context, replicantsNeeded in
 	return synced
 }
 
+// GoodCount returns how many replicants are online and acknowledged within
+// maxLag.
+func (r *Replicants) GoodCount(maxLag time.Duration) int {
+	good := 0
+	for _, replicant := range r.cloneConnections() {
+		lag, online := replicant.Lag()
+		if online && lag <= maxLag {
+			good += 1
+		}
+	}
+
+	return good
+}
+
+func (r *Replicants) Find(host string, port string) (*ReplicantConnection, bool) {
+	for _, replicant := range r.cloneConnections() {
+		if replicant.Is(host, port) {
+			return replicant, true
+		}
+	}
+
+	return nil, false
+}
+
+// CaughtUp returns a replicant that acknowledged offset, limited to the one at
+// host and port unless host is empty.
+func (r *Replicants) CaughtUp(host string, port string, offset int) (*ReplicantConnection, bool) {
+	for _, replicant := range r.cloneConnections() {
+		if host != "" && !replicant.Is(host, port) {
+			continue
+		}
+
+		if replicant.port != "" && replicant.CaughtUp(offset) {
+			return replicant, true
+		}
+	}
+
+	return nil, false
+}
+
+// ToString lists every replicant as a slaveN line of INFO replication.
+func (r *Replicants) ToString() string {
+	sb := strings.Builder{}
+	for i, replicant := range r.cloneConnections() {
+		WriteLine(&sb, fmt.Sprintf("slave%d:%s\n", i, replicant.ToString()))
+	}
+
+	return sb.String()
+}
+
 func (r *Replicants) Size() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	return len(r.connections)
 }
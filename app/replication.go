@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	minReconnectDelay = 250 * time.Millisecond
+	maxReconnectDelay = 8 * time.Second
+	ackInterval       = time.Second
+)
+
+// syncWithMaster connects to the master, syncs with it and then applies the
+// writes it streams until the link is lost. It reports whether the link got
+// as far as being connected.
+func (rs *RedisServer) syncWithMaster(ctx context.Context) (bool, error) {
+	link := rs.ServerInfo.Replication.MasterLink
+	defer link.SetState(replStateConnect)
+
+	link.SetState(replStateConnecting)
+	masterTCP, err := DialTCPConnection(net.JoinHostPort(rs.ServerInfo.Replication.Master()))
+	if err != nil {
+		rs.ServerInfo.Replication.Failover.Report(err)
+		return false, fmt.Errorf("error dialing connection: %v", err)
+	}
+
+	master := &MasterConnection{NewRedisConnection(NewRESPConnection(masterTCP), rs)}
+	defer master.Close()
+	stop := context.AfterFunc(ctx, func() { master.Close() })
+	defer stop()
+
+	err = master.Handshake(ctx)
+	rs.ServerInfo.Replication.Failover.Report(err)
+	if err != nil {
+		return false, fmt.Errorf("failed to run handshake: %v", err)
+	}
+
+	link.SetState(replStateConnected)
+	acks, stopAcks := context.WithCancel(ctx)
+	defer stopAcks()
+	go rs.acknowledgeMaster(acks, master)
+
+	err = master.HandleMaster(ctx)
+	if err != nil && err != io.EOF {
+		return true, fmt.Errorf("failed to handle master requests: %v", err)
+	}
+
+	return true, nil
+}
+
+// acknowledgeMaster reports the replication offset to the master as soon as
+// the link is up and then every second, which it uses to know which replicas
+// are up to date.
+func (rs *RedisServer) acknowledgeMaster(ctx context.Context, master *MasterConnection) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+
+	for {
+		rs.writeLock.RLock()
+		offset := rs.ServerInfo.Replication.MasterReplOffset
+		rs.writeLock.RUnlock()
+
+		err := master.conn.Conn.RespondRESP(CommandRESP("REPLCONF", "ACK", strconv.Itoa(offset)))
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnoughGoodReplicas reports whether min-replicas-to-write is met, counting
+// replicas that acknowledged within min-replicas-max-lag seconds.
+func (rs *RedisServer) EnoughGoodReplicas() bool {
+	info := rs.ServerInfo.Replication
+	info.Acquire()
+	minReplicas, minReplicasLag := info.MinReplicas, info.MinReplicasLag
+	info.Release()
+
+	if minReplicas <= 0 || minReplicasLag <= 0 {
+		return true
+	}
+
+	return info.Replicants.GoodCount(time.Duration(minReplicasLag)*time.Second) >= minReplicas
+}
+
+// replicate keeps the replica synced with its master, reconnecting with an
+// exponential backoff whenever the link is lost.
+func (rs *RedisServer) replicate(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		connected, err := rs.syncWithMaster(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("lost connection to master: %v\n", err)
+		}
+
+		if connected {
+			delay = minReconnectDelay
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if !connected {
+			delay = time.Duration(Min(int(delay*2), int(maxReconnectDelay)))
+		}
+	}
+}
+
+// startReplication starts following the configured master in the background.
+// stopReplication disconnects from it and waits until nothing more is applied.
+func (rs *RedisServer) startReplication(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	rs.stopReplication = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		rs.replicate(ctx)
+		close(done)
+	}()
+}
+
+// ReplicaOf makes the server a replica of the master at host and port. A
+// master keeps its own history cached, so the new master can continue from it
+// when they share one.
+func (rs *RedisServer) ReplicaOf(ctx context.Context, host string, port string) bool {
+	rs.replicationLock.Lock()
+	defer rs.replicationLock.Unlock()
+
+	info := rs.ServerInfo.Replication
+	masterHost, masterPort := info.Master()
+	if info.Role() == "slave" && masterHost == host && masterPort == port {
+		return false
+	}
+
+	if rs.stopReplication != nil {
+		rs.stopReplication()
+	}
+
+	rs.writeLock.Lock()
+	if info.Role() == "master" {
+		rs.createBacklog()
+		info.MasterSynced = true
+	}
+	info.SetRole("slave", host, port)
+	rs.writeLock.Unlock()
+
+	rs.startReplication(ctx)
+	return true
+}
+
+// PromoteToMaster stops replicating and starts a new replication history,
+// keeping the old one as the second so replicas that followed it can still
+// continue partially.
+func (rs *RedisServer) PromoteToMaster() {
+	rs.replicationLock.Lock()
+	defer rs.replicationLock.Unlock()
+
+	info := rs.ServerInfo.Replication
+	if info.Role() == "master" {
+		return
+	}
+
+	rs.stopReplication()
+	rs.stopReplication = nil
+
+	rs.writeLock.Lock()
+	rs.createBacklog()
+	info.MasterReplid2 = info.MasterReplid
+	info.SecondReplOffset = info.MasterReplOffset + 1
+	info.MasterReplid = NewReplicationID()
+	info.SetRole("master", "", info.Port)
+	rs.writeLock.Unlock()
+}
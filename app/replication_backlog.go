@@ -0,0 +1,119 @@
+package main
+
+import (
+	"sync"
+)
+
+const (
+	defaultReplBacklogSize = 1024 * 1024
+	minReplBacklogSize     = 16 * 1024
+)
+
+// ReplicationBacklog is a circular buffer holding the most recent bytes of the
+// replication stream, so a replica that briefly lost its connection can
+// continue from its offset instead of doing a full resync. Offsets follow the
+// replication offset: the byte at offset n is the nth byte ever propagated.
+type ReplicationBacklog struct {
+	buffer          []byte
+	next            int
+	histlen         int
+	firstByteOffset int
+	lock            sync.Mutex
+}
+
+// NewReplicationBacklog creates a backlog whose next byte will be the one
+// following offset.
+func NewReplicationBacklog(size int, offset int) *ReplicationBacklog {
+	return &ReplicationBacklog{buffer: make([]byte, size), next: 0, histlen: 0, firstByteOffset: offset + 1}
+}
+
+func (rb *ReplicationBacklog) feed(data string) {
+	size := len(rb.buffer)
+	if len(data) > size {
+		rb.firstByteOffset += rb.histlen + len(data) - size
+		data = data[len(data)-size:]
+		rb.histlen = 0
+	}
+
+	for len(data) > 0 {
+		n := copy(rb.buffer[rb.next:], data)
+		data = data[n:]
+		rb.next = (rb.next + n) % size
+		rb.histlen += n
+	}
+
+	if rb.histlen > size {
+		rb.firstByteOffset += rb.histlen - size
+		rb.histlen = size
+	}
+}
+
+func (rb *ReplicationBacklog) Feed(data string) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	rb.feed(data)
+}
+
+func (rb *ReplicationBacklog) since(offset int) (string, bool) {
+	if offset < rb.firstByteOffset || offset > rb.firstByteOffset+rb.histlen {
+		return "", false
+	}
+
+	skip := offset - rb.firstByteOffset
+	count := rb.histlen - skip
+	start := (rb.next - count + len(rb.buffer)) % len(rb.buffer)
+
+	data := make([]byte, 0, count)
+	if start+count <= len(rb.buffer) {
+		data = append(data, rb.buffer[start:start+count]...)
+	} else {
+		data = append(data, rb.buffer[start:]...)
+		data = append(data, rb.buffer[:count-(len(rb.buffer)-start)]...)
+	}
+
+	return string(data), true
+}
+
+// Since returns every byte from offset onwards, or false when offset is not
+// covered by the backlog.
+func (rb *ReplicationBacklog) Since(offset int) (string, bool) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return rb.since(offset)
+}
+
+// Resize changes the capacity of the backlog, keeping as much of the most
+// recent history as fits.
+func (rb *ReplicationBacklog) Resize(size int) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	history, _ := rb.since(rb.firstByteOffset)
+	rb.buffer = make([]byte, size)
+	rb.next = 0
+	rb.histlen = 0
+	rb.feed(history)
+}
+
+func (rb *ReplicationBacklog) Size() int {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return len(rb.buffer)
+}
+
+func (rb *ReplicationBacklog) FirstByteOffset() int {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return rb.firstByteOffset
+}
+
+func (rb *ReplicationBacklog) Histlen() int {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+
+	return rb.histlen
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplicationBacklogWrapsAround(t *testing.T) {
+	backlog := NewReplicationBacklog(10, 99)
+	stream := ""
+	for _, data := range []string{"abcd", "efgh", "ijkl", "mnopqrstuvwxyz", "0123"} {
+		backlog.Feed(data)
+		stream += data
+
+		histlen := min(len(stream), 10)
+		if backlog.Histlen() != histlen || backlog.FirstByteOffset() != 100+len(stream)-histlen {
+			t.Fatalf("after %q backlog holds %d bytes from %d", stream, backlog.Histlen(), backlog.FirstByteOffset())
+		}
+	}
+
+	// The stream starts at offset 100, so the backlog holds its last 10 bytes.
+	first := 100 + len(stream) - 10
+	for offset := first; offset <= first+10; offset++ {
+		missing, ok := backlog.Since(offset)
+		if want := stream[offset-100:]; !ok || missing != want {
+			t.Fatalf("Since(%d) returned %q, %v, want %q", offset, missing, ok, want)
+		}
+	}
+
+	for _, offset := range []int{first - 1, first + 11} {
+		if _, ok := backlog.Since(offset); ok {
+			t.Fatalf("Since(%d) is outside the backlog but was covered", offset)
+		}
+	}
+}
+
+func TestReplicationBacklogResize(t *testing.T) {
+	backlog := NewReplicationBacklog(8, 0)
+	backlog.Feed("abcdefghijkl")
+
+	backlog.Resize(16)
+	backlog.Feed("mnop")
+	if missing, ok := backlog.Since(5); !ok || missing != "efghijklmnop" {
+		t.Fatalf("growing the backlog kept %q, %v", missing, ok)
+	}
+
+	backlog.Resize(4)
+	if missing, ok := backlog.Since(backlog.FirstByteOffset()); !ok || missing != "mnop" || backlog.FirstByteOffset() != 13 {
+		t.Fatalf("shrinking the backlog kept %q from %d", missing, backlog.FirstByteOffset())
+	}
+
+	backlog.Feed(strings.Repeat("z", 9))
+	if missing, ok := backlog.Since(22); !ok || missing != "zzzz" {
+		t.Fatalf("feeding more than the backlog holds kept %q, %v", missing, ok)
+	}
+}
@@ -1,36 +1,130 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
+const noReplicationID = "0000000000000000000000000000000000000000"
+
+// NewReplicationID returns a random 40 character ID naming a replication
+// history.
+func NewReplicationID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 type ReplicationInfo struct {
-	Role                       string
-	Port                       string
-	Replicants                 Replicants
-	MasterPort                 string
-	MasterReplid               string
-	MasterReplOffset           int
-	SecondReplOffset           int
-	ReplBacklogActive          int
-	ReplBacklogSize            int
-	ReplBacklogFirstByteOffset int
-	ReplBacklogHistlen         int
+	role             string
+	Port             string
+	Replicants       *Replicants
+	masterHost       string
+	masterPort       string
+	MasterLink       *MasterLink
+	MasterReplid     string
+	MasterReplid2    string
+	MasterReplOffset int
+	SecondReplOffset int
+	MasterSynced     bool
+	ReadOnly         bool
+	ServeStaleData   bool
+	MinReplicas      int
+	MinReplicasLag   int
+	ReplicaPriority  int
+	DisklessSync     bool
+	DisklessDelay    int
+	DisklessLoad     string
+	ReplBacklogSize  int
+	Backlog          *ReplicationBacklog
+	Failover         *Failover
+	lock             sync.RWMutex
+}
+
+// Acquire guards the settings changed with CONFIG SET, and the role.
+func (info *ReplicationInfo) Acquire() {
+	info.lock.Lock()
+}
+
+func (info *ReplicationInfo) Release() {
+	info.lock.Unlock()
+}
+
+// Role returns "master", or "slave" while the server replicates the master
+// returned by Master. Both change together, with SetRole, when the server is
+// promoted or made a replica.
+func (info *ReplicationInfo) Role() string {
+	info.lock.RLock()
+	defer info.lock.RUnlock()
+
+	return info.role
+}
+
+func (info *ReplicationInfo) Master() (string, string) {
+	info.lock.RLock()
+	defer info.lock.RUnlock()
+
+	return info.masterHost, info.masterPort
+}
+
+func (info *ReplicationInfo) SetRole(role string, masterHost string, masterPort string) {
+	info.lock.Lock()
+	defer info.lock.Unlock()
+
+	info.role = role
+	info.masterHost, info.masterPort = masterHost, masterPort
 }
 
 func (info *ReplicationInfo) ToString() string {
 	sb := strings.Builder{}
 	WriteLine(&sb, "# Replication")
-	WriteLine(&sb, fmt.Sprintf("role:%s\n", info.Role))
+	role := info.Role()
+	info.Acquire()
+	minReplicas, minReplicasLag, priority := info.MinReplicas, info.MinReplicasLag, info.ReplicaPriority
+	info.Release()
+
+	WriteLine(&sb, fmt.Sprintf("role:%s\n", role))
+	if role == "slave" {
+		masterHost, masterPort := info.Master()
+		linkStatus := "down"
+		if info.MasterLink.State() == replStateConnected {
+			linkStatus = "up"
+		}
+
+		WriteLine(&sb, fmt.Sprintf("master_host:%s\n", masterHost))
+		WriteLine(&sb, fmt.Sprintf("master_port:%s\n", masterPort))
+		WriteLine(&sb, fmt.Sprintf("master_link_status:%s\n", linkStatus))
+		WriteLine(&sb, fmt.Sprintf("master_last_io_seconds_ago:%d\n", info.MasterLink.LastIOSecondsAgo()))
+		WriteLine(&sb, fmt.Sprintf("master_sync_in_progress:%d\n", boolToInt(info.MasterLink.State() == replStateTransfer)))
+		WriteLine(&sb, fmt.Sprintf("slave_repl_offset:%d\n", info.MasterReplOffset))
+		WriteLine(&sb, fmt.Sprintf("slave_priority:%d\n", priority))
+		if linkStatus == "down" {
+			WriteLine(&sb, fmt.Sprintf("master_link_down_since_seconds:%d\n", info.MasterLink.DownSinceSeconds()))
+		}
+	}
 	WriteLine(&sb, fmt.Sprintf("connected_slaves:%d\n", info.Replicants.Size()))
+	sb.WriteString(info.Replicants.ToString())
+	if minReplicas > 0 && minReplicasLag > 0 {
+		WriteLine(&sb, fmt.Sprintf("min_slaves_good_slaves:%d\n", info.Replicants.GoodCount(time.Duration(minReplicasLag)*time.Second)))
+	}
+	WriteLine(&sb, fmt.Sprintf("master_failover_state:%s\n", info.Failover.State()))
 	WriteLine(&sb, fmt.Sprintf("master_replid:%s\n", info.MasterReplid))
+	WriteLine(&sb, fmt.Sprintf("master_replid2:%s\n", info.MasterReplid2))
 	WriteLine(&sb, fmt.Sprintf("master_repl_offset:%d\n", info.MasterReplOffset))
 	WriteLine(&sb, fmt.Sprintf("second_repl_offset:%d\n", info.SecondReplOffset))
-	WriteLine(&sb, fmt.Sprintf("repl_backlog_active:%d\n", info.ReplBacklogActive))
+
+	firstByteOffset, histlen := 0, 0
+	if info.Backlog != nil {
+		firstByteOffset, histlen = info.Backlog.FirstByteOffset(), info.Backlog.Histlen()
+	}
+	WriteLine(&sb, fmt.Sprintf("repl_backlog_active:%d\n", boolToInt(info.Backlog != nil)))
 	WriteLine(&sb, fmt.Sprintf("repl_backlog_size:%d\n", info.ReplBacklogSize))
-	WriteLine(&sb, fmt.Sprintf("repl_backlog_first_byte_offset:%d\n", info.ReplBacklogFirstByteOffset))
-	WriteLine(&sb, fmt.Sprintf("ReplBack:%d\n", info.MasterReplOffset))
+	WriteLine(&sb, fmt.Sprintf("repl_backlog_first_byte_offset:%d\n", firstByteOffset))
+	WriteLine(&sb, fmt.Sprintf("repl_backlog_histlen:%d\n", histlen))
 
 	return sb.String()
 }
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReplicaOfWhileServingClients(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	port := startTestServer(t, "")
+
+	master := newTestClient(t, masterPort)
+	if resp := master.do("SET", "greeting", "hello"); resp.Value != "OK" {
+		t.Fatalf("SET on master returned %v", resp)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		client := newTestClient(t, port)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				for _, args := range [][]string{{"SET", "local", "1"}, {"GET", "greeting"}, {"INFO", "replication"}} {
+					_, err := client.try(args...)
+					if err != nil {
+						t.Errorf("%s failed: %v", args[0], err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	admin := newTestClient(t, port)
+	for i := 0; i < 5; i++ {
+		if resp := admin.do("REPLICAOF", "127.0.0.1", masterPort); resp.Type == SimpleError {
+			t.Fatalf("REPLICAOF returned %v", resp)
+		}
+
+		if resp := admin.do("REPLICAOF", "NO", "ONE"); resp.Type == SimpleError {
+			t.Fatalf("REPLICAOF NO ONE returned %v", resp)
+		}
+	}
+	admin.do("REPLICAOF", "127.0.0.1", masterPort)
+
+	close(stop)
+	wg.Wait()
+
+	info := admin.do("INFO", "replication").Value.(string)
+	if !strings.Contains(info, "role:slave") || !strings.Contains(info, "master_port:"+masterPort) {
+		t.Fatalf("INFO doesn't list the master:\n%s", info)
+	}
+
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(admin.do("GET", "greeting"), "hello")
+	})
+
+	if resp := admin.do("SET", "local", "2"); !isError(resp, "READONLY") {
+		t.Fatalf("SET on replica returned %v", resp)
+	}
+}
+
+// hammer runs commands on a client of port in a loop until the returned stop
+// function is called.
+func hammer(t *testing.T, port string, commands ...[]string) func() {
+	client := newTestClient(t, port)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			for _, args := range commands {
+				_, err := client.try(args...)
+				if err != nil {
+					t.Errorf("%s failed: %v", args[0], err)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func TestReplicaReadOnlyConfig(t *testing.T) {
+	port := startTestServer(t, "127.0.0.1 "+freePort(t))
+
+	admin, client := newTestClient(t, port), newTestClient(t, port)
+	for i := 0; i < 5; i++ {
+		admin.do("CONFIG", "SET", "replica-read-only", "yes")
+		if resp := client.do("SET", "local", "1"); !isError(resp, "READONLY") {
+			t.Fatalf("SET on read only replica returned %v", resp)
+		}
+
+		admin.do("CONFIG", "SET", "replica-read-only", "no")
+		if resp := client.do("SET", "local", "1"); resp.Value != "OK" {
+			t.Fatalf("SET on writable replica returned %v", resp)
+		}
+
+		// The replica's master never answers, so its data is stale.
+		admin.do("CONFIG", "SET", "replica-serve-stale-data", "no")
+		if resp := client.do("GET", "local"); !isError(resp, "MASTERDOWN") {
+			t.Fatalf("GET of stale data returned %v", resp)
+		}
+
+		admin.do("CONFIG", "SET", "replica-serve-stale-data", "yes")
+		if resp := client.do("GET", "local"); !isBulk(resp, "1") {
+			t.Fatalf("GET of served stale data returned %v", resp)
+		}
+	}
+}
+
+func TestMinReplicasConfig(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	startTestServer(t, "127.0.0.1 "+masterPort)
+
+	admin, client := newTestClient(t, masterPort), newTestClient(t, masterPort)
+	admin.do("CONFIG", "SET", "min-replicas-to-write", "1")
+	waitFor(t, "the replica to acknowledge", func() bool {
+		return client.do("SET", "key", "1").Value == "OK"
+	})
+
+	info := client.do("INFO", "replication").Value.(string)
+	if good := infoField(info, "min_slaves_good_slaves"); good != "1" {
+		t.Fatalf("INFO reports %s good replicas, want 1", good)
+	}
+
+	admin.do("CONFIG", "SET", "min-replicas-to-write", "2")
+	if resp := client.do("SET", "key", "2"); !isError(resp, "NOREPLICAS") {
+		t.Fatalf("SET without enough replicas returned %v", resp)
+	}
+
+	admin.do("CONFIG", "SET", "min-replicas-max-lag", "0")
+	if resp := client.do("SET", "key", "2"); resp.Value != "OK" {
+		t.Fatalf("SET without min-replicas-max-lag returned %v", resp)
+	}
+
+	info = client.do("INFO", "replication").Value.(string)
+	if good := infoField(info, "min_slaves_good_slaves"); good != "" {
+		t.Fatalf("INFO reports %s good replicas without min-replicas-max-lag", good)
+	}
+
+	admin.do("CONFIG", "SET", "min-replicas-max-lag", "10")
+	admin.do("CONFIG", "SET", "min-replicas-to-write", "0")
+	if resp := client.do("SET", "key", "3"); resp.Value != "OK" {
+		t.Fatalf("SET without min-replicas-to-write returned %v", resp)
+	}
+}
+
+// rdbSaved reports whether the server has written its RDB file.
+func rdbSaved(rs *RedisServer) bool {
+	dir, dbfilename := rs.rdbPath()
+	_, err := os.Stat(filepath.Join(dir, dbfilename))
+	return err == nil
+}
+
+func TestDisklessSync(t *testing.T) {
+	master, masterPort := runTestServer(t, "", "repl-diskless-sync", "yes", "repl-diskless-sync-delay", "0")
+	newTestClient(t, masterPort).do("SET", "greeting", "hello")
+
+	replica, port := runTestServer(t, "127.0.0.1 "+masterPort, "repl-diskless-load", "swapdb")
+	client := newTestClient(t, port)
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(client.do("GET", "greeting"), "hello")
+	})
+
+	if rdbSaved(master) {
+		t.Fatalf("master wrote an RDB file for a diskless sync")
+	}
+
+	if rdbSaved(replica) {
+		t.Fatalf("replica wrote an RDB file while loading disklessly")
+	}
+}
+
+func TestDiskBasedSync(t *testing.T) {
+	master, masterPort := runTestServer(t, "")
+	newTestClient(t, masterPort).do("SET", "greeting", "hello")
+
+	replica, port := runTestServer(t, "127.0.0.1 "+masterPort)
+	client := newTestClient(t, port)
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(client.do("GET", "greeting"), "hello")
+	})
+
+	if !rdbSaved(master) || !rdbSaved(replica) {
+		t.Fatalf("RDB file written by master: %v, replica: %v", rdbSaved(master), rdbSaved(replica))
+	}
+}
+
+// infoField returns the value of field in an INFO reply.
+func infoField(info string, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		name, val, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok && name == field {
+			return val
+		}
+	}
+
+	return ""
+}
+
+func TestBacklogResizeWhileWritesStream(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	replicaPort := startTestServer(t, "127.0.0.1 "+masterPort)
+
+	master := newTestClient(t, masterPort)
+	replica := newTestClient(t, replicaPort)
+	master.do("SET", "synced", "1")
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(replica.do("GET", "synced"), "1")
+	})
+
+	stop := hammer(t, masterPort, []string{"SET", "streamed", strings.Repeat("x", 1000)})
+	for i := 0; i < 50; i++ {
+		size := []string{"16kb", "1mb"}[i%2]
+		if resp := master.do("CONFIG", "SET", "repl-backlog-size", size); resp.Value != "OK" {
+			t.Fatalf("CONFIG SET returned %v", resp)
+		}
+
+		info := master.do("INFO", "replication").Value.(string)
+		first, _ := strconv.Atoi(infoField(info, "repl_backlog_first_byte_offset"))
+		histlen, _ := strconv.Atoi(infoField(info, "repl_backlog_histlen"))
+		backlogSize, _ := strconv.Atoi(infoField(info, "repl_backlog_size"))
+		offset, _ := strconv.Atoi(infoField(info, "master_repl_offset"))
+		if histlen > backlogSize || first+histlen != offset+1 {
+			t.Fatalf("backlog holds %d bytes from %d in %d, at offset %d", histlen, first, backlogSize, offset)
+		}
+	}
+	stop()
+
+	master.do("SET", "last", "1")
+	waitFor(t, "the replica to catch up", func() bool {
+		return isBulk(replica.do("GET", "last"), "1")
+	})
+}
+
+func TestSubReplicaFollowsResync(t *testing.T) {
+	firstPort := startTestServer(t, "")
+	secondPort := startTestServer(t, "")
+	replicaPort := startTestServer(t, "127.0.0.1 "+firstPort)
+	subReplicaPort := startTestServer(t, "127.0.0.1 "+replicaPort)
+
+	first, second := newTestClient(t, firstPort), newTestClient(t, secondPort)
+	replica, subReplica := newTestClient(t, replicaPort), newTestClient(t, subReplicaPort)
+	first.do("SET", "first", "1")
+	second.do("SET", "second", "1")
+	waitFor(t, "the sub-replica to sync", func() bool {
+		return isBulk(subReplica.do("GET", "first"), "1")
+	})
+
+	stop := hammer(t, replicaPort, []string{"INFO", "replication"})
+	if resp := replica.do("REPLICAOF", "127.0.0.1", secondPort); resp.Type == SimpleError {
+		t.Fatalf("REPLICAOF returned %v", resp)
+	}
+
+	waitFor(t, "the sub-replica to resync", func() bool {
+		return isBulk(subReplica.do("GET", "second"), "1")
+	})
+	stop()
+
+	replicaInfo := replica.do("INFO", "replication").Value.(string)
+	subReplicaInfo := subReplica.do("INFO", "replication").Value.(string)
+	if infoField(subReplicaInfo, "master_replid") != infoField(replicaInfo, "master_replid") {
+		t.Fatalf("sub-replica follows history %s, replica %s", infoField(subReplicaInfo, "master_replid"), infoField(replicaInfo, "master_replid"))
+	}
+
+	if resp := subReplica.do("GET", "first"); resp.Type != NullBulkString {
+		t.Fatalf("sub-replica kept a key of the old master: %v", resp)
+	}
+}
+
+func TestReplicaPriorityInInfo(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	replica := newTestClient(t, startTestServer(t, "127.0.0.1 "+masterPort))
+
+	for _, name := range []string{"replica-priority", "slave-priority"} {
+		for _, priority := range []string{"0", "7", "100"} {
+			replica.do("CONFIG", "SET", name, priority)
+			info := replica.do("INFO", "replication").Value.(string)
+			if reported := infoField(info, "slave_priority"); reported != priority {
+				t.Fatalf("INFO reports slave_priority %s after setting %s to %s", reported, name, priority)
+			}
+		}
+	}
+}
+
+func TestPartialSyncAtBacklogBoundaries(t *testing.T) {
+	masterPort := startTestServer(t, "", "repl-backlog-size", "16kb")
+	replicaPort := startTestServer(t, "127.0.0.1 "+masterPort)
+
+	master := newTestClient(t, masterPort)
+	replica := newTestClient(t, replicaPort)
+	for i := 0; i < 40; i++ {
+		master.do("SET", "key", strings.Repeat("x", 1000))
+	}
+	master.do("SET", "synced", "1")
+	waitFor(t, "the replica to sync", func() bool {
+		return isBulk(replica.do("GET", "synced"), "1")
+	})
+
+	info := master.do("INFO", "replication").Value.(string)
+	replid := infoField(info, "master_replid")
+	first, _ := strconv.Atoi(infoField(info, "repl_backlog_first_byte_offset"))
+	offset, _ := strconv.Atoi(infoField(info, "master_repl_offset"))
+	if first <= 1 {
+		t.Fatalf("the backlog never wrapped around, it starts at %d", first)
+	}
+
+	for _, tc := range []struct {
+		offset int
+		reply  string
+	}{
+		{first - 1, "FULLRESYNC"},
+		{first, "CONTINUE"},
+		{offset + 1, "CONTINUE"},
+		{offset + 2, "FULLRESYNC"},
+	} {
+		resp := newTestClient(t, masterPort).do("PSYNC", replid, strconv.Itoa(tc.offset))
+		reply, _ := resp.Value.(string)
+		if !strings.HasPrefix(reply, tc.reply+" ") {
+			t.Fatalf("PSYNC from %d with the backlog at %d-%d returned %v, want %s", tc.offset, first, offset, resp, tc.reply)
+		}
+	}
+}
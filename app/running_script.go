@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultBusyReplyThreshold = 5000 * time.Millisecond
+
+// RunningScript tracks the script or function the server is executing, if
+// any. Once it runs for longer than the busy threshold, other clients are
+// answered with BUSY and may kill it, as long as it hasn't written anything.
+type RunningScript struct {
+	running   bool
+	name      string
+	function  bool
+	start     time.Time
+	wrote     bool
+	killed    bool
+	kill      func()
+	threshold time.Duration
+	lock      sync.Mutex
+}
+
+func NewRunningScript() *RunningScript {
+	return &RunningScript{threshold: defaultBusyReplyThreshold}
+}
+
+// Start records that the script or function called name is running, which
+// kill stops.
+func (rs *RunningScript) Start(name string, function bool, kill func()) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.running, rs.wrote, rs.killed = true, false, false
+	rs.name, rs.function = name, function
+	rs.start = time.Now()
+	rs.kill = kill
+}
+
+// Stop ends the script and reports whether it was killed.
+func (rs *RunningScript) Stop() bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.running = false
+	rs.kill = nil
+	return rs.killed
+}
+
+// Wrote records that the script modified the dataset, after which it can no
+// longer be killed.
+func (rs *RunningScript) Wrote() {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.wrote = true
+}
+
+// Busy returns the error other clients are answered with once the script has
+// been running for longer than the busy threshold.
+func (rs *RunningScript) Busy() (RESPValue, bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if !rs.running || time.Since(rs.start) < rs.threshold {
+		return RESPValue{}, false
+	}
+
+	kill := "SCRIPT KILL"
+	if rs.function {
+		kill = "FUNCTION KILL"
+	}
+
+	return RESPValue{Type: SimpleError, Value: RESPError{Error: "BUSY", Message: fmt.Sprintf("Redis is busy running a script. You can only call %s or SHUTDOWN NOSAVE.", kill)}}, true
+}
+
+// Running returns the name of the running script, or function when function
+// is set, and how long it has been running.
+func (rs *RunningScript) Running(function bool) (string, time.Duration, bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	return rs.name, time.Since(rs.start), rs.running && rs.function == function
+}
+
+// Kill stops the running script, or function when function is set, returning
+// the error to answer with when there is none or it already wrote.
+func (rs *RunningScript) Kill(function bool) (RESPValue, bool) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if !rs.running || rs.function != function {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "NOTBUSY", Message: "No scripts in execution right now."}}, false
+	}
+
+	if rs.wrote {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "UNKILLABLE", Message: "Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."}}, false
+	}
+
+	rs.killed = true
+	rs.kill()
+	return RESPValue{}, true
+}
+
+func (rs *RunningScript) Threshold() time.Duration {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	return rs.threshold
+}
+
+func (rs *RunningScript) SetThreshold(threshold time.Duration) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	rs.threshold = threshold
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SavePoint triggers a background save once at least Changes writes have
+// happened and Seconds have passed since the last successful save.
+type SavePoint struct {
+	Seconds int
+	Changes int
+}
+
+func ParseSavePoints(config string) ([]SavePoint, error) {
+	fields := strings.Fields(config)
+	if len(fields)%2 != 0 {
+		return []SavePoint{}, fmt.Errorf("invalid save parameters: %s", config)
+	}
+
+	savePoints := []SavePoint{}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 0 {
+			return []SavePoint{}, fmt.Errorf("invalid save parameters: %s", config)
+		}
+
+		changes, err := strconv.Atoi(fields[i+1])
+		if err != nil || changes < 0 {
+			return []SavePoint{}, fmt.Errorf("invalid save parameters: %s", config)
+		}
+
+		savePoints = append(savePoints, SavePoint{Seconds: seconds, Changes: changes})
+	}
+
+	return savePoints, nil
+}
+
+func FormatSavePoints(savePoints []SavePoint) string {
+	fields := []string{}
+	for _, savePoint := range savePoints {
+		fields = append(fields, strconv.Itoa(savePoint.Seconds), strconv.Itoa(savePoint.Changes))
+	}
+
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/internal/lua"
+	"github.com/codecrafters-io/redis-starter-go/app/internal/lua/parse"
+)
+
+// scriptChunkName is the name Lua errors report for a script's source.
+const scriptChunkName = "user_script"
+
+// Script is a Lua script compiled once and cached by the SHA1 of its body.
+type Script struct {
+	sha   string
+	body  string
+	proto *lua.FunctionProto
+}
+
+func ScriptSHA(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// singleLine joins the lines of a Lua error message, which must fit on the
+// line of an error reply.
+func singleLine(message string) string {
+	return strings.Join(strings.Fields(message), " ")
+}
+
+func CompileScript(body string) (*Script, error) {
+	chunk, err := parse.Parse(strings.NewReader(body), scriptChunkName)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling script (new function): %s", singleLine(err.Error()))
+	}
+
+	proto, err := lua.Compile(chunk, scriptChunkName)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling script (new function): %s", singleLine(err.Error()))
+	}
+
+	return &Script{sha: ScriptSHA(body), body: body, proto: proto}, nil
+}
+
+type ScriptCache struct {
+	scripts map[string]*Script
+	lock    sync.Mutex
+}
+
+func NewScriptCache() *ScriptCache {
+	return &ScriptCache{scripts: map[string]*Script{}}
+}
+
+// Load compiles body unless a script with the same SHA1 is already cached.
+func (sc *ScriptCache) Load(body string) (*Script, error) {
+	sha := ScriptSHA(body)
+
+	sc.lock.Lock()
+	script, ok := sc.scripts[sha]
+	sc.lock.Unlock()
+	if ok {
+		return script, nil
+	}
+
+	script, err := CompileScript(body)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	sc.scripts[sha] = script
+	return script, nil
+}
+
+func (sc *ScriptCache) Get(sha string) (*Script, bool) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	script, ok := sc.scripts[strings.ToLower(sha)]
+	return script, ok
+}
+
+func (sc *ScriptCache) Flush() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	sc.scripts = map[string]*Script{}
+}
+
+func (sc *ScriptCache) Size() int {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	return len(sc.scripts)
+}
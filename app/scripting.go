@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/internal/lua"
+)
+
+// openScriptLibs opens the Lua libraries scripts may use, leaving out those
+// that reach the file system or load other code.
+func openScriptLibs(L *lua.LState) {
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.LoadLibName, lua.OpenPackage},
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile", "require", "module", "package"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+}
+
+// respError splits an error reply as written by a script, such as
+// "WRONGTYPE Operation against a key", into its code and message.
+func respError(reply string) RESPValue {
+	code, message, _ := strings.Cut(reply, " ")
+	return RESPValue{Type: SimpleError, Value: RESPError{Error: code, Message: message}}
+}
+
+func replyTable(L *lua.LState, field string, reply string) *lua.LTable {
+	table := L.NewTable()
+	table.RawSetString(field, lua.LString(reply))
+	return table
+}
+
+// respToLua converts a command's reply for a script: status and error replies
+// become tables with an ok or err field, and nulls become false.
+func respToLua(L *lua.LState, val RESPValue) lua.LValue {
+	switch val.Type {
+	case Integer:
+		return lua.LNumber(val.Value.(int))
+	case BulkString, RawString:
+		return lua.LString(val.Value.(string))
+	case SimpleString:
+		return replyTable(L, "ok", val.Value.(string))
+	case SimpleError:
+		err := val.Value.(RESPError)
+		return replyTable(L, "err", strings.TrimSpace(err.Error+" "+err.Message))
+	case Array:
+		table := L.NewTable()
+		for _, item := range val.Value.([]RESPValue) {
+			table.Append(respToLua(L, item))
+		}
+		return table
+	}
+
+	return lua.LFalse
+}
+
+// luaToRESP converts a script's return value to a reply. Numbers are
+// truncated to integers, true becomes 1, and arrays end at their first nil.
+func luaToRESP(val lua.LValue) RESPValue {
+	switch val := val.(type) {
+	case lua.LNumber:
+		return RESPValue{Type: Integer, Value: int(val)}
+	case lua.LString:
+		return RESPValue{Type: BulkString, Value: string(val)}
+	case lua.LBool:
+		if val {
+			return RESPValue{Type: Integer, Value: 1}
+		}
+	case *lua.LTable:
+		if reply, ok := val.RawGetString("err").(lua.LString); ok {
+			return respError(string(reply))
+		}
+		if reply, ok := val.RawGetString("ok").(lua.LString); ok {
+			return RESPValue{Type: SimpleString, Value: string(reply)}
+		}
+
+		values := []RESPValue{}
+		for i := 1; ; i++ {
+			item := val.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			values = append(values, luaToRESP(item))
+		}
+		return RESPValue{Type: Array, Value: values}
+	}
+
+	return RESPValue{Type: NullBulkString}
+}
+
+func stringsTable(L *lua.LState, values []RESPValue) *lua.LTable {
+	table := L.NewTable()
+	for _, val := range values {
+		table.Append(lua.LString(val.Value.(string)))
+	}
+
+	return table
+}
+
+// newScriptState returns a fresh Lua state for running a script, which is
+// stopped once ctx is done.
+func newScriptState(ctx context.Context) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	openScriptLibs(L)
+	L.SetContext(ctx)
+	return L
+}
+
+// scriptCall runs a command called by a script through redis.call or
+// redis.pcall, collecting the writes it makes into effects. A read only
+// script may not call writes.
+func (rc *RedisConnection) scriptCall(ctx context.Context, L *lua.LState, effects *[]RESPValue, readOnly bool) RESPValue {
+	if L.GetTop() == 0 {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Please specify at least one argument for this redis lib call"}}
+	}
+
+	args := []string{}
+	for i := 1; i <= L.GetTop(); i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			args = append(args, string(arg))
+		case lua.LNumber:
+			if float64(arg) == float64(int64(arg)) {
+				args = append(args, strconv.FormatInt(int64(arg), 10))
+			} else {
+				args = append(args, strconv.FormatFloat(float64(arg), 'g', 17, 64))
+			}
+		default:
+			return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Lua redis lib command arguments must be strings or integers"}}
+		}
+	}
+
+	resp := CommandRESP(args...)
+	parseInfo := ParseInfo{Command: strings.ToUpper(args[0]), Args: resp.Value.([]RESPValue)[1:]}
+	command, callErr, ok := checkCall(parseInfo)
+	if !ok {
+		return callErr
+	}
+
+	if command.HasFlag(commandNoScript) {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "This Redis command is not allowed from script"}}
+	}
+
+	if readOnly && command.HasFlag(commandWrite) {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Write commands are not allowed from read-only scripts."}}
+	}
+
+	rejection, rejected := rc.rejection(command)
+	if rejected {
+		return rejection
+	}
+
+	if command.HasFlag(commandWrite) {
+		rc.Server.RunningScript.Wrote()
+	}
+
+	rc.propagated = []RESPValue{resp}
+	responses := rc.invoke(ctx, command, parseInfo)
+	rc.feedMonitors(command, parseInfo, "lua")
+	if command.HasFlag(commandWrite) && !isErrorResponse(responses) {
+		*effects = append(*effects, rc.propagated...)
+	}
+
+	if len(responses) == 0 {
+		return RESPValue{Type: NullBulkString}
+	}
+
+	return responses[0]
+}
+
+// redisLibrary builds the redis table of helpers available to every script.
+func redisLibrary(L *lua.LState) *lua.LTable {
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(ScriptSHA(L.CheckString(1))))
+			return 1
+		},
+	})
+}
+
+// addRedisCalls adds redis.call and redis.pcall to the redis table. A failing
+// redis.call raises the error reply, which ends the script with it, while
+// redis.pcall returns it.
+func (rc *RedisConnection) addRedisCalls(ctx context.Context, L *lua.LState, redis *lua.LTable, effects *[]RESPValue, readOnly bool) {
+	call := func(protect bool) lua.LGFunction {
+		return func(L *lua.LState) int {
+			reply := respToLua(L, rc.scriptCall(ctx, L, effects, readOnly))
+			if table, ok := reply.(*lua.LTable); ok && !protect && table.RawGetString("err") != lua.LNil {
+				L.Error(table, 1)
+			}
+
+			L.Push(reply)
+			return 1
+		}
+	}
+
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  call(false),
+		"pcall": call(true),
+	})
+}
+
+// runLua calls fn with args as the running script or function called name,
+// and propagates the writes it made instead of the script itself, so replicas
+// and the AOF get the same effects however the script behaves.
+func (rc *RedisConnection) runLua(L *lua.LState, kill func(), name string, function bool, fn *lua.LFunction, args []lua.LValue, effects *[]RESPValue) []RESPValue {
+	rc.Server.RunningScript.Start(name, function, kill)
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	err := L.PCall(len(args), 1, nil)
+	killed := rc.Server.RunningScript.Stop()
+	rc.propagated = *effects
+
+	if killed && function {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Script killed by user with FUNCTION KILL..."}}}
+	} else if killed {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Script killed by user with SCRIPT KILL..."}}}
+	}
+
+	if err != nil {
+		apiErr, ok := err.(*lua.ApiError)
+		if ok {
+			if table, ok := apiErr.Object.(*lua.LTable); ok && table.RawGetString("err") != lua.LNil {
+				return []RESPValue{luaToRESP(table)}
+			}
+		}
+
+		message := err.Error()
+		if ok {
+			message = apiErr.Object.String()
+		}
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("%s script: %s", singleLine(message), name)}}}
+	}
+
+	return []RESPValue{luaToRESP(L.Get(-1))}
+}
+
+// runScript executes an EVAL script with KEYS and ARGV set.
+func (rc *RedisConnection) runScript(ctx context.Context, script *Script, keys []RESPValue, argv []RESPValue) []RESPValue {
+	scriptCtx, kill := context.WithCancel(ctx)
+	defer kill()
+	L := newScriptState(scriptCtx)
+	defer L.Close()
+
+	effects := []RESPValue{}
+	redis := redisLibrary(L)
+	rc.addRedisCalls(ctx, L, redis, &effects, false)
+	L.SetGlobal("redis", redis)
+	L.SetGlobal("KEYS", stringsTable(L, keys))
+	L.SetGlobal("ARGV", stringsTable(L, argv))
+
+	return rc.runLua(L, kill, script.sha, false, L.NewFunctionFromProto(script.proto), nil, &effects)
+}
+
+// runFunction calls a library function with its keys and arguments. The
+// library is loaded again in a fresh state to register its callbacks. A
+// function called with FCALL_RO or flagged no-writes may not write.
+func (rc *RedisConnection) runFunction(ctx context.Context, library *FunctionLibrary, function *LibraryFunction, keys []RESPValue, argv []RESPValue, readOnly bool) []RESPValue {
+	scriptCtx, kill := context.WithCancel(ctx)
+	defer kill()
+	L := newScriptState(scriptCtx)
+	defer L.Close()
+
+	redis, callbacks, err := library.instantiate(L, map[string]*LibraryFunction{})
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	effects := []RESPValue{}
+	rc.addRedisCalls(ctx, L, redis, &effects, readOnly || function.HasFlag("no-writes"))
+	args := []lua.LValue{stringsTable(L, keys), stringsTable(L, argv)}
+
+	return rc.runLua(L, kill, function.name, true, callbacks[function.name], args, &effects)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// partialWritesScript sets KEYS[1] to "partial" many times before setting it
+// to ARGV[1], so a reader running alongside would see the intermediate value.
+const partialWritesScript = `
+for i = 1, 20000 do
+	redis.call('SET', KEYS[1], 'partial')
+end
+return redis.call('SET', KEYS[1], ARGV[1])
+`
+
+func TestEvalIsIsolatedFromReaders(t *testing.T) {
+	port := startTestServer(t, "")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go readUntilStopped(t, newTestClient(t, port), "counter", "partial", stop, &wg)
+	}
+
+	client := newTestClient(t, port)
+	for _, val := range []string{"1", "2", "3"} {
+		if resp := client.do("EVAL", partialWritesScript, "1", "counter", val); resp.Value != "OK" {
+			t.Fatalf("EVAL returned %v", resp)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if resp := client.do("GET", "counter"); !isBulk(resp, "3") {
+		t.Fatalf("GET returned %v after the last script", resp)
+	}
+}
+
+func TestFcallIsIsolatedFromReaders(t *testing.T) {
+	port := startTestServer(t, "")
+
+	client := newTestClient(t, port)
+	library := "#!lua name=partial\nredis.register_function('partial_writes', function(KEYS, ARGV)\n" + partialWritesScript + "\nend)"
+	if resp := client.do("FUNCTION", "LOAD", library); !isBulk(resp, "partial") {
+		t.Fatalf("FUNCTION LOAD returned %v", resp)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go readUntilStopped(t, newTestClient(t, port), "counter", "partial", stop, &wg)
+	}
+
+	for _, val := range []string{"1", "2", "3"} {
+		if resp := client.do("FCALL", "partial_writes", "1", "counter", val); resp.Value != "OK" {
+			t.Fatalf("FCALL returned %v", resp)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if resp := client.do("GET", "counter"); !isBulk(resp, "3") {
+		t.Fatalf("GET returned %v after the last function call", resp)
+	}
+}
@@ -0,0 +1,805 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSentinelPort     = "26379"
+	sentinelHelloChannel    = "__sentinel__:hello"
+	sentinelTimerPeriod     = 100 * time.Millisecond
+	sentinelPingPeriod      = time.Second
+	sentinelInfoPeriod      = 10 * time.Second
+	sentinelHelloPeriod     = 2 * time.Second
+	sentinelAskPeriod       = time.Second
+	sentinelElectionTimeout = 10 * time.Second
+	sentinelMaxDesync       = time.Second
+)
+
+// Sentinel monitors masters and their replicas, and together with its peer
+// sentinels promotes a replica when a master fails. Peers find each other and
+// share the current configuration through hello messages, and agree on a
+// master being down and on which of them leads its failover by voting.
+type Sentinel struct {
+	port         string
+	runid        string
+	currentEpoch int
+	masters      map[string]*SentinelMaster
+	ctx          context.Context
+	lock         sync.Mutex
+}
+
+func NewSentinel(port string) *Sentinel {
+	return &Sentinel{port: port, runid: NewReplicationID(), masters: map[string]*SentinelMaster{}}
+}
+
+// Monitor starts monitoring the master at host and port under name, which is
+// considered objectively down once quorum sentinels agree it is.
+func (s *Sentinel) Monitor(name string, host string, port string, quorum int, downAfter time.Duration, failoverTimeout time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.masters[name]; ok {
+		return fmt.Errorf("Duplicated master name.")
+	}
+
+	master := NewSentinelMaster(name, host, port, quorum, downAfter, failoverTimeout)
+	s.masters[name] = master
+	s.watch(master, master.instance)
+
+	return nil
+}
+
+// AddSentinel adds a peer sentinel monitoring the master named name. Peers
+// announce themselves with hello messages, so only one side needs to know
+// the other.
+func (s *Sentinel) AddSentinel(name string, host string, port string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	master, ok := s.masters[name]
+	if !ok {
+		return fmt.Errorf("No such master with that name")
+	}
+
+	if !s.isOwnAddress(host, port) {
+		s.addInstance(master, master.sentinels, sentinelInstanceSentinel, host, port)
+	}
+	return nil
+}
+
+// isOwnAddress reports whether host and port reach this sentinel, so the
+// same list of sentinels can be given to each of them.
+func (s *Sentinel) isOwnAddress(host string, port string) bool {
+	if port != s.port {
+		return false
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+
+	addrs, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return true
+		}
+
+		for _, addr := range addrs {
+			if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Sentinel) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", "0.0.0.0:"+s.port)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.ctx = ctx
+	for _, master := range s.masters {
+		s.watch(master, master.instance)
+		for _, instance := range master.replicas {
+			s.watch(master, instance)
+		}
+		for _, instance := range master.sentinels {
+			s.watch(master, instance)
+		}
+		go s.handleMaster(ctx, master)
+	}
+	s.lock.Unlock()
+
+	for {
+		conn, err := AcceptTCPConnection(listener)
+		if err != nil {
+			return fmt.Errorf("error accepting connection: %v", err)
+		}
+
+		go NewSentinelConnection(NewRESPConnection(conn), s).HandleRequests(ctx)
+	}
+}
+
+// event logs something that happened to an instance, in the format of
+// Redis Sentinel's events.
+func (s *Sentinel) event(name string, master *SentinelMaster, instance *SentinelInstance, format string, args ...any) {
+	description := fmt.Sprintf("master %s %s %s", master.name, instance.host, instance.port)
+	if instance != master.instance {
+		description = fmt.Sprintf("%s %s %s %s @ %s %s %s", instance.kind, instance.Address(), instance.host, instance.port, master.name, master.instance.host, master.instance.port)
+	}
+
+	if format != "" {
+		description += " " + fmt.Sprintf(format, args...)
+	}
+
+	fmt.Printf("%s %s\n", name, description)
+}
+
+// watch starts monitoring an instance in the background, once the sentinel
+// runs. The sentinel's lock must be held.
+func (s *Sentinel) watch(master *SentinelMaster, instance *SentinelInstance) {
+	if s.ctx == nil || instance.stop != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	instance.stop = cancel
+	go s.monitorInstance(ctx, master, instance)
+}
+
+func (s *Sentinel) unwatch(instance *SentinelInstance) {
+	if instance.stop != nil {
+		instance.stop()
+	}
+}
+
+// addInstance adds a replica or sentinel to instances unless it is already
+// known. The sentinel's lock must be held.
+func (s *Sentinel) addInstance(master *SentinelMaster, instances map[string]*SentinelInstance, kind string, host string, port string) *SentinelInstance {
+	instance := NewSentinelInstance(kind, host, port)
+	if existing, ok := instances[instance.Address()]; ok {
+		return existing
+	}
+
+	instances[instance.Address()] = instance
+	s.watch(master, instance)
+	if kind == sentinelInstanceReplica {
+		s.event("+slave", master, instance, "")
+	}
+
+	return instance
+}
+
+// monitorInstance pings an instance every second, or every
+// down-after-milliseconds if that is shorter, refreshes what it reports
+// through INFO and, for peer sentinels, sends hello messages. While the
+// instance can't be reached, it tries again every 100ms.
+func (s *Sentinel) monitorInstance(ctx context.Context, master *SentinelMaster, instance *SentinelInstance) {
+	ticker := time.NewTicker(sentinelTimerPeriod)
+	defer ticker.Stop()
+	defer instance.link.Close()
+
+	period := min(sentinelPingPeriod, master.downAfter)
+	var lastPing time.Time
+	for {
+		if time.Since(lastPing) >= period || !instance.link.Connected() {
+			lastPing = time.Now()
+			s.ping(instance)
+			if instance.kind == sentinelInstanceSentinel {
+				s.sendHello(master, instance)
+			} else {
+				s.refreshInfo(master, instance)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isPingReply reports whether reply shows the instance is working, which
+// includes it still loading its data or having lost its own master.
+func isPingReply(reply RESPValue) bool {
+	switch reply.Type {
+	case SimpleString:
+		return reply.Value.(string) == "PONG"
+	case SimpleError:
+		message := reply.Value.(string)
+		return strings.HasPrefix(message, "LOADING") || strings.HasPrefix(message, "MASTERDOWN")
+	}
+
+	return false
+}
+
+// ping sends a PING to an instance. Down time is measured from the oldest PING
+// still unanswered, so it is only recorded when no earlier one is pending.
+func (s *Sentinel) ping(instance *SentinelInstance) {
+	s.lock.Lock()
+	if instance.actPing.IsZero() {
+		instance.actPing = time.Now()
+	}
+	s.lock.Unlock()
+
+	reply, err := instance.link.Command("PING")
+	if err != nil || !isPingReply(reply) {
+		return
+	}
+
+	s.lock.Lock()
+	instance.lastPong = time.Now()
+	instance.actPing = time.Time{}
+	s.lock.Unlock()
+}
+
+// refreshInfo asks an instance for INFO replication every ten seconds, and
+// every second while its master is down or failing over. Replicas a master
+// lists are added to the ones monitored.
+func (s *Sentinel) refreshInfo(master *SentinelMaster, instance *SentinelInstance) {
+	s.lock.Lock()
+	period := sentinelInfoPeriod
+	if master.instance.sdown || master.failoverState != sentinelFailoverNone {
+		period = sentinelPingPeriod
+	}
+	due := instance.infoRefresh.IsZero() || time.Since(instance.infoRefresh) >= period
+	s.lock.Unlock()
+
+	if !due {
+		return
+	}
+
+	reply, err := instance.link.Command("INFO", "replication")
+	if err != nil || reply.Type != BulkString {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	replicas := instance.applyInfo(reply.Value.(string))
+	if instance == master.instance {
+		for _, replica := range replicas {
+			if !master.Is(replica.Key, replica.Val) {
+				s.addInstance(master, master.replicas, sentinelInstanceReplica, replica.Key, replica.Val)
+			}
+		}
+	} else if instance.kind == sentinelInstanceReplica {
+		s.fixReplicaConfig(master, instance)
+	}
+}
+
+// fixReplicaConfig points a replica that reports being a master, or follows
+// another master, at the monitored master. This is how a failed master that
+// comes back joins the promoted replica. The change must have been reported
+// for a while, so a configuration still being updated is left alone.
+func (s *Sentinel) fixReplicaConfig(master *SentinelMaster, instance *SentinelInstance) {
+	if !master.looksSane() || time.Since(instance.roleReported) < 4*sentinelHelloPeriod {
+		return
+	}
+
+	if instance.role == "slave" && master.Is(instance.masterHost, instance.masterPort) {
+		return
+	}
+
+	if instance.role == "master" {
+		s.event("+convert-to-slave", master, instance, "")
+	} else {
+		s.event("+fix-slave-config", master, instance, "")
+	}
+
+	instance.roleReported = time.Now()
+	host, port := master.instance.host, master.instance.port
+	go instance.link.Command("REPLICAOF", host, port)
+}
+
+// sendHello announces this sentinel and its configuration of the master to a
+// peer every two seconds.
+func (s *Sentinel) sendHello(master *SentinelMaster, instance *SentinelInstance) {
+	ip := instance.link.LocalIP()
+
+	s.lock.Lock()
+	due := ip != "" && time.Since(instance.helloSent) >= sentinelHelloPeriod
+	if due {
+		instance.helloSent = time.Now()
+	}
+	hello := strings.Join([]string{
+		ip, s.port, s.runid, strconv.Itoa(s.currentEpoch),
+		master.name, master.instance.host, master.instance.port, strconv.Itoa(master.configEpoch),
+	}, ",")
+	s.lock.Unlock()
+
+	if due {
+		instance.link.Command("PUBLISH", sentinelHelloChannel, hello)
+	}
+}
+
+// ProcessHello learns about the sentinel that sent a hello message, and
+// adopts its configuration of the master if it is newer.
+func (s *Sentinel) ProcessHello(hello string) error {
+	fields := strings.Split(hello, ",")
+	if len(fields) != 8 {
+		return fmt.Errorf("invalid hello message")
+	}
+
+	epoch, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return fmt.Errorf("invalid hello message")
+	}
+
+	configEpoch, err := strconv.Atoi(fields[7])
+	if err != nil {
+		return fmt.Errorf("invalid hello message")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	host, port, runid := fields[0], fields[1], fields[2]
+	master, ok := s.masters[fields[4]]
+	if !ok {
+		return nil
+	}
+
+	// A hello of our own means we were listed as our own peer.
+	address := net.JoinHostPort(host, port)
+	if runid == s.runid {
+		if self, ok := master.sentinels[address]; ok {
+			s.unwatch(self)
+			delete(master.sentinels, address)
+		}
+		return nil
+	}
+
+	if _, ok := master.sentinels[address]; !ok {
+		for other, sentinel := range master.sentinels {
+			if sentinel.runid == runid {
+				s.unwatch(sentinel)
+				delete(master.sentinels, other)
+			}
+		}
+	}
+
+	peer, ok := master.sentinels[address]
+	if !ok {
+		peer = s.addInstance(master, master.sentinels, sentinelInstanceSentinel, host, port)
+		s.event("+sentinel", master, peer, "")
+	}
+	peer.runid = runid
+	peer.lastHello = time.Now()
+
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		fmt.Printf("+new-epoch %d\n", epoch)
+	}
+
+	if configEpoch > master.configEpoch {
+		if !master.Is(fields[5], fields[6]) {
+			s.event("+config-update-from", master, peer, "")
+			s.switchMaster(master, fields[5], fields[6], configEpoch)
+		}
+		master.configEpoch = configEpoch
+	}
+
+	return nil
+}
+
+// switchMaster makes the instance at host and port the monitored master, with
+// every other instance known, including the old master, as its replicas. The
+// sentinel's lock must be held.
+func (s *Sentinel) switchMaster(master *SentinelMaster, host string, port string, configEpoch int) {
+	old := master.instance
+	fmt.Printf("+switch-master %s %s %s %s %s\n", master.name, old.host, old.port, host, port)
+
+	replicas := []Pair{}
+	for _, replica := range master.replicas {
+		if replica.host != host || replica.port != port {
+			replicas = append(replicas, Pair{Key: replica.host, Val: replica.port})
+		}
+		s.unwatch(replica)
+	}
+	if old.host != host || old.port != port {
+		replicas = append(replicas, Pair{Key: old.host, Val: old.port})
+	}
+	s.unwatch(old)
+
+	master.instance = NewSentinelInstance(sentinelInstanceMaster, host, port)
+	master.replicas = map[string]*SentinelInstance{}
+	s.watch(master, master.instance)
+	for _, replica := range replicas {
+		s.addInstance(master, master.replicas, sentinelInstanceReplica, replica.Key, replica.Val)
+	}
+
+	master.configEpoch = configEpoch
+	master.odown = false
+	master.failoverState = sentinelFailoverNone
+	master.failoverForced = false
+	master.promoted = nil
+}
+
+// handleMaster checks the state of a master and its instances every 100ms,
+// starting a failover when the master is objectively down.
+func (s *Sentinel) handleMaster(ctx context.Context, master *SentinelMaster) {
+	ticker := time.NewTicker(sentinelTimerPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.lock.Lock()
+		s.checkMaster(master)
+		s.lock.Unlock()
+	}
+}
+
+func (s *Sentinel) checkMaster(master *SentinelMaster) {
+	s.checkSubjectivelyDown(master, master.instance)
+	for _, instance := range master.replicas {
+		s.checkSubjectivelyDown(master, instance)
+	}
+	for _, instance := range master.sentinels {
+		s.checkSubjectivelyDown(master, instance)
+	}
+
+	s.checkObjectivelyDown(master)
+	if (master.instance.sdown || master.failoverState == sentinelFailoverWaitStart) && time.Since(master.lastAsk) >= sentinelAskPeriod {
+		master.lastAsk = time.Now()
+		s.askSentinels(master)
+	}
+
+	if master.odown && master.failoverState == sentinelFailoverNone && time.Since(master.failoverStart) > 2*master.failoverTimeout {
+		s.startFailover(master, false)
+	}
+
+	if master.failoverState == sentinelFailoverWaitStart {
+		s.checkElection(master)
+	}
+}
+
+// checkSubjectivelyDown marks an instance down when a PING has gone
+// unanswered for longer than down-after-milliseconds.
+func (s *Sentinel) checkSubjectivelyDown(master *SentinelMaster, instance *SentinelInstance) {
+	down := instance.noReplySince() > master.downAfter
+	if down == instance.sdown {
+		return
+	}
+
+	instance.sdown = down
+	if down {
+		s.event("+sdown", master, instance, "")
+	} else {
+		s.event("-sdown", master, instance, "")
+	}
+}
+
+// checkObjectivelyDown marks the master down once enough sentinels agree it
+// is subjectively down.
+func (s *Sentinel) checkObjectivelyDown(master *SentinelMaster) {
+	odown := master.instance.sdown && master.downVotes() >= master.quorum
+	if odown == master.odown {
+		return
+	}
+
+	master.odown = odown
+	if odown {
+		s.event("+odown", master, master.instance, "#quorum %d/%d", master.downVotes(), master.quorum)
+	} else {
+		s.event("-odown", master, master.instance, "")
+	}
+}
+
+// askSentinels asks every peer whether it considers the master down, and
+// while this sentinel tries to start a failover, for its vote.
+func (s *Sentinel) askSentinels(master *SentinelMaster) {
+	runid := "*"
+	if master.failoverState != sentinelFailoverNone {
+		runid = s.runid
+	}
+	host, port, epoch := master.instance.host, master.instance.port, strconv.Itoa(s.currentEpoch)
+
+	for _, peer := range master.sentinels {
+		go func(peer *SentinelInstance) {
+			reply, err := peer.link.Command("SENTINEL", "is-master-down-by-addr", host, port, epoch, runid)
+			if err != nil {
+				return
+			}
+
+			values, ok := reply.Value.([]RESPValue)
+			if !ok || len(values) != 3 {
+				return
+			}
+
+			down, _ := values[0].Value.(int)
+			leader, _ := values[1].Value.(string)
+			leaderEpoch, _ := values[2].Value.(int)
+
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			peer.masterDown = down == 1
+			peer.masterDownReply = time.Now()
+			if leader != "*" {
+				peer.leader, peer.leaderEpoch = leader, leaderEpoch
+			}
+		}(peer)
+	}
+}
+
+// VoteLeader answers a peer asking whether the master at host and port is
+// down. When the peer also asks for a vote to lead its failover in epoch, it
+// gets this sentinel's vote unless the vote was already given in that epoch.
+// The leader voted for and its epoch are returned.
+func (s *Sentinel) VoteLeader(host string, port string, epoch int, runid string) (bool, string, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var master *SentinelMaster
+	for _, m := range s.masters {
+		if m.Is(host, port) {
+			master = m
+		}
+	}
+
+	if master == nil {
+		return false, "*", 0
+	}
+
+	down := master.instance.sdown
+	if runid == "*" {
+		return down, "*", 0
+	}
+
+	if master.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		master.leader, master.leaderEpoch = runid, epoch
+		s.currentEpoch = epoch
+		fmt.Printf("+vote-for-leader %s %d\n", runid, epoch)
+
+		// Give the leader time to fail over before trying to ourselves.
+		if runid != s.runid {
+			master.failoverStart = time.Now().Add(time.Duration(rand.Int63n(int64(sentinelMaxDesync))))
+		}
+	}
+
+	if master.leader == "" {
+		return down, "*", master.leaderEpoch
+	}
+
+	return down, master.leader, master.leaderEpoch
+}
+
+// startFailover starts a new epoch and votes for this sentinel to lead the
+// failover in it. A forced failover doesn't wait for the votes of peers.
+func (s *Sentinel) startFailover(master *SentinelMaster, forced bool) {
+	s.currentEpoch += 1
+	fmt.Printf("+new-epoch %d\n", s.currentEpoch)
+
+	master.failoverState = sentinelFailoverWaitStart
+	master.failoverEpoch = s.currentEpoch
+	master.failoverStart = time.Now().Add(time.Duration(rand.Int63n(int64(sentinelMaxDesync))))
+	master.failoverForced = forced
+	master.leader, master.leaderEpoch = s.runid, s.currentEpoch
+	master.lastAsk = time.Time{}
+
+	s.event("+try-failover", master, master.instance, "")
+	fmt.Printf("+vote-for-leader %s %d\n", s.runid, s.currentEpoch)
+}
+
+func (s *Sentinel) abortFailover(master *SentinelMaster) {
+	master.failoverState = sentinelFailoverNone
+	master.failoverForced = false
+	master.promoted = nil
+}
+
+// checkElection goes on with the failover once enough sentinels voted for
+// this one, and gives up if they don't in time.
+func (s *Sentinel) checkElection(master *SentinelMaster) {
+	if master.failoverForced || master.leaderVotes(s.runid, master.failoverEpoch) >= master.votesNeeded() {
+		s.event("+elected-leader", master, master.instance, "")
+		master.failoverState = sentinelFailoverSelectReplica
+		go s.failover(master, master.failoverEpoch)
+		return
+	}
+
+	timeout := min(sentinelElectionTimeout, master.failoverTimeout)
+	if time.Since(master.failoverStart) > timeout {
+		s.event("-failover-abort-not-elected", master, master.instance, "")
+		s.abortFailover(master)
+	}
+}
+
+func (s *Sentinel) failoverRunning(master *SentinelMaster, epoch int) bool {
+	return master.failoverState != sentinelFailoverNone && master.failoverEpoch == epoch
+}
+
+// failover promotes the best replica with REPLICAOF NO ONE, waits until it
+// reports being a master and points the other replicas to it. The new
+// configuration reaches peer sentinels through hello messages.
+func (s *Sentinel) failover(master *SentinelMaster, epoch int) {
+	s.lock.Lock()
+	if !s.failoverRunning(master, epoch) {
+		s.lock.Unlock()
+		return
+	}
+
+	promoted := master.selectReplica()
+	if promoted == nil {
+		s.event("-failover-abort-no-good-slave", master, master.instance, "")
+		s.abortFailover(master)
+		s.lock.Unlock()
+		return
+	}
+
+	s.event("+selected-slave", master, promoted, "")
+	master.promoted = promoted
+	master.failoverState = sentinelFailoverWaitPromotion
+	s.lock.Unlock()
+
+	_, err := promoted.link.Command("REPLICAOF", "NO", "ONE")
+	s.lock.Lock()
+	if err != nil {
+		if s.failoverRunning(master, epoch) {
+			s.event("-failover-abort-slaveof-noone-failed", master, promoted, "")
+			s.abortFailover(master)
+		}
+		s.lock.Unlock()
+		return
+	}
+	s.event("+failover-state-wait-promotion", master, promoted, "")
+	s.lock.Unlock()
+
+	if !s.waitForPromotion(master, promoted, epoch) {
+		return
+	}
+
+	s.lock.Lock()
+	s.event("+promoted-slave", master, promoted, "")
+	master.failoverState = sentinelFailoverReconfReplica
+	replicas := []*SentinelInstance{}
+	for _, replica := range master.replicas {
+		if replica != promoted {
+			replicas = append(replicas, replica)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, replica := range replicas {
+		_, err := replica.link.Command("REPLICAOF", promoted.host, promoted.port)
+		if err == nil {
+			s.lock.Lock()
+			s.event("+slave-reconf-sent", master, replica, "")
+			s.lock.Unlock()
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.failoverRunning(master, epoch) {
+		s.event("+failover-end", master, master.instance, "")
+		s.switchMaster(master, promoted.host, promoted.port, epoch)
+	}
+}
+
+// waitForPromotion waits until the promoted replica reports being a master,
+// aborting the failover if that takes longer than failover-timeout.
+func (s *Sentinel) waitForPromotion(master *SentinelMaster, promoted *SentinelInstance, epoch int) bool {
+	ticker := time.NewTicker(sentinelTimerPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.lock.Lock()
+		if !s.failoverRunning(master, epoch) {
+			s.lock.Unlock()
+			return false
+		}
+
+		if promoted.role == "master" {
+			s.lock.Unlock()
+			return true
+		}
+
+		if time.Since(master.failoverStart) > master.failoverTimeout {
+			s.event("-failover-abort-timeout", master, promoted, "")
+			s.abortFailover(master)
+			s.lock.Unlock()
+			return false
+		}
+		s.lock.Unlock()
+	}
+
+	return false
+}
+
+// MasterAddress returns the address of the master named name.
+func (s *Sentinel) MasterAddress(name string) (string, string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	master, ok := s.masters[name]
+	if !ok {
+		return "", "", false
+	}
+
+	return master.instance.host, master.instance.port, true
+}
+
+// ForceFailover fails the master named name over without waiting for it to be
+// down or for peers to agree.
+func (s *Sentinel) ForceFailover(name string) RESPValue {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	master, ok := s.masters[name]
+	if !ok {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "No such master with that name"}}
+	} else if master.failoverState != sentinelFailoverNone {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "INPROG", Message: "Failover already in progress"}}
+	} else if master.selectReplica() == nil {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "NOGOODSLAVE", Message: "No suitable replica to promote"}}
+	}
+
+	s.startFailover(master, true)
+	return RESPValue{Type: SimpleString, Value: "OK"}
+}
+
+// CheckQuorum reports whether enough sentinels are reachable to agree on the
+// master named name being down and to authorize its failover.
+func (s *Sentinel) CheckQuorum(name string) RESPValue {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	master, ok := s.masters[name]
+	if !ok {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "No such master with that name"}}
+	}
+
+	usable := 1
+	for _, sentinel := range master.sentinels {
+		if !sentinel.sdown {
+			usable += 1
+		}
+	}
+
+	if usable < master.quorum {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "NOQUORUM", Message: fmt.Sprintf("%d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)}}
+	} else if usable < master.votesNeeded() {
+		return RESPValue{Type: SimpleError, Value: RESPError{Error: "NOQUORUM", Message: fmt.Sprintf("%d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable)}}
+	}
+
+	return RESPValue{Type: SimpleString, Value: fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable)}
+}
+
+// ToString returns the sentinel section of INFO.
+func (s *Sentinel) ToString() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sb := strings.Builder{}
+	WriteLine(&sb, "# Sentinel")
+	WriteLine(&sb, fmt.Sprintf("sentinel_masters:%d\n", len(s.masters)))
+	i := 0
+	for _, master := range s.masters {
+		WriteLine(&sb, fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\n", i, master.name, master.status(), master.instance.Address(), len(master.replicas), len(master.sentinels)+1))
+		i++
+	}
+
+	return sb.String()
+}
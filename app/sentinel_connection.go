@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sentinelCommand is a command a sentinel answers. Arity follows the same
+// rules as Command.
+type sentinelCommand struct {
+	Name    string
+	Arity   int
+	Handler func(sc *SentinelConnection, parseInfo ParseInfo) []RESPValue
+}
+
+var sentinelCommandTable map[string]sentinelCommand
+
+func init() {
+	sentinelCommandTable = map[string]sentinelCommand{}
+	for _, command := range []sentinelCommand{
+		{Name: "PING", Arity: -1, Handler: (*SentinelConnection).responsePING},
+		{Name: "INFO", Arity: -1, Handler: (*SentinelConnection).responseINFO},
+		{Name: "PUBLISH", Arity: 3, Handler: (*SentinelConnection).responsePUBLISH},
+		{Name: "SENTINEL", Arity: -2, Handler: (*SentinelConnection).responseSENTINEL},
+	} {
+		sentinelCommandTable[command.Name] = command
+	}
+}
+
+// SentinelConnection is a client of a sentinel, which may be a peer sentinel.
+type SentinelConnection struct {
+	Conn     *RESPConnection
+	Sentinel *Sentinel
+}
+
+func NewSentinelConnection(conn *RESPConnection, sentinel *Sentinel) *SentinelConnection {
+	return &SentinelConnection{Conn: conn, Sentinel: sentinel}
+}
+
+func (sc *SentinelConnection) HandleRequests(ctx context.Context) error {
+	defer sc.Conn.Close()
+
+	for {
+		resp, err := sc.Conn.NextRESP(ctx)
+		if err != nil {
+			return err
+		}
+
+		parseInfo, err := sc.Conn.GetArgs(resp)
+		if err != nil {
+			return err
+		}
+
+		err = sc.Conn.RespondRESPValues(sc.ResponseFromArgs(parseInfo))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sc *SentinelConnection) ResponseFromArgs(parseInfo ParseInfo) []RESPValue {
+	command, ok := sentinelCommandTable[parseInfo.Command]
+	if !ok {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "command not found"}}}
+	}
+
+	if !(Command{Arity: command.Arity}).CheckArity(parseInfo.Args) {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(command.Name))}}}
+	}
+
+	return command.Handler(sc, parseInfo)
+}
+
+func (sc *SentinelConnection) responsePING(parseInfo ParseInfo) []RESPValue {
+	return []RESPValue{{Type: SimpleString, Value: "PONG"}}
+}
+
+func (sc *SentinelConnection) responseINFO(parseInfo ParseInfo) []RESPValue {
+	return []RESPValue{{Type: BulkString, Value: sc.Sentinel.ToString()}}
+}
+
+// responsePUBLISH accepts the hello messages peers send straight to this
+// sentinel.
+func (sc *SentinelConnection) responsePUBLISH(parseInfo ParseInfo) []RESPValue {
+	if parseInfo.Args[0].Value.(string) != sentinelHelloChannel {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "Only HELLO messages are accepted by Sentinel instances."}}}
+	}
+
+	err := sc.Sentinel.ProcessHello(parseInfo.Args[1].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: err.Error()}}}
+	}
+
+	return []RESPValue{{Type: Integer, Value: 1}}
+}
+
+func fieldsRESP(fields []string) RESPValue {
+	values := make([]RESPValue, len(fields))
+	for i, field := range fields {
+		values[i] = RESPValue{Type: BulkString, Value: field}
+	}
+
+	return RESPValue{Type: Array, Value: values}
+}
+
+// instancesRESP lists the state of instances, sorted by address.
+func instancesRESP(master *SentinelMaster, instances map[string]*SentinelInstance) RESPValue {
+	addresses := []string{}
+	for address := range instances {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	values := []RESPValue{}
+	for _, address := range addresses {
+		values = append(values, fieldsRESP(instances[address].fields(master)))
+	}
+
+	return RESPValue{Type: Array, Value: values}
+}
+
+// sentinelMaster runs view on the master named by the first argument while
+// holding the sentinel's lock.
+func (sc *SentinelConnection) sentinelMaster(parseInfo ParseInfo, view func(master *SentinelMaster) RESPValue) []RESPValue {
+	if len(parseInfo.Args) != 2 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'sentinel' command"}}}
+	}
+
+	sc.Sentinel.lock.Lock()
+	defer sc.Sentinel.lock.Unlock()
+
+	master, ok := sc.Sentinel.masters[parseInfo.Args[1].Value.(string)]
+	if !ok {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "No such master with that name"}}}
+	}
+
+	return []RESPValue{view(master)}
+}
+
+func (sc *SentinelConnection) sentinelMASTERS() []RESPValue {
+	sc.Sentinel.lock.Lock()
+	defer sc.Sentinel.lock.Unlock()
+
+	names := []string{}
+	for name := range sc.Sentinel.masters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := []RESPValue{}
+	for _, name := range names {
+		master := sc.Sentinel.masters[name]
+		values = append(values, fieldsRESP(master.instance.fields(master)))
+	}
+
+	return []RESPValue{{Type: Array, Value: values}}
+}
+
+func (sc *SentinelConnection) sentinelGetMasterAddrByName(parseInfo ParseInfo) []RESPValue {
+	if len(parseInfo.Args) != 2 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'sentinel' command"}}}
+	}
+
+	host, port, ok := sc.Sentinel.MasterAddress(parseInfo.Args[1].Value.(string))
+	if !ok {
+		return []RESPValue{{Type: NullBulkString}}
+	}
+
+	return []RESPValue{fieldsRESP([]string{host, port})}
+}
+
+func (sc *SentinelConnection) sentinelIsMasterDownByAddr(parseInfo ParseInfo) []RESPValue {
+	if len(parseInfo.Args) != 5 {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'sentinel' command"}}}
+	}
+
+	epoch, err := strconv.Atoi(parseInfo.Args[3].Value.(string))
+	if err != nil {
+		return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "value is not an integer or out of range"}}}
+	}
+
+	host, port, runid := parseInfo.Args[1].Value.(string), parseInfo.Args[2].Value.(string), parseInfo.Args[4].Value.(string)
+	down, leader, leaderEpoch := sc.Sentinel.VoteLeader(host, port, epoch, runid)
+	res := []RESPValue{{Type: Integer, Value: boolToInt(down)}, {Type: BulkString, Value: leader}, {Type: Integer, Value: leaderEpoch}}
+
+	return []RESPValue{{Type: Array, Value: res}}
+}
+
+func (sc *SentinelConnection) responseSENTINEL(parseInfo ParseInfo) []RESPValue {
+	switch strings.ToUpper(parseInfo.Args[0].Value.(string)) {
+	case "MYID":
+		return []RESPValue{{Type: BulkString, Value: sc.Sentinel.runid}}
+	case "MASTERS":
+		return sc.sentinelMASTERS()
+	case "MASTER":
+		return sc.sentinelMaster(parseInfo, func(master *SentinelMaster) RESPValue {
+			return fieldsRESP(master.instance.fields(master))
+		})
+	case "REPLICAS", "SLAVES":
+		return sc.sentinelMaster(parseInfo, func(master *SentinelMaster) RESPValue {
+			return instancesRESP(master, master.replicas)
+		})
+	case "SENTINELS":
+		return sc.sentinelMaster(parseInfo, func(master *SentinelMaster) RESPValue {
+			return instancesRESP(master, master.sentinels)
+		})
+	case "GET-MASTER-ADDR-BY-NAME":
+		return sc.sentinelGetMasterAddrByName(parseInfo)
+	case "IS-MASTER-DOWN-BY-ADDR":
+		return sc.sentinelIsMasterDownByAddr(parseInfo)
+	case "FAILOVER":
+		if len(parseInfo.Args) != 2 {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'sentinel' command"}}}
+		}
+		return []RESPValue{sc.Sentinel.ForceFailover(parseInfo.Args[1].Value.(string))}
+	case "CKQUORUM":
+		if len(parseInfo.Args) != 2 {
+			return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: "wrong number of arguments for 'sentinel' command"}}}
+		}
+		return []RESPValue{sc.Sentinel.CheckQuorum(parseInfo.Args[1].Value.(string))}
+	}
+
+	return []RESPValue{{Type: SimpleError, Value: RESPError{Error: "ERR", Message: fmt.Sprintf("Unknown sentinel subcommand '%s'", parseInfo.Args[0].Value.(string))}}}
+}
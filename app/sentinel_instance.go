@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of instances a sentinel keeps track of for each monitored master.
+const (
+	sentinelInstanceMaster   = "master"
+	sentinelInstanceReplica  = "slave"
+	sentinelInstanceSentinel = "sentinel"
+)
+
+// SentinelInstance is a master, replica or peer sentinel as seen by a
+// sentinel. Its fields are guarded by the sentinel's lock.
+type SentinelInstance struct {
+	kind     string
+	host     string
+	port     string
+	runid    string
+	link     *SentinelLink
+	actPing  time.Time // when the oldest PING still unanswered was sent
+	lastPong time.Time
+	sdown    bool
+	stop     func()
+
+	// Reported by INFO replication.
+	infoRefresh      time.Time
+	role             string
+	roleReported     time.Time // when the role or master last changed
+	masterHost       string
+	masterPort       string
+	masterLinkStatus string
+	offset           int
+	priority         int
+
+	// Reported by a peer sentinel about the master.
+	lastHello       time.Time
+	helloSent       time.Time
+	masterDown      bool
+	masterDownReply time.Time
+	leader          string
+	leaderEpoch     int
+}
+
+func NewSentinelInstance(kind string, host string, port string) *SentinelInstance {
+	return &SentinelInstance{
+		kind:     kind,
+		host:     host,
+		port:     port,
+		link:     NewSentinelLink(net.JoinHostPort(host, port)),
+		actPing:  time.Now(),
+		priority: 100,
+	}
+}
+
+func (si *SentinelInstance) Address() string {
+	return net.JoinHostPort(si.host, si.port)
+}
+
+// noReplySince returns how long the oldest PING the instance hasn't answered
+// has been waiting, or zero when it answered them all.
+func (si *SentinelInstance) noReplySince() time.Duration {
+	if si.actPing.IsZero() {
+		return 0
+	}
+
+	return time.Since(si.actPing)
+}
+
+// applyInfo records an instance's INFO replication and returns the replicas
+// it lists when it is a master.
+func (si *SentinelInstance) applyInfo(info string) []Pair {
+	replicas := []Pair{}
+	si.infoRefresh = time.Now()
+	role, masterHost, masterPort := si.role, si.masterHost, si.masterPort
+
+	for _, line := range strings.Split(info, "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+
+		switch {
+		case key == "role":
+			si.role = val
+		case key == "master_host":
+			si.masterHost = val
+		case key == "master_port":
+			si.masterPort = val
+		case key == "master_link_status":
+			si.masterLinkStatus = val
+		case key == "slave_repl_offset":
+			si.offset, _ = strconv.Atoi(val)
+		case key == "slave_priority":
+			si.priority, _ = strconv.Atoi(val)
+		case strings.HasPrefix(key, "slave"):
+			replica := Pair{}
+			for _, field := range strings.Split(val, ",") {
+				name, value, _ := strings.Cut(field, "=")
+				if name == "ip" {
+					replica.Key = value
+				} else if name == "port" {
+					replica.Val = value
+				}
+			}
+
+			if replica.Key != "" && replica.Val != "" {
+				replicas = append(replicas, replica)
+			}
+		}
+	}
+
+	if si.role == "master" {
+		si.masterHost, si.masterPort, si.masterLinkStatus = "", "", ""
+	}
+
+	if si.role != role || si.masterHost != masterHost || si.masterPort != masterPort {
+		si.roleReported = time.Now()
+	}
+
+	return replicas
+}
+
+func (si *SentinelInstance) flags(master *SentinelMaster) string {
+	flags := []string{si.kind}
+	if si.sdown {
+		flags = append(flags, "s_down")
+	}
+	if si.kind == sentinelInstanceMaster && master.odown {
+		flags = append(flags, "o_down")
+	}
+	if si.kind == sentinelInstanceMaster && master.failoverState != sentinelFailoverNone {
+		flags = append(flags, "failover_in_progress")
+	}
+	if si == master.promoted {
+		flags = append(flags, "promoted")
+	}
+	if !si.link.Connected() {
+		flags = append(flags, "disconnected")
+	}
+
+	return strings.Join(flags, ",")
+}
+
+// fields lists the instance's state as returned by the SENTINEL commands.
+func (si *SentinelInstance) fields(master *SentinelMaster) []string {
+	lastPing := int64(-1)
+	if !si.lastPong.IsZero() {
+		lastPing = time.Since(si.lastPong).Milliseconds()
+	}
+
+	name := si.Address()
+	if si.kind == sentinelInstanceMaster {
+		name = master.name
+	}
+
+	fields := []string{
+		"name", name,
+		"ip", si.host,
+		"port", si.port,
+		"runid", si.runid,
+		"flags", si.flags(master),
+		"last-ping-reply", strconv.FormatInt(lastPing, 10),
+		"down-after-milliseconds", strconv.FormatInt(master.downAfter.Milliseconds(), 10),
+	}
+
+	switch si.kind {
+	case sentinelInstanceMaster:
+		fields = append(fields,
+			"num-slaves", strconv.Itoa(len(master.replicas)),
+			"num-other-sentinels", strconv.Itoa(len(master.sentinels)),
+			"quorum", strconv.Itoa(master.quorum),
+			"failover-timeout", strconv.FormatInt(master.failoverTimeout.Milliseconds(), 10),
+			"config-epoch", strconv.Itoa(master.configEpoch),
+		)
+	case sentinelInstanceReplica:
+		fields = append(fields,
+			"role-reported", si.role,
+			"master-link-status", si.masterLinkStatus,
+			"master-host", si.masterHost,
+			"master-port", si.masterPort,
+			"slave-priority", strconv.Itoa(si.priority),
+			"slave-repl-offset", strconv.Itoa(si.offset),
+		)
+	case sentinelInstanceSentinel:
+		lastHello := int64(-1)
+		if !si.lastHello.IsZero() {
+			lastHello = time.Since(si.lastHello).Milliseconds()
+		}
+
+		fields = append(fields,
+			"last-hello-message", strconv.FormatInt(lastHello, 10),
+			"voted-leader", si.leader,
+			"voted-leader-epoch", strconv.Itoa(si.leaderEpoch),
+		)
+	}
+
+	return fields
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const sentinelCommandTimeout = time.Second
+
+// SentinelLink is a sentinel's connection to an instance. It connects on the
+// first command and after a command fails, so an instance that restarts is
+// picked up again.
+type SentinelLink struct {
+	address string
+	conn    *RESPConnection
+	lock    sync.Mutex
+}
+
+func NewSentinelLink(address string) *SentinelLink {
+	return &SentinelLink{address: address}
+}
+
+func (sl *SentinelLink) connect() error {
+	conn, err := net.DialTimeout("tcp", sl.address, sentinelCommandTimeout)
+	if err != nil {
+		return err
+	}
+
+	sl.conn = NewRESPConnection(NewTCPConnection(&conn))
+	return nil
+}
+
+// Command sends a command to the instance and returns its reply.
+func (sl *SentinelLink) Command(args ...string) (RESPValue, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.conn == nil {
+		err := sl.connect()
+		if err != nil {
+			return RESPValue{}, fmt.Errorf("failed to connect to %s: %v", sl.address, err)
+		}
+	}
+
+	sl.conn.SetDeadline(time.Now().Add(sentinelCommandTimeout))
+	err := sl.conn.RespondRESP(CommandRESP(args...))
+	if err != nil {
+		sl.close()
+		return RESPValue{}, err
+	}
+
+	reply, err := sl.conn.NextRESP(context.Background())
+	if err != nil {
+		sl.close()
+		return RESPValue{}, err
+	}
+
+	return reply, nil
+}
+
+// LocalIP returns the address this sentinel reaches the instance from, which
+// is how it announces itself to other sentinels.
+func (sl *SentinelLink) LocalIP() string {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if sl.conn == nil {
+		return ""
+	}
+
+	return sl.conn.LocalIP()
+}
+
+func (sl *SentinelLink) Connected() bool {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	return sl.conn != nil
+}
+
+func (sl *SentinelLink) close() {
+	if sl.conn != nil {
+		sl.conn.Close()
+		sl.conn = nil
+	}
+}
+
+func (sl *SentinelLink) Close() {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	sl.close()
+}
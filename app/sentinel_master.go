@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// States of a failover run by a sentinel: it waits to be elected leader,
+// promotes the replica it selected, waits until the replica reports being a
+// master, and then points the other replicas to it.
+const (
+	sentinelFailoverNone          = "none"
+	sentinelFailoverWaitStart     = "wait_start"
+	sentinelFailoverSelectReplica = "select_slave"
+	sentinelFailoverWaitPromotion = "wait_promotion"
+	sentinelFailoverReconfReplica = "reconf_slaves"
+)
+
+// SentinelMaster is a master monitored by a sentinel, with the replicas and
+// peer sentinels discovered for it. Its fields are guarded by the sentinel's
+// lock.
+type SentinelMaster struct {
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	configEpoch     int
+	instance        *SentinelInstance
+	replicas        map[string]*SentinelInstance
+	sentinels       map[string]*SentinelInstance
+	odown           bool
+	lastAsk         time.Time
+
+	// The vote this sentinel gave for the leader of a failover of the master.
+	leader      string
+	leaderEpoch int
+
+	failoverState  string
+	failoverEpoch  int
+	failoverStart  time.Time
+	failoverForced bool
+	promoted       *SentinelInstance
+}
+
+func NewSentinelMaster(name string, host string, port string, quorum int, downAfter time.Duration, failoverTimeout time.Duration) *SentinelMaster {
+	return &SentinelMaster{
+		name:            name,
+		quorum:          quorum,
+		downAfter:       downAfter,
+		failoverTimeout: failoverTimeout,
+		instance:        NewSentinelInstance(sentinelInstanceMaster, host, port),
+		replicas:        map[string]*SentinelInstance{},
+		sentinels:       map[string]*SentinelInstance{},
+		failoverState:   sentinelFailoverNone,
+	}
+}
+
+func (sm *SentinelMaster) Is(host string, port string) bool {
+	return sm.instance.Address() == net.JoinHostPort(host, port)
+}
+
+// downVotes counts the sentinels that recently agreed the master is down,
+// including this one.
+func (sm *SentinelMaster) downVotes() int {
+	votes := 1
+	for _, sentinel := range sm.sentinels {
+		if sentinel.masterDown && time.Since(sentinel.masterDownReply) < 5*sentinelAskPeriod {
+			votes += 1
+		}
+	}
+
+	return votes
+}
+
+// leaderVotes counts the sentinels that voted for runid as the leader of the
+// failover in epoch, including this one.
+func (sm *SentinelMaster) leaderVotes(runid string, epoch int) int {
+	votes := 0
+	if sm.leader == runid && sm.leaderEpoch == epoch {
+		votes += 1
+	}
+
+	for _, sentinel := range sm.sentinels {
+		if sentinel.leader == runid && sentinel.leaderEpoch == epoch {
+			votes += 1
+		}
+	}
+
+	return votes
+}
+
+// votesNeeded is how many votes a sentinel needs to lead a failover: the
+// quorum, and at least a majority of the known sentinels.
+func (sm *SentinelMaster) votesNeeded() int {
+	return Max(sm.quorum, (len(sm.sentinels)+1)/2+1)
+}
+
+// looksSane reports whether the master is up and reports being a master, so
+// replicas pointing elsewhere can be reconfigured to follow it.
+func (sm *SentinelMaster) looksSane() bool {
+	return !sm.instance.sdown && sm.instance.role == "master" && sm.failoverState == sentinelFailoverNone
+}
+
+// selectReplica picks the replica to promote: one that is up and recently
+// answered, preferring a lower replica-priority, then the most data, with
+// replicas that have a priority of 0 never promoted.
+func (sm *SentinelMaster) selectReplica() *SentinelInstance {
+	infoValidity := 3 * sentinelInfoPeriod
+	if sm.instance.sdown {
+		infoValidity = 5 * sentinelPingPeriod
+	}
+
+	candidates := []*SentinelInstance{}
+	for _, replica := range sm.replicas {
+		if replica.sdown || replica.noReplySince() > 5*sentinelPingPeriod || replica.priority == 0 {
+			continue
+		}
+
+		if replica.infoRefresh.IsZero() || time.Since(replica.infoRefresh) > infoValidity {
+			continue
+		}
+
+		candidates = append(candidates, replica)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		if a.offset != b.offset {
+			return a.offset > b.offset
+		}
+
+		return a.Address() < b.Address()
+	})
+
+	return candidates[0]
+}
+
+func (sm *SentinelMaster) status() string {
+	if sm.odown {
+		return "odown"
+	} else if sm.instance.sdown {
+		return "sdown"
+	}
+
+	return "ok"
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// startTestSentinel runs a sentinel monitoring the master at masterPort as
+// mymaster until the test ends.
+func startTestSentinel(t *testing.T, masterPort string, downAfter time.Duration) *Sentinel {
+	t.Helper()
+
+	port := freePort(t)
+	sentinel := NewSentinel(port)
+	err := sentinel.Monitor("mymaster", "127.0.0.1", masterPort, 1, downAfter, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to monitor master: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go sentinel.Run(ctx)
+
+	waitForListening(t, port)
+	return sentinel
+}
+
+func masterDown(sentinel *Sentinel) bool {
+	sentinel.lock.Lock()
+	defer sentinel.lock.Unlock()
+
+	return sentinel.masters["mymaster"].instance.sdown
+}
+
+func TestSentinelKeepsAnsweringMasterUp(t *testing.T) {
+	masterPort := startTestServer(t, "")
+	sentinel := startTestSentinel(t, masterPort, 500*time.Millisecond)
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if masterDown(sentinel) {
+			t.Fatalf("master answering every PING was marked down")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSentinelMarksSilentMasterDown(t *testing.T) {
+	sentinel := startTestSentinel(t, freePort(t), 500*time.Millisecond)
+
+	waitFor(t, "the master to be marked down", func() bool {
+		return masterDown(sentinel)
+	})
+}
+
+func TestSentinelIsNotItsOwnPeer(t *testing.T) {
+	sentinel := NewSentinel("26379")
+	sentinel.Monitor("mymaster", "127.0.0.1", "6379", 2, time.Second, time.Minute)
+
+	for _, host := range []string{"127.0.0.1", "localhost", "0.0.0.0"} {
+		sentinel.AddSentinel("mymaster", host, "26379")
+	}
+	sentinel.AddSentinel("mymaster", "127.0.0.1", "26380")
+
+	master := sentinel.masters["mymaster"]
+	if len(master.sentinels) != 1 {
+		t.Fatalf("sentinel knows %d peers, want 1", len(master.sentinels))
+	}
+
+	// Listed under an address it can't tell is its own, the sentinel finds
+	// out from its own hello message.
+	sentinel.addInstance(master, master.sentinels, sentinelInstanceSentinel, "192.0.2.1", "26379")
+	err := sentinel.ProcessHello("192.0.2.1,26379," + sentinel.runid + ",0,mymaster,127.0.0.1,6379,0")
+	if err != nil {
+		t.Fatalf("failed to process hello: %v", err)
+	}
+
+	if len(master.sentinels) != 1 {
+		t.Fatalf("sentinel knows %d peers after its own hello, want 1", len(master.sentinels))
+	}
+}
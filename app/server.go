@@ -5,28 +5,123 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const ReplicationID = "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-
 func main() {
 	fmt.Println("Redis Server Started")
 
 	port := flag.String("port", "6379", "port for redis server to use")
-	replicaOf := flag.String("replicaof", "", "port that this server is a replica of")
+	replicaOf := flag.String("replicaof", "", "\"host port\" of the master this server is a replica of")
 	dir := flag.String("dir", "./", "directory of RDB file")
-	dbfilename := flag.String("dbfilename", "./", "file name of RDB file")
+	dbfilename := flag.String("dbfilename", "dump.rdb", "file name of RDB file")
+	config := map[string]*string{
+		"save":                        flag.String("save", "", "RDB save points as pairs of seconds and changes, none by default"),
+		"appendonly":                  flag.String("appendonly", "no", "whether to log writes to an append only file"),
+		"appendfsync":                 flag.String("appendfsync", "everysec", "how often to fsync the append only file: always, everysec or no"),
+		"appendfilename":              flag.String("appendfilename", "appendonly.aof", "base name of append only files"),
+		"appenddirname":               flag.String("appenddirname", "appendonlydir", "directory of append only files, relative to dir"),
+		"auto-aof-rewrite-percentage": flag.String("auto-aof-rewrite-percentage", "100", "growth of the append only file over its last rewritten size that triggers a rewrite"),
+		"auto-aof-rewrite-min-size":   flag.String("auto-aof-rewrite-min-size", "64mb", "minimum append only file size that triggers a rewrite"),
+		"replica-read-only":           flag.String("replica-read-only", "yes", "whether a replica rejects writes from its clients"),
+		"replica-serve-stale-data":    flag.String("replica-serve-stale-data", "yes", "whether a replica answers queries while its link to the master is down"),
+		"min-replicas-to-write":       flag.String("min-replicas-to-write", "0", "number of good replicas a master needs to accept writes"),
+		"min-replicas-max-lag":        flag.String("min-replicas-max-lag", "10", "seconds since a replica's last acknowledgement for it to count as good"),
+		"repl-diskless-sync":          flag.String("repl-diskless-sync", "no", "whether full syncs stream the RDB straight to replicas instead of saving it to disk"),
+		"repl-diskless-sync-delay":    flag.String("repl-diskless-sync-delay", "5", "seconds to wait for more replicas to share a diskless sync"),
+		"repl-diskless-load":          flag.String("repl-diskless-load", "disabled", "how a replica loads a full sync: disabled, on-empty-db or swapdb"),
+		"repl-backlog-size":           flag.String("repl-backlog-size", "1mb", "size of the backlog kept for partial resynchronization of replicas"),
+		"replica-priority":            flag.String("replica-priority", "100", "preference for promoting this replica in a failover, lower first and 0 for never"),
+		"notify-keyspace-events":      flag.String("notify-keyspace-events", "", "classes of keyspace events published to pub/sub channels"),
+	}
+
+	sentinel := flag.Bool("sentinel", false, "run as a sentinel monitoring masters instead of serving data")
+	monitors := []string{}
+	flag.Func("sentinel-monitor", "\"name host port quorum\" of a master for the sentinel to monitor, may be repeated", func(val string) error {
+		monitors = append(monitors, val)
+		return nil
+	})
+	knownSentinels := []string{}
+	flag.Func("sentinel-known-sentinel", "\"name host port\" of another sentinel monitoring the named master, may be repeated", func(val string) error {
+		knownSentinels = append(knownSentinels, val)
+		return nil
+	})
+	downAfter := flag.Int("sentinel-down-after-milliseconds", 30000, "milliseconds without a reply for the sentinel to consider an instance down")
+	failoverTimeout := flag.Int("sentinel-failover-timeout", 180000, "milliseconds the sentinel allows a failover to take")
 	flag.Parse()
 
+	if *sentinel {
+		portSet := false
+		flag.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "port" })
+		if !portSet {
+			*port = defaultSentinelPort
+		}
+
+		err := runSentinel(*port, monitors, knownSentinels, time.Duration(*downAfter)*time.Millisecond, time.Duration(*failoverTimeout)*time.Millisecond)
+		if err != nil {
+			fmt.Printf("failed to run sentinel: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	rs, err := NewRedisServer(*port, *replicaOf, *dir, *dbfilename)
 	if err != nil {
 		fmt.Printf("failed to create redis server: %v\n", err)
 		os.Exit(1)
 	}
 
+	for name, val := range config {
+		err = rs.ConfigSet(name, *val)
+		if err != nil {
+			fmt.Printf("failed to configure redis server: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	err = rs.Run(context.Background())
 	if err != nil {
 		fmt.Printf("failed to create redis server: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+func runSentinel(port string, monitors []string, knownSentinels []string, downAfter time.Duration, failoverTimeout time.Duration) error {
+	if downAfter <= 0 {
+		return fmt.Errorf("sentinel-down-after-milliseconds must be positive, got %d", downAfter.Milliseconds())
+	}
+
+	sentinel := NewSentinel(port)
+	for _, monitor := range monitors {
+		args := strings.Fields(monitor)
+		if len(args) != 4 {
+			return fmt.Errorf("sentinel-monitor must be \"name host port quorum\", got %q", monitor)
+		}
+
+		quorum, err := strconv.Atoi(args[3])
+		if err != nil || quorum <= 0 {
+			return fmt.Errorf("quorum must be a positive integer, got %s", args[3])
+		}
+
+		err = sentinel.Monitor(args[0], args[1], args[2], quorum, downAfter, failoverTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, known := range knownSentinels {
+		args := strings.Fields(known)
+		if len(args) != 3 {
+			return fmt.Errorf("sentinel-known-sentinel must be \"name host port\", got %q", known)
+		}
+
+		err := sentinel.AddSentinel(args[0], args[1], args[2])
+		if err != nil {
+			return err
+		}
+	}
+
+	return sentinel.Run(context.Background())
+}
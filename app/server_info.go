@@ -1,6 +1,6 @@
 package main
 
 type ServerInfo struct {
-	Replication ReplicationInfo
-	Persistence PersistenceInfo
+	Replication *ReplicationInfo
+	Persistence *PersistenceInfo
 }
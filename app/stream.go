@@ -4,3 +4,12 @@ type StreamLog struct {
 	Name    string
 	Entries []StreamEntry
 }
+
+func (log StreamLog) LastID() StreamID {
+	if len(log.Entries) == 0 {
+		return StreamID{}
+	}
+
+	id, _ := ParseStreamID(log.Entries[len(log.Entries)-1].Id)
+	return id
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func ParseStreamID(id string) (StreamID, error) {
+	msStr, seqStr, _ := strings.Cut(id, "-")
+
+	ms, err := strconv.ParseUint(msStr, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream ID %s: %v", id, err)
+	}
+
+	seq := uint64(0)
+	if seqStr != "" {
+		seq, err = strconv.ParseUint(seqStr, 10, 64)
+		if err != nil {
+			return StreamID{}, fmt.Errorf("invalid stream ID %s: %v", id, err)
+		}
+	}
+
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// NextStreamID resolves the ID given to XADD, which may leave the whole ID or
+// just its sequence to be generated with "*", into the ID of a new entry
+// following last.
+func NextStreamID(spec string, last StreamID, now time.Time) (StreamID, error) {
+	if spec == "*" {
+		ms := uint64(now.UnixMilli())
+		if ms <= last.Ms {
+			return StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+		}
+
+		return StreamID{Ms: ms, Seq: 0}, nil
+	}
+
+	msStr, seqStr, _ := strings.Cut(spec, "-")
+	id := StreamID{}
+	if seqStr == "*" {
+		ms, err := strconv.ParseUint(msStr, 10, 64)
+		if err != nil {
+			return StreamID{}, fmt.Errorf("Invalid stream ID specified as stream command argument")
+		}
+
+		id = StreamID{Ms: ms, Seq: 0}
+		if ms == last.Ms {
+			id.Seq = last.Seq + 1
+		} else if ms == 0 {
+			id.Seq = 1
+		}
+	} else {
+		parsed, err := ParseStreamID(spec)
+		if err != nil {
+			return StreamID{}, fmt.Errorf("Invalid stream ID specified as stream command argument")
+		}
+		id = parsed
+	}
+
+	if id == (StreamID{}) {
+		return StreamID{}, fmt.Errorf("The ID specified in XADD must be greater than 0-0")
+	} else if !last.Less(id) {
+		return StreamID{}, fmt.Errorf("The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import "sync"
+
+const subscriberQueueSize = 4096
+
+// Subscriber writes the replies of a connection using pub/sub, and the
+// messages published to it, from a queue. Messages from other connections are
+// then written in order with its replies without waiting on the connection,
+// and a subscriber that falls too far behind is disconnected.
+type Subscriber struct {
+	conn          *RESPConnection
+	channels      map[string]bool // guarded by the PubSub lock
+	patterns      map[string]bool // guarded by the PubSub lock
+	shardChannels map[string]bool // guarded by the PubSub lock
+	queue         chan RESPValue
+	push          bool
+	closed        bool
+	done          chan struct{}
+	lock          sync.Mutex
+}
+
+func NewSubscriber(conn *RESPConnection) *Subscriber {
+	sub := &Subscriber{
+		conn:          conn,
+		channels:      map[string]bool{},
+		patterns:      map[string]bool{},
+		shardChannels: map[string]bool{},
+		queue:         make(chan RESPValue, subscriberQueueSize),
+		done:          make(chan struct{}),
+	}
+	go sub.run()
+	return sub
+}
+
+func (sub *Subscriber) run() {
+	defer close(sub.done)
+
+	for val := range sub.queue {
+		err := sub.conn.RespondRESP(val)
+		if err != nil {
+			sub.conn.Close()
+			break
+		}
+	}
+
+	for range sub.queue {
+	}
+}
+
+// Push queues values to be written, disconnecting the subscriber if its queue
+// is full.
+func (sub *Subscriber) Push(vals ...RESPValue) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	for _, val := range vals {
+		if sub.closed {
+			return
+		}
+
+		select {
+		case sub.queue <- val:
+		default:
+			sub.closed = true
+			close(sub.queue)
+			sub.conn.Close()
+		}
+	}
+}
+
+// Send queues a pub/sub message or confirmation, as a push once the client
+// switched to RESP3.
+func (sub *Subscriber) Send(val RESPValue) {
+	sub.lock.Lock()
+	push := sub.push
+	sub.lock.Unlock()
+
+	if push {
+		val.Type = Push
+	}
+	sub.Push(val)
+}
+
+func (sub *Subscriber) SetPush(push bool) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	sub.push = push
+}
+
+// Count returns how many channels and patterns the subscriber is subscribed
+// to. The PubSub lock must be held.
+func (sub *Subscriber) Count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// ShardCount returns how many shard channels the subscriber is subscribed to.
+// The PubSub lock must be held.
+func (sub *Subscriber) ShardCount() int {
+	return len(sub.shardChannels)
+}
+
+// Close waits until everything queued is written.
+func (sub *Subscriber) Close() {
+	sub.lock.Lock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.queue)
+	}
+	sub.lock.Unlock()
+
+	<-sub.done
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultTrackingTableMaxKeys = 1000000
+
+// TrackingClient is how a client asked for its cached keys to be invalidated
+// with CLIENT TRACKING. A client with redirect set has its invalidations sent
+// to the client with that id instead.
+type TrackingClient struct {
+	id       int
+	redirect int
+	bcast    bool
+	optIn    bool
+	optOut   bool
+	noLoop   bool
+	prefixes []string
+}
+
+// TrackingTable remembers which clients may have cached which keys, and sends
+// them invalidation messages once the keys are modified. Clients in BCAST mode
+// are sent invalidations for every key matching one of their prefixes
+// instead. Once more than maxKeys keys are remembered, keys are forgotten,
+// and invalidated, to make room.
+type TrackingTable struct {
+	clients  map[int]*TrackingClient
+	keys     map[string]map[int]bool
+	prefixes map[string]map[int]bool
+	maxKeys  int
+	registry *ClientRegistry
+	lock     sync.Mutex
+}
+
+func NewTrackingTable(registry *ClientRegistry) *TrackingTable {
+	return &TrackingTable{
+		clients:  map[int]*TrackingClient{},
+		keys:     map[string]map[int]bool{},
+		prefixes: map[string]map[int]bool{},
+		maxKeys:  defaultTrackingTableMaxKeys,
+		registry: registry,
+	}
+}
+
+// Client returns the tracking settings of the client with id, if it has
+// tracking on.
+func (tt *TrackingTable) Client(id int) (TrackingClient, bool) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	client, ok := tt.clients[id]
+	if !ok {
+		return TrackingClient{}, false
+	}
+
+	return *client, true
+}
+
+// Enable turns tracking on for client.id, replacing its previous settings.
+func (tt *TrackingTable) Enable(client TrackingClient) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	tt.disable(client.id)
+	tt.clients[client.id] = &client
+	if !client.bcast {
+		return
+	}
+
+	if len(client.prefixes) == 0 {
+		client.prefixes = []string{""}
+	}
+
+	for _, prefix := range client.prefixes {
+		if tt.prefixes[prefix] == nil {
+			tt.prefixes[prefix] = map[int]bool{}
+		}
+		tt.prefixes[prefix][client.id] = true
+	}
+}
+
+// Disable turns tracking off for the client with id. The keys it read are
+// forgotten lazily, as they are invalidated.
+func (tt *TrackingTable) Disable(id int) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	tt.disable(id)
+}
+
+func (tt *TrackingTable) disable(id int) {
+	client, ok := tt.clients[id]
+	if !ok {
+		return
+	}
+
+	for _, prefix := range client.prefixes {
+		delete(tt.prefixes[prefix], id)
+		if len(tt.prefixes[prefix]) == 0 {
+			delete(tt.prefixes, prefix)
+		}
+	}
+
+	delete(tt.clients, id)
+}
+
+// Remember records that the client with id read keys.
+func (tt *TrackingTable) Remember(id int, keys []string) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	client, ok := tt.clients[id]
+	if !ok || client.bcast {
+		return
+	}
+
+	for _, key := range keys {
+		if tt.keys[key] == nil {
+			tt.keys[key] = map[int]bool{}
+		}
+		tt.keys[key][id] = true
+	}
+
+	tt.limit()
+}
+
+// limit forgets keys, in no particular order, until no more than maxKeys are
+// remembered. A maxKeys of 0 means no limit.
+func (tt *TrackingTable) limit() {
+	if tt.maxKeys == 0 {
+		return
+	}
+
+	for key := range tt.keys {
+		if len(tt.keys) <= tt.maxKeys {
+			return
+		}
+
+		tt.invalidate([]string{key}, 0)
+	}
+}
+
+// Invalidate sends invalidations for keys, which were modified by the client
+// with id writer, or by the server itself when writer is 0.
+func (tt *TrackingTable) Invalidate(keys []string, writer int) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	tt.invalidate(keys, writer)
+}
+
+func (tt *TrackingTable) invalidate(keys []string, writer int) {
+	invalidated := map[int]map[string]bool{}
+	add := func(id int, key string) {
+		client, ok := tt.clients[id]
+		if !ok || (client.noLoop && id == writer) {
+			return
+		}
+
+		if invalidated[id] == nil {
+			invalidated[id] = map[string]bool{}
+		}
+		invalidated[id][key] = true
+	}
+
+	for _, key := range keys {
+		for id := range tt.keys[key] {
+			if client, ok := tt.clients[id]; ok && !client.bcast {
+				add(id, key)
+			}
+		}
+		delete(tt.keys, key)
+
+		for prefix, ids := range tt.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				for id := range ids {
+					add(id, key)
+				}
+			}
+		}
+	}
+
+	for id, keys := range invalidated {
+		sorted := []string{}
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		tt.send(tt.clients[id], sorted)
+	}
+}
+
+// InvalidateAll tells every client with tracking on that all of its cached
+// keys are invalid, for when the dataset is flushed.
+func (tt *TrackingTable) InvalidateAll() {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	tt.keys = map[string]map[int]bool{}
+	for _, client := range tt.clients {
+		tt.send(client, nil)
+	}
+}
+
+// send delivers an invalidation of keys, or of everything when keys is nil,
+// to the client or to the client it redirects to.
+func (tt *TrackingTable) send(client *TrackingClient, keys []string) {
+	target := client.id
+	if client.redirect != 0 {
+		target = client.redirect
+	}
+
+	rc, ok := tt.registry.Get(target)
+	if ok {
+		rc.pushInvalidation(keys)
+		return
+	}
+
+	if self, ok := tt.registry.Get(client.id); ok {
+		self.pushRedirectBroken(client.redirect)
+	}
+}
+
+func (tt *TrackingTable) MaxKeys() int {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	return tt.maxKeys
+}
+
+func (tt *TrackingTable) SetMaxKeys(maxKeys int) {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	tt.maxKeys = maxKeys
+	tt.limit()
+}
@@ -0,0 +1,48 @@
+package main
+
+// QueuedCommand is a command queued in a transaction, checked against the
+// command table when it was queued.
+type QueuedCommand struct {
+	resp      RESPValue
+	parseInfo ParseInfo
+	command   Command
+}
+
+// Transaction holds the commands a client queued after MULTI until EXEC runs
+// them all at once. A command that could not be queued aborts the
+// transaction, and EXEC then discards it.
+type Transaction struct {
+	commands []QueuedCommand
+	aborted  bool
+}
+
+func NewTransaction() *Transaction {
+	return &Transaction{commands: []QueuedCommand{}}
+}
+
+func (t *Transaction) Queue(resp RESPValue, parseInfo ParseInfo, command Command) {
+	t.commands = append(t.commands, QueuedCommand{resp: resp, parseInfo: parseInfo, command: command})
+}
+
+// Writes reports whether any command queued may write.
+func (t *Transaction) Writes() bool {
+	for _, queued := range t.commands {
+		if queued.command.HasFlag(commandWrite) || queued.command.HasFlag(commandMayReplicate) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Transaction) Size() int {
+	return len(t.commands)
+}
+
+func (t *Transaction) Abort() {
+	t.aborted = true
+}
+
+func (t *Transaction) Aborted() bool {
+	return t.aborted
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// readUntilStopped reads key until stop is closed, failing the test if it ever
+// sees the value partial.
+func readUntilStopped(t *testing.T, client *testClient, key string, partial string, stop chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		resp, err := client.try("GET", key)
+		if err != nil {
+			t.Errorf("GET failed: %v", err)
+			return
+		}
+
+		if isBulk(resp, partial) {
+			t.Errorf("GET saw %s half applied", key)
+			return
+		}
+	}
+}
+
+func TestExecIsIsolatedFromReaders(t *testing.T) {
+	port := startTestServer(t, "")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go readUntilStopped(t, newTestClient(t, port), "counter", "partial", stop, &wg)
+	}
+
+	client := newTestClient(t, port)
+	for i := 0; i < 5; i++ {
+		commands := [][]string{{"MULTI"}}
+		for j := 0; j < 5000; j++ {
+			commands = append(commands, []string{"SET", "counter", "partial"})
+		}
+		commands = append(commands, []string{"SET", "counter", strconv.Itoa(i)}, []string{"EXEC"})
+
+		replies := client.pipeline(commands...)
+		results, ok := replies[len(replies)-1].Value.([]RESPValue)
+		if !ok || len(results) != 5001 {
+			t.Fatalf("EXEC returned %v", replies[len(replies)-1])
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	if resp := client.do("GET", "counter"); !isBulk(resp, "4") {
+		t.Fatalf("GET returned %v after the last transaction", resp)
+	}
+}
@@ -21,3 +21,101 @@ func Max(a int, b int) int {
 func WriteLine(sb *strings.Builder, line string) {
 	sb.WriteString(line + "\n")
 }
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// globMatch reports whether s matches a glob-style pattern as Redis matches
+// them: * matches any run of characters, ? any single one, [...] one of a
+// set or range (negated with ^), and \ escapes the character after it.
+//
+// On a mismatch it only retries from the last * seen, letting it match one
+// more character, which is enough since anything matched before that * stays
+// matched. This keeps patterns with many *s from taking exponential time.
+func globMatch(pattern string, s string) bool {
+	p, i := 0, 0
+	star, starI := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starI = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				end, matched := globClass(pattern[p+1:], s[i])
+				if matched {
+					p += 1 + end
+					i++
+					continue
+				}
+			default:
+				c, width := pattern[p], 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, width = pattern[p+1], 2
+				}
+				if s[i] == c {
+					p += width
+					i++
+					continue
+				}
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+		p = star + 1
+		starI++
+		i = starI
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// globClass matches c against the set at the start of pattern, which follows
+// its opening [, and returns the length of the set up to its closing ].
+func globClass(pattern string, c byte) (int, bool) {
+	i := 0
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	matched := false
+	for ; i < len(pattern) && pattern[i] != ']'; i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+	}
+
+	if i < len(pattern) {
+		i++
+	}
+
+	return i, matched != negate
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		s       string
+		matched bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"news.*", "news.sport", true},
+		{"news.*", "weather", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*a*a", "banana", true},
+		{"**x", "x", true},
+		{"x*", "", false},
+	} {
+		if matched := globMatch(test.pattern, test.s); matched != test.matched {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", test.pattern, test.s, matched, test.matched)
+		}
+	}
+}
+
+func TestGlobMatchManyStars(t *testing.T) {
+	pattern := strings.Repeat("*a", 12) + "b"
+	s := strings.Repeat("a", 40)
+
+	start := time.Now()
+	if globMatch(pattern, s) {
+		t.Fatalf("%q matched %q", pattern, s)
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("matching took %v", elapsed)
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+// WatchedKeys are the keys a client watches with WATCH. It is marked dirty
+// once any of them is modified, which makes the client's next EXEC fail. Its
+// fields are guarded by the database's lock.
+type WatchedKeys struct {
+	// keys records for each key whether it had already expired when watched,
+	// since a key expiring afterwards counts as a modification.
+	keys  map[string]bool
+	dirty bool
+}
+
+func NewWatchedKeys() *WatchedKeys {
+	return &WatchedKeys{keys: map[string]bool{}}
+}